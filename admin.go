@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// 启动时已用于初始化监听、存储、队列等组件的字段，热加载时忽略
+var restartOnlyFields = []string{
+	"port", "admin_token", "upstream_key_file", "encode_concurrency", "download_concurrency",
+	"audit", "upstream_audit", "cloud_events", "queue", "storage", "stale_cache", "webp", "geoip",
+}
+
+var (
+	configPath string
+	reloadMu   sync.Mutex
+)
+
+// 热加载结果
+type reloadResult struct {
+	Changed []string `json:"changed"`
+	Ignored []string `json:"ignored,omitempty"`
+	Note    string   `json:"note,omitempty"`
+}
+
+// 仅持有 admin_token 的请求可访问管理接口；未配置 admin_token 时管理接口关闭
+func withAdminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := currentConfig().AdminToken
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// 重新读取配置文件并整体替换当前配置，返回发生变化的字段
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	if configPath == "" {
+		log.Printf("[REJECT] 启动时未指定 -config，没有可重新加载的配置文件")
+		writeError(w, http.StatusConflict, "no config file to reload")
+		return
+	}
+	next, err := loadConfig(configPath)
+	if err != nil {
+		log.Printf("[ERROR] 重新加载配置失败: %v", err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	res := applyReload(currentConfig(), next)
+	config.Store(next)
+	log.Printf("[RELOAD] 配置已重新加载，变更: %v，忽略: %v", res.Changed, res.Ignored)
+	go prewarmUpstream(next)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// 比较新旧配置的顶层字段；需要重启才能生效的字段沿用旧值
+func applyReload(prev, next *Config) reloadResult {
+	res := reloadResult{Changed: []string{}}
+	pv, nv := reflect.ValueOf(prev).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < pv.NumField(); i++ {
+		name, _, _ := strings.Cut(pv.Type().Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" || reflect.DeepEqual(pv.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		if slices.Contains(restartOnlyFields, name) {
+			nv.Field(i).Set(pv.Field(i))
+			res.Ignored = append(res.Ignored, name)
+			continue
+		}
+		res.Changed = append(res.Changed, name)
+	}
+	if len(res.Ignored) > 0 {
+		res.Note = "ignored fields require a restart to take effect"
+	}
+	return res
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReloadAppliesModelMap(t *testing.T) {
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, nil)
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig := func(port string, maxN int) {
+		t.Helper()
+		data := fmt.Sprintf(`{"port":%q,"admin_token":"admin","upstream_url":%q,"models":{"m":{"max_n":%d}}}`, port, up.URL, maxN)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("3000", 0)
+	useConfig(t, nil)
+	loaded, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Store(loaded)
+	swapGlobal(t, &configPath, path)
+
+	body := `{"prompt":"x","model":"m","n":2}`
+	if w := postGenerations(t, body); w.Code != http.StatusOK {
+		t.Fatalf("重载前 status = %d, want 200", w.Code)
+	}
+
+	writeConfig("4000", 1)
+	r := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+	r.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	withAdminAuth(handleReload)(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("reload status = %d, body = %s", w.Code, w.Body)
+	}
+	var res reloadResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(res.Changed, []string{"models"}) || !slices.Equal(res.Ignored, []string{"port"}) {
+		t.Errorf("changed = %v, ignored = %v", res.Changed, res.Ignored)
+	}
+	if currentConfig().Port != loaded.Port {
+		t.Errorf("port 需重启生效，热加载后应沿用旧值，实际 %s", currentConfig().Port)
+	}
+
+	if w := postGenerations(t, body); w.Code != http.StatusBadRequest {
+		t.Errorf("重载后 max_n=1，n=2 的 status = %d, want 400", w.Code)
+	}
+}
+
+func TestReloadRequiresAdminToken(t *testing.T) {
+	useConfig(t, func(c *Config) { c.AdminToken = "admin" })
+	r := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+	r.Header.Set("Authorization", "Bearer wrong")
+	w := httptest.NewRecorder()
+	withAdminAuth(handleReload)(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", w.Code)
+	}
+}
+
+func TestReloadWithoutConfigFile(t *testing.T) {
+	prev := useConfig(t, func(c *Config) { c.AdminToken = "admin" })
+	swapGlobal(t, &configPath, "")
+	r := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+	r.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	withAdminAuth(handleReload)(w, r)
+	if w.Code != http.StatusConflict {
+		t.Errorf("未指定配置文件时 reload status = %d, want 409", w.Code)
+	}
+	if currentConfig() != prev {
+		t.Error("未指定配置文件时不应替换当前配置")
+	}
+
+	// 启动时未指定配置文件，默认配置同样经过 prepare
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.blockedHashes == nil {
+		t.Error("loadConfig(\"\") 应计算派生字段")
+	}
+}
+
+func TestStatsSnapshot(t *testing.T) {
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{"images":[{"url":"https://cdn.example/a.png"},{"url":"https://cdn.example/b.png"}]}`))
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.AdminToken = "admin"
+	})
+	swapGlobal(t, &stats, &requestStats{started: time.Now()})
+
+	for range 3 {
+		if w := postGenerations(t, `{"prompt":"x","n":2}`); w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+		}
+	}
+	if w := postGenerations(t, `{"prompt":"x","n":"two"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("无效参数 status = %d, want 400", w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+	r.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	withAdminAuth(handleStats)(w, r)
+	var snap StatsSnapshot
+	if err := json.Unmarshal(w.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("响应不是有效 JSON: %s", w.Body)
+	}
+	if snap.Requests != 4 || snap.Successes != 3 || snap.Failures != 1 || snap.Images != 6 {
+		t.Errorf("snapshot = %+v, want 4 个请求、3 个成功、1 个失败、6 张图片", snap)
+	}
+	// 3 个成功请求各至少 20ms，平均不少于 15ms
+	if snap.AvgLatencyMs < 15 {
+		t.Errorf("avg_latency_ms = %v, want >= 15", snap.AvgLatencyMs)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 审计事件结构版本，字段变更时递增
+const auditSchemaVersion = 1
+
+// 审计事件，与运行日志分离输出，字段保持稳定
+type AuditEvent struct {
+	SchemaVersion int    `json:"schema_version"`
+	Time          string `json:"time"`
+	Event         string `json:"event"`
+	Method        string `json:"method"`
+	Path          string `json:"path"`
+	KeyHash       string `json:"key_hash,omitempty"`
+	User          string `json:"user,omitempty"`
+	Model         string `json:"model,omitempty"`
+	PromptHash    string `json:"prompt_hash,omitempty"`
+	Prompt        string `json:"prompt,omitempty"`
+	N             int    `json:"n"`
+	Status        int    `json:"status"`
+	Outcome       string `json:"outcome"`
+	Images        int    `json:"images"`
+	DurationMs    int64  `json:"duration_ms"`
+}
+
+type auditLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+var audit *auditLogger
+
+// 根据配置打开审计输出，未启用时返回 nil
+func newAuditLogger(cfg AuditConfig) (*auditLogger, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	switch cfg.Output {
+	case "", "stdout":
+		return &auditLogger{w: os.Stdout}, nil
+	case "stderr":
+		return &auditLogger{w: os.Stderr}, nil
+	case "syslog":
+		w, err := openAuditSyslog()
+		if err != nil {
+			return nil, err
+		}
+		return &auditLogger{w: w}, nil
+	default:
+		f, err := os.OpenFile(cfg.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("打开审计日志失败: %w", err)
+		}
+		return &auditLogger{w: f}, nil
+	}
+}
+
+// 上游调用审计事件，用于与服务商账单对账；对冲请求的每次调用各记一条
+type UpstreamCallEvent struct {
+	SchemaVersion int    `json:"schema_version"`
+	Time          string `json:"time"`
+	Event         string `json:"event"`
+	KeyHash       string `json:"key_hash,omitempty"`
+	Model         string `json:"model,omitempty"`
+	N             int    `json:"n"`
+	Size          string `json:"size,omitempty"`
+	Status        int    `json:"status"`
+	Error         string `json:"error,omitempty"`
+	LatencyMs     int64  `json:"latency_ms"`
+}
+
+var upstreamAudit *auditLogger
+
+// 每个事件一行 JSON
+func (a *auditLogger) Emit(ev AuditEvent) {
+	if a == nil {
+		return
+	}
+	ev.SchemaVersion = auditSchemaVersion
+	if ev.Time == "" {
+		ev.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	a.write(ev)
+}
+
+func (a *auditLogger) EmitUpstream(ev UpstreamCallEvent) {
+	if a == nil {
+		return
+	}
+	ev.SchemaVersion = auditSchemaVersion
+	ev.Event = "upstream.call"
+	if ev.Time == "" {
+		ev.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	a.write(ev)
+}
+
+func (a *auditLogger) write(ev interface{}) {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("[ERROR] 审计事件序列化失败: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.w.Write(line); err != nil {
+		log.Printf("[ERROR] 审计事件写入失败: %v", err)
+	}
+}
+
+// 对 API Key 取哈希，避免明文落盘
+func hashAPIKey(authorization string) string {
+	key := strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	if key == "" {
+		return ""
+	}
+	return shortHash(key)
+}
+
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func auditOutcome(status int) string {
+	switch {
+	case status >= 200 && status < 400:
+		return "success"
+	case status >= 400 && status < 500:
+		return "rejected"
+	default:
+		return "error"
+	}
+}
+
+// 记录写出的状态码与响应体字节数，供审计、指标等收尾逻辑使用
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// 透传 Flush，保证流式响应可以及时下发
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s *statusRecorder) Status() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
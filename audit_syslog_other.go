@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func openAuditSyslog() (io.Writer, error) {
+	return nil, errors.New("当前平台不支持 syslog 审计输出")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestAuditEventForGeneration(t *testing.T) {
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, nil)
+	for _, includePrompt := range []bool{false, true} {
+		useConfig(t, func(c *Config) {
+			c.UpstreamURL = up.URL
+			c.Audit.IncludePrompt = includePrompt
+		})
+		var buf syncBuffer
+		swapGlobal(t, &audit, &auditLogger{w: &buf})
+
+		w := postGenerations(t, `{"model":"flux","prompt":"a red fox","n":1,"user":"u-1"}`, "Authorization", "Bearer sk-secret")
+		if w.Code != 200 {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+
+		line := strings.TrimSpace(buf.String())
+		if strings.Count(line, "\n") != 0 {
+			t.Fatalf("应只输出一行审计事件: %q", line)
+		}
+		if strings.Contains(line, "sk-secret") {
+			t.Fatalf("审计事件包含明文 Key: %s", line)
+		}
+		if strings.Contains(line, "a red fox") != includePrompt {
+			t.Fatalf("include_prompt=%v 时原始提示词记录不符: %s", includePrompt, line)
+		}
+		var ev map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("审计事件不是 JSON: %v", err)
+		}
+		want := map[string]interface{}{
+			"schema_version": float64(auditSchemaVersion),
+			"event":          "image.generation",
+			"method":         "POST",
+			"path":           "/v1/images/generations",
+			"key_hash":       hashAPIKey("Bearer sk-secret"),
+			"user":           "u-1",
+			"model":          "flux",
+			"prompt_hash":    shortHash("a red fox"),
+			"n":              float64(1),
+			"status":         float64(200),
+			"outcome":        "success",
+			"images":         float64(1),
+		}
+		for k, v := range want {
+			if ev[k] != v {
+				t.Errorf("%s = %v, want %v", k, ev[k], v)
+			}
+		}
+		if _, ok := ev["time"].(string); !ok {
+			t.Errorf("缺少 time 字段: %s", line)
+		}
+	}
+}
+
+func TestAuditOutcome(t *testing.T) {
+	for status, want := range map[int]string{200: "success", 304: "success", 400: "rejected", 429: "rejected", 502: "error"} {
+		if got := auditOutcome(status); got != want {
+			t.Errorf("auditOutcome(%d) = %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestUpstreamAuditPerCall(t *testing.T) {
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, nil)
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	var buf syncBuffer
+	swapGlobal(t, &upstreamAudit, &auditLogger{w: &buf})
+	swapGlobal(t, &audit, nil)
+
+	// seeds 数组中每个 seed 单独调用一次上游
+	w := postGenerations(t, `{"model":"flux","prompt":"x","seeds":[1,2],"image_size":"512x512"}`, "Authorization", "Bearer sk-secret")
+	if w.Code != 200 {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("上游审计条目数 = %d, want 2:\n%s", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		var ev map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("上游审计条目不是 JSON: %v", err)
+		}
+		want := map[string]interface{}{
+			"schema_version": float64(auditSchemaVersion),
+			"event":          "upstream.call",
+			"key_hash":       hashAPIKey("Bearer sk-secret"),
+			"model":          "flux",
+			"n":              float64(1),
+			"size":           "512x512",
+			"status":         float64(200),
+		}
+		for k, v := range want {
+			if ev[k] != v {
+				t.Errorf("%s = %v, want %v", k, ev[k], v)
+			}
+		}
+		if _, ok := ev["latency_ms"].(float64); !ok {
+			t.Errorf("缺少 latency_ms: %s", line)
+		}
+		if _, ok := ev["time"].(string); !ok {
+			t.Errorf("缺少 time: %s", line)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 带固定 seed 的确定性请求的最近一次成功响应，上游故障时可作为过期结果返回
+type resultCache struct {
+	mu      sync.Mutex
+	max     int
+	ttl     time.Duration
+	order   *list.List // 最近使用的在前
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key         string
+	body        []byte
+	contentType string
+	stored      time.Time
+}
+
+var staleCache *resultCache
+
+func newResultCache(cfg StaleCacheConfig) *resultCache {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &resultCache{
+		max:     max(cfg.MaxEntries, 1),
+		ttl:     time.Duration(cfg.TTL),
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// 仅带 seed 的请求结果可复现，其余请求返回空 key 表示不缓存。
+// key 同时包含客户端 Key 哈希、租户与 Accept，不同客户端之间不会互相拿到对方的结果
+func resultCacheKey(reqBody map[string]interface{}, forwarded []byte, bare bool, keyHash, tenant, accept string) string {
+	if _, ok := reqBody["seed"]; !ok {
+		return ""
+	}
+	return contentETag(forwarded, []byte(strconv.FormatBool(bare)), []byte(keyHash), []byte(tenant), []byte(accept))
+}
+
+func (c *resultCache) Put(key, contentType string, body []byte) {
+	if c == nil || key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, body: body, contentType: contentType, stored: time.Now()})
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *resultCache) Get(key string) (*cacheEntry, bool) {
+	if c == nil || key == "" {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if c.ttl > 0 && time.Since(entry.stored) > c.ttl {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry, true
+}
+
+// 客户端以 Cache-Control: no-cache / no-store 或 X-No-Cache 要求全新生成；honor_no_cache 关闭时忽略。
+// stale_cache 只在上游失败时读取，因此对它而言跳过缓存即不写入、也不以过期结果兜底
+func bypassCache(cfg *Config, r *http.Request) bool {
+	if !cfg.HonorNoCache {
+		return false
+	}
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "no-cache", "no-store":
+			return true
+		}
+	}
+	v := strings.ToLower(strings.TrimSpace(r.Header.Get("X-No-Cache")))
+	return v != "" && v != "0" && v != "false"
+}
+
+// 上游失败时尝试返回缓存结果，并以 X-Cache: stale 与 Age 标明
+func serveStale(w http.ResponseWriter, key string) bool {
+	entry, ok := staleCache.Get(key)
+	if !ok {
+		return false
+	}
+	age := time.Since(entry.stored)
+	log.Printf("[WARN] 上游失败，返回 %v 前缓存的结果", age.Round(time.Second))
+	w.Header().Set("Content-Type", entry.contentType)
+	w.Header().Set("X-Cache", "stale")
+	w.Header().Set("Age", fmt.Sprint(int(age.Seconds())))
+	w.Write(entry.body)
+	return true
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// down 为 true 时返回 500 的上游，并开启过期结果缓存
+func newFlakyUpstream(t *testing.T, imageURL string, down *atomic.Bool) {
+	t.Helper()
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, `{"error":"boom"}`, http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"images":[{"url":"` + imageURL + `"}],"seed":42}`))
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.StaleCache.Enabled = true
+	})
+	swapGlobal(t, &staleCache, newResultCache(StaleCacheConfig{Enabled: true, MaxEntries: 10, TTL: Duration(time.Hour)}))
+}
+
+func TestStaleResultServedOnUpstreamFailure(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	var down atomic.Bool
+	newFlakyUpstream(t, img.URL+"/a.png", &down)
+	body := `{"prompt":"x","seed":42,"response_format":"b64_json"}`
+
+	fresh := postGenerations(t, body)
+	if fresh.Code != http.StatusOK || fresh.Header().Get("X-Cache") != "" {
+		t.Fatalf("首次请求 status = %d, X-Cache = %q", fresh.Code, fresh.Header().Get("X-Cache"))
+	}
+
+	down.Store(true)
+	w := postGenerations(t, body)
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "stale" {
+		t.Fatalf("上游失败时 status = %d, X-Cache = %q, want 200 stale", w.Code, w.Header().Get("X-Cache"))
+	}
+	if w.Header().Get("Age") == "" {
+		t.Error("过期结果应带 Age 头")
+	}
+	if got, want := decodeB64Response(t, w).Data[0].B64JSON, decodeB64Response(t, fresh).Data[0].B64JSON; got != want {
+		t.Error("返回的不是缓存的结果")
+	}
+
+	// 没有 seed 的请求不可复现，不使用缓存
+	if w := postGenerations(t, `{"prompt":"x","response_format":"b64_json"}`); w.Code == http.StatusOK {
+		t.Errorf("无 seed 的请求在上游失败时 status = %d, want 错误", w.Code)
+	}
+}
+
+func TestStaleResultScopedToPlainJSONAndKey(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	var down atomic.Bool
+	newFlakyUpstream(t, img.URL+"/a.png", &down)
+	body := `{"prompt":"x","seed":42,"response_format":"b64_json"}`
+
+	if w := postGenerations(t, body, "Authorization", "Bearer sk-a"); w.Code != http.StatusOK {
+		t.Fatalf("首次请求 status = %d", w.Code)
+	}
+	down.Store(true)
+	if w := postGenerations(t, body, "Authorization", "Bearer sk-a"); w.Header().Get("X-Cache") != "stale" {
+		t.Fatalf("同一 Key 的相同请求应返回过期结果，X-Cache = %q", w.Header().Get("X-Cache"))
+	}
+
+	// 转发的请求体相同，但响应格式或客户端不同，都不能拿到缓存的 JSON
+	cases := map[string]struct {
+		body string
+		hdr  []string
+	}{
+		"zip":    {body, []string{"Authorization", "Bearer sk-a", "Accept", "application/zip"}},
+		"SSE":    {`{"prompt":"x","seed":42,"response_format":"b64_json","stream":true}`, []string{"Authorization", "Bearer sk-a"}},
+		"其他 Key": {body, []string{"Authorization", "Bearer sk-b"}},
+		"无 Key":  {body, nil},
+		"其他租户":   {body, []string{"Authorization", "Bearer sk-a", "X-Tenant-Id", "tenant-b"}},
+	}
+	for name, c := range cases {
+		w := postGenerations(t, c.body, c.hdr...)
+		if w.Header().Get("X-Cache") == "stale" || w.Code == http.StatusOK {
+			t.Errorf("%s: status = %d, X-Cache = %q, 不应返回其他请求的过期结果", name, w.Code, w.Header().Get("X-Cache"))
+		}
+	}
+}
+
+func TestNoCacheSkipsCachedResults(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	var down atomic.Bool
+	newFlakyUpstream(t, img.URL+"/a.png", &down)
+	body := `{"prompt":"x","seed":42,"response_format":"b64_json"}`
+
+	if w := postGenerations(t, body); w.Code != http.StatusOK {
+		t.Fatalf("首次请求 status = %d", w.Code)
+	}
+	// 已有缓存条目时，no-cache 请求在上游失败时不以过期结果兜底
+	down.Store(true)
+	for _, hdr := range [][]string{{"Cache-Control", "no-cache"}, {"Cache-Control", "max-age=0, no-store"}, {"X-No-Cache", "1"}} {
+		w := postGenerations(t, body, hdr...)
+		if w.Code == http.StatusOK || w.Header().Get("X-Cache") != "" {
+			t.Errorf("%s: %s 时 status = %d, X-Cache = %q, want 上游错误", hdr[0], hdr[1], w.Code, w.Header().Get("X-Cache"))
+		}
+	}
+	if w := postGenerations(t, body); w.Header().Get("X-Cache") != "stale" {
+		t.Errorf("普通请求仍应返回过期结果，X-Cache = %q", w.Header().Get("X-Cache"))
+	}
+
+	// no-cache 的成功响应不写入缓存
+	down.Store(false)
+	other := `{"prompt":"y","seed":7,"response_format":"b64_json"}`
+	postGenerations(t, other, "Cache-Control", "no-cache")
+	down.Store(true)
+	if w := postGenerations(t, other); w.Header().Get("X-Cache") == "stale" {
+		t.Error("no-cache 请求的结果不应写入缓存")
+	}
+}
+
+func TestNoCacheSkipsDuplicateReplay(t *testing.T) {
+	var calls atomic.Int32
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"images":[{"url":"https://cdn.example/a.png"}]}`))
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.DuplicateSubmissions.Enabled = true
+	})
+	swapGlobal(t, &submissions, &submissionGroup{calls: make(map[string]*submission)})
+	h := withDuplicateDetection(handleGenerations)
+	body := `{"prompt":"x"}`
+
+	serveGenerations(t, h, body)
+	if w := serveGenerations(t, h, body); w.Header().Get("X-Duplicate-Submission") != "true" || calls.Load() != 1 {
+		t.Fatalf("窗口内的相同请求应复用结果，上游调用 %d 次", calls.Load())
+	}
+	// 刚完成的结果存在时，no-cache 请求仍调用上游
+	w := serveGenerations(t, h, body, "Cache-Control", "no-cache")
+	if w.Header().Get("X-Duplicate-Submission") != "" || calls.Load() != 2 {
+		t.Errorf("no-cache 请求不应复用结果，X-Duplicate-Submission = %q，上游调用 %d 次", w.Header().Get("X-Duplicate-Submission"), calls.Load())
+	}
+
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.DuplicateSubmissions.Enabled = true
+		c.HonorNoCache = false
+	})
+	if w := serveGenerations(t, h, body, "Cache-Control", "no-cache"); w.Header().Get("X-Duplicate-Submission") != "true" {
+		t.Error("honor_no_cache 关闭时应忽略 no-cache")
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+)
+
+// 模型是否能直接返回指定的 response_format；未配置 response_formats 时视为都支持
+func (m ModelConfig) supportsFormat(format string) bool {
+	return len(m.ResponseFormats) == 0 || slices.Contains(m.ResponseFormats, format)
+}
+
+// 按模型支持的返回格式改写转发给上游的 response_format。
+// 仅返回 URL 的模型由代理下载后转为 base64；仅返回 base64 的模型在需要 URL 时由存储模式提供，
+// needURL 表示本次响应必须给出上游 URL（URL 模式且未开启存储），此时无法转换，返回错误
+func applyModelFormats(m ModelConfig, model string, reqBody map[string]interface{}, requested string, needURL bool) error {
+	if requested == "" {
+		requested = "url"
+	}
+	if m.supportsFormat(requested) {
+		return nil
+	}
+	if requested == "url" && needURL {
+		return fmt.Errorf("model %s only returns b64_json; url responses require storage to be enabled", model)
+	}
+	upstream := m.ResponseFormats[0]
+	log.Printf("[FORMAT] 模型 %s 不支持 %s，向上游请求 %s 并由代理转换", model, requested, upstream)
+	reqBody["response_format"] = upstream
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+)
+
+func TestURLOnlyModelConvertedToB64(t *testing.T) {
+	png := testPNG(t, 2, 2)
+	img := newImageServer(t, png)
+	var forwarded map[string]interface{}
+	up := newUpstream(t, []string{img.URL + "/a.png"}, &forwarded)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Models = map[string]ModelConfig{"url-only": {ResponseFormats: []string{"url"}}}
+	})
+
+	resp := decodeB64Response(t, postGenerations(t, `{"model":"url-only","prompt":"x","response_format":"b64_json"}`))
+	if forwarded["response_format"] != "url" {
+		t.Errorf("上游收到的 response_format = %v, want url", forwarded["response_format"])
+	}
+	if len(resp.Data) != 1 || resp.Data[0].B64JSON != base64.StdEncoding.EncodeToString(png) {
+		t.Errorf("仅返回 URL 的模型应由代理转为 base64: %+v", resp.Data)
+	}
+}
+
+func TestB64OnlyModelURLRejectedWithoutStorage(t *testing.T) {
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Models = map[string]ModelConfig{"b64-only": {ResponseFormats: []string{"b64_json"}}}
+	})
+	if w := postGenerations(t, `{"model":"b64-only","prompt":"x"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("无法转换时 status = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// b64 响应的末尾字段，在全部图片写出后追加
+type chunkedTail struct {
+	FailedIndices []int            `json:"failed_indices,omitempty"`
+	Timings       *ResponseTimings `json:"timings,omitempty"`
+	Usage         *ResponseUsage   `json:"usage,omitempty"`
+	Error         string           `json:"error,omitempty"` // 超过最长时长中止时给出，data 不完整
+}
+
+// 以分块传输逐步写出 b64 响应：先写 {"created":...,"data":[，每张图片的全部变体完成后按顺序写出该条目并刷新，
+// 最后补上 failed_indices 等末尾字段。条目写出后即释放，不在内存中保留整个响应。
+// 响应头已提前发送，失败数量改由 X-Failed-Images 尾部字段给出。bare 为 true 时只输出 data 数组。
+// 超过 max_stream_duration 时不再写出后续条目，直接闭合 JSON 并在末尾给出 error
+func streamB64Chunked(parent context.Context, w http.ResponseWriter, cfg *Config, images []Image, created int64, bare bool, tail func(slots [][]imageSlot) chunkedTail) int {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Trailer", "X-Failed-Images")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	write := func(b []byte) {
+		if _, err := w.Write(b); err != nil {
+			log.Printf("[ERROR] 写入分块响应失败: %v", err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if bare {
+		write([]byte("["))
+	} else {
+		createdKey, _ := json.Marshal(outputFieldName(cfg.OutputFieldRenames, "created"))
+		dataKey, _ := json.Marshal(outputFieldName(cfg.OutputFieldRenames, "data"))
+		write([]byte("{" + string(createdKey) + ":" + strconv.FormatInt(created, 10) + "," + string(dataKey) + ":["))
+	}
+
+	// 各图片尚未完成的变体数；条目须按顺序写出，先完成的后位条目暂存到前面的条目写出为止
+	remaining := make([]int, len(images))
+	pending := make([][]imageSlot, len(images))
+	for i, img := range images {
+		remaining[i] = len(img.variantList())
+		pending[i] = make([]imageSlot, remaining[i])
+	}
+	ctx, cancel := streamContext(parent, cfg)
+	defer cancel()
+	refs := newImageRefs(cfg)
+	next, written := 0, 0
+	var failed []int
+	slots := fetchImagesStream(ctx, cfg, images, func(ref slotRef, slot imageSlot) {
+		pending[ref.index][ref.variant] = slot
+		remaining[ref.index]--
+		if ctx.Err() != nil {
+			return
+		}
+		for next < len(images) && remaining[next] == 0 {
+			item := buildDataItem(cfg, images[next], pending[next], next)
+			refs.apply(&item, pending[next], next)
+			pending[next] = nil
+			if item.Error != "" {
+				failed = append(failed, next)
+			} else {
+				written++
+			}
+			data, _ := json.Marshal(item)
+			data = renameOutputFields(cfg.OutputFieldRenames, data)
+			if next > 0 {
+				data = append([]byte(","), data...)
+			}
+			write(data)
+			next++
+		}
+	})
+
+	if parent.Err() != nil {
+		log.Printf("[WARN] 客户端已断开，分块响应提前结束")
+		return written
+	}
+	expired := streamExpired(ctx)
+	if expired {
+		log.Printf("[WARN] 分块响应超过最长时长 %v，已中止", time.Duration(cfg.MaxStreamDuration))
+	}
+	if bare {
+		write([]byte("]\n"))
+	} else {
+		t := tail(slots)
+		if expired {
+			t.Error = errStreamDurationExceeded.Error()
+		}
+		if cfg.IncludeFailedIndices {
+			t.FailedIndices = failed
+		}
+		rest, _ := json.Marshal(t)
+		rest = renameOutputFields(cfg.OutputFieldRenames, rest)
+		if len(rest) > 2 {
+			rest[0] = ','
+			write(append([]byte("]"), append(rest, '\n')...))
+		} else {
+			write([]byte("]}\n"))
+		}
+	}
+	w.Header().Set("X-Failed-Images", strconv.Itoa(len(failed)))
+	log.Printf("[SUCCESS] 分块返回数据 - 图片数量: %d，失败: %d", len(images), len(failed))
+	return written
+}
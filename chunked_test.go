@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestChunkedB64Response(t *testing.T) {
+	png := testPNG(t, 2, 2)
+	img := newImageServer(t, png)
+	missing := newUpstreamFunc(t, http.NotFound)
+	up := newUpstream(t, []string{img.URL + "/0.png", missing.URL + "/1.png", img.URL + "/2.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.ChunkedB64Response = true
+		c.IncludeFailedIndices = true
+	})
+	// 需要真实的 HTTP 连接才能检查分块传输与 trailer
+	proxy := httptest.NewServer(http.HandlerFunc(handleGenerations))
+	defer proxy.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, proxy.URL, strings.NewReader(`{"prompt":"x","n":3,"response_format":"b64_json"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if !slices.Equal(resp.TransferEncoding, []string{"chunked"}) {
+		t.Errorf("Transfer-Encoding = %v, want chunked", resp.TransferEncoding)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out OpenAIResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		t.Fatalf("拼接后的响应不是有效 JSON: %v\n%s", err, body)
+	}
+	if out.Created == 0 || len(out.Data) != 3 {
+		t.Fatalf("响应结构不完整: created=%d, data=%d", out.Created, len(out.Data))
+	}
+	want := base64.StdEncoding.EncodeToString(png)
+	if out.Data[0].B64JSON != want || out.Data[2].B64JSON != want || out.Data[1].Error == "" {
+		t.Errorf("条目顺序或内容不正确: %+v", out.Data)
+	}
+	if !slices.Equal(out.FailedIndices, []int{1}) {
+		t.Errorf("failed_indices = %v, want [1]", out.FailedIndices)
+	}
+	if got := resp.Trailer.Get("X-Failed-Images"); got != "1" {
+		t.Errorf("X-Failed-Images trailer = %q, want 1", got)
+	}
+}
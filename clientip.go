@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// 获取客户端 IP：仅当直连方属于可信代理时才采信 X-Forwarded-For，
+// 并从右向左跳过可信代理，取第一个不可信的地址
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !ipTrusted(remote, trusted) {
+		return remote
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !ipTrusted(hop, trusted) {
+			return hop
+		}
+	}
+	return remote
+}
+
+func ipTrusted(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析可信代理列表，支持单个 IP 与 CIDR
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// 单 IP 并发请求计数
+type ipLimiter struct {
+	mu     sync.Mutex
+	active map[string]int
+}
+
+var perIPLimiter = &ipLimiter{active: make(map[string]int)}
+
+func (l *ipLimiter) acquire(ip string, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[ip] >= limit {
+		return false
+	}
+	l.active[ip]++
+	return true
+}
+
+func (l *ipLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[ip] <= 1 {
+		delete(l.active, ip)
+		return
+	}
+	l.active[ip]--
+}
+
+// 单 IP 并发限制中间件，超出返回 429
+func withIPLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := currentConfig()
+		if cfg.MaxConcurrentPerIP <= 0 {
+			next(w, r)
+			return
+		}
+		ip := clientIP(r, cfg.trustedProxies)
+		if !perIPLimiter.acquire(ip, cfg.MaxConcurrentPerIP) {
+			log.Printf("[LIMIT] IP %s 并发请求超过上限 %d", ip, cfg.MaxConcurrentPerIP)
+			http.Error(w, `{"error":"Too many concurrent requests from this IP"}`, http.StatusTooManyRequests)
+			return
+		}
+		defer perIPLimiter.release(ip)
+		next(w, r)
+	}
+}
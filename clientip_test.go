@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func requestFrom(remote, xff string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/v1/images/generations", strings.NewReader(`{}`))
+	r.RemoteAddr = remote
+	if xff != "" {
+		r.Header.Set("X-Forwarded-For", xff)
+	}
+	return r
+}
+
+func TestClientIPTrustsOnlyConfiguredProxies(t *testing.T) {
+	trusted, err := parseCIDRs([]string{"10.0.0.1", "192.168.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct{ remote, xff, want string }{
+		{"10.0.0.1:1234", "1.1.1.1", "1.1.1.1"},
+		{"10.0.0.1:1234", "6.6.6.6, 1.1.1.1, 192.168.1.2", "1.1.1.1"},
+		{"8.8.8.8:1234", "1.1.1.1", "8.8.8.8"}, // 非可信代理伪造的 X-Forwarded-For 不采信
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+	}
+	for _, c := range cases {
+		if got := clientIP(requestFrom(c.remote, c.xff), trusted); got != c.want {
+			t.Errorf("clientIP(%s, %q) = %s, want %s", c.remote, c.xff, got, c.want)
+		}
+	}
+}
+
+func TestPerIPConcurrencyLimit(t *testing.T) {
+	useConfig(t, func(c *Config) {
+		c.MaxConcurrentPerIP = 1
+		c.TrustedProxies = []string{"10.0.0.1"}
+	})
+	swapGlobal(t, &perIPLimiter, &ipLimiter{active: make(map[string]int)})
+
+	entered, unblock := make(chan struct{}), make(chan struct{})
+	h := withIPLimit(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Block") != "" {
+			close(entered)
+			<-unblock
+		}
+	})
+
+	first := make(chan int)
+	go func() {
+		r := requestFrom("10.0.0.1:1", "1.1.1.1")
+		r.Header.Set("X-Block", "1")
+		w := httptest.NewRecorder()
+		h(w, r)
+		first <- w.Code
+	}()
+	<-entered
+
+	w := httptest.NewRecorder()
+	h(w, requestFrom("10.0.0.1:2", "1.1.1.1"))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("同一 IP 超出并发上限 status = %d, want 429", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h(w, requestFrom("10.0.0.1:3", "2.2.2.2"))
+	if w.Code != http.StatusOK {
+		t.Errorf("其他 IP 不应受影响，status = %d", w.Code)
+	}
+
+	close(unblock)
+	if code := <-first; code != http.StatusOK {
+		t.Errorf("首个请求 status = %d", code)
+	}
+	w = httptest.NewRecorder()
+	h(w, requestFrom("10.0.0.1:4", "1.1.1.1"))
+	if w.Code != http.StatusOK {
+		t.Errorf("首个请求结束后应释放名额，status = %d", w.Code)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+var errNoProfile = errors.New("no color profile")
+
+var jpegICCMarker = []byte("ICC_PROFILE\x00")
+
+// PNG 中描述色彩空间的块，标记为 sRGB 时一并移除
+var pngColorSpaceChunks = map[string]bool{
+	"iCCP": true,
+	"gAMA": true,
+	"cHRM": true,
+}
+
+// 移除图片内嵌的非 sRGB 色彩配置并标记为 sRGB：PNG 删除 iCCP/gAMA/cHRM 后写入 sRGB 块，
+// JPEG 删除 APP2 中的 ICC 配置（不带配置的 JPEG 按惯例视为 sRGB）。像素值不做转换。
+// 没有色彩信息时返回 errNoProfile，已是 sRGB 时原样返回
+func normalizeColorProfile(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, pngHeader):
+		return normalizePNGProfile(data)
+	case bytes.HasPrefix(data, jpegSOI):
+		return normalizeJPEGProfile(data)
+	}
+	return data, errNoProfile
+}
+
+func normalizePNGProfile(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, pngHeader...)
+	found := false
+	pos := len(pngHeader)
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return data, errors.New("png: truncated chunk header")
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return data, errors.New("png: truncated chunk")
+		}
+		typ := string(data[pos+4 : pos+8])
+		switch {
+		case typ == "sRGB":
+			return data, nil
+		case typ == "iCCP":
+			// 配置名称带 sRGB 的视为已是 sRGB
+			name, _, _ := bytes.Cut(data[pos+8:end-4], []byte{0})
+			if strings.Contains(strings.ToLower(string(name)), "srgb") {
+				return data, nil
+			}
+			found = true
+		case pngColorSpaceChunks[typ]:
+			found = true
+		case typ == "IDAT":
+			// 色彩块只出现在图像数据之前
+			if !found {
+				return data, errNoProfile
+			}
+			return insertPNGSRGBChunk(append(out, data[pos:]...)), nil
+		default:
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	return data, errors.New("png: missing IDAT")
+}
+
+func normalizeJPEGProfile(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, jpegSOI...)
+	found := false
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return data, errors.New("jpeg: invalid marker")
+		}
+		marker := data[pos+1]
+		if marker == 0xDA {
+			if !found {
+				return data, errNoProfile
+			}
+			return append(out, data[pos:]...), nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return data, errors.New("jpeg: truncated segment")
+		}
+		payload := data[pos+4 : end]
+		if marker != 0xE2 || !bytes.HasPrefix(payload, jpegICCMarker) {
+			out = append(out, data[pos:end]...)
+			pos = end
+			continue
+		}
+		// 序号为 1 的分段带有 ICC 头：CMYK 等配置去掉后无法正确显示，只处理 RGB/灰度；设备型号为 sRGB 的视为已是 sRGB
+		if header := payload[len(jpegICCMarker):]; len(header) >= 2+56 && header[0] == 1 {
+			header = header[2:]
+			if cs := string(header[16:20]); cs != "RGB " && cs != "GRAY" {
+				return data, fmt.Errorf("jpeg: unsupported icc color space %q", cs)
+			}
+			if string(header[52:56]) == "sRGB" {
+				return data, nil
+			}
+		}
+		found = true
+		pos = end
+	}
+	return data, errors.New("jpeg: missing start of scan")
+}
+
+// 为重新编码后的 PNG 补写 sRGB 块；重新编码的 JPEG 不带 ICC 配置，无需处理
+func embedSRGBMarker(data []byte) []byte {
+	if bytes.HasPrefix(data, pngHeader) {
+		return insertPNGSRGBChunk(data)
+	}
+	return data
+}
+
+// 在 IHDR 之后插入 sRGB 块（渲染意图：感知）
+func insertPNGSRGBChunk(data []byte) []byte {
+	ihdrEnd := len(pngHeader) + 12 + int(binary.BigEndian.Uint32(data[len(pngHeader):]))
+	chunk := pngChunk("sRGB", []byte{0})
+	out := make([]byte, 0, len(data)+len(chunk))
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+func pngChunk(typ string, payload []byte) []byte {
+	chunk := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	copy(chunk[4:], typ)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"slices"
+	"testing"
+)
+
+// 只含 ICC 头的配置，colorSpace 为 "RGB "、"CMYK" 等，model 为设备型号（sRGB 配置为 "sRGB"）
+func testICCProfile(colorSpace, model string) []byte {
+	p := make([]byte, 128)
+	binary.BigEndian.PutUint32(p, 128)
+	copy(p[16:], colorSpace)
+	copy(p[36:], "acsp")
+	copy(p[52:], model)
+	return p
+}
+
+func withPNGICC(t *testing.T, data []byte, name string, profile []byte) []byte {
+	t.Helper()
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(profile)
+	zw.Close()
+	return withPNGChunk(data, "iCCP", append([]byte(name+"\x00\x00"), z.Bytes()...))
+}
+
+func withJPEGICC(data, profile []byte) []byte {
+	return withJPEGSegment(data, 0xE2, append([]byte("ICC_PROFILE\x00\x01\x01"), profile...))
+}
+
+// JPEG 扫描开始前的 ICC 段数量
+func jpegICCSegments(data []byte) int {
+	n := 0
+	for pos := 2; pos+4 <= len(data) && data[pos+1] != 0xDA; {
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if data[pos+1] == 0xE2 && bytes.HasPrefix(data[pos+4:end], []byte("ICC_PROFILE\x00")) {
+			n++
+		}
+		pos = end
+	}
+	return n
+}
+
+// PNG 在 IDAT 之前的块类型
+func pngColorChunks(data []byte) []string {
+	var types []string
+	for pos := len(pngHeader); pos+12 <= len(data); {
+		typ := string(data[pos+4 : pos+8])
+		if typ == "IDAT" {
+			break
+		}
+		if typ == "sRGB" || typ == "iCCP" || typ == "gAMA" {
+			types = append(types, typ)
+		}
+		pos += 12 + int(binary.BigEndian.Uint32(data[pos:]))
+	}
+	return types
+}
+
+func TestNormalizeColorProfileSurvivesReencoding(t *testing.T) {
+	adobe := testICCProfile("RGB ", "ADBE")
+	cases := []struct {
+		name   string
+		mutate func(*Config)
+	}{
+		{"仅归一化", func(c *Config) {}},
+		{"归一化后缩小", func(c *Config) { c.MaxImageDimension = 8 }},
+		{"归一化后增强", func(c *Config) { c.Enhance.Sharpen = 1 }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := useConfig(t, func(cfg *Config) {
+				cfg.NormalizeColorProfile = true
+				c.mutate(cfg)
+			})
+
+			out := processImage(cfg, withPNGICC(t, testPNG(t, 16, 16), "Adobe RGB", adobe), 0)
+			if got := pngColorChunks(out); len(got) != 1 || got[0] != "sRGB" {
+				t.Errorf("PNG 输出的色彩块 = %v, want [sRGB]", got)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(out)); err != nil {
+				t.Fatalf("PNG 输出无法解码: %v", err)
+			}
+
+			out = processImage(cfg, withJPEGICC(testJPEG(t, 16, 16), adobe), 0)
+			if n := jpegICCSegments(out); n != 0 {
+				t.Errorf("JPEG 输出仍带有 %d 个 ICC 段，应去掉配置按 sRGB 处理", n)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(out)); err != nil {
+				t.Fatalf("JPEG 输出无法解码: %v", err)
+			}
+		})
+	}
+}
+
+func TestNormalizeColorProfileSkipsWithoutProfile(t *testing.T) {
+	src := testPNG(t, 4, 4)
+	out, err := normalizeColorProfile(src)
+	if err != errNoProfile || !bytes.Equal(out, src) {
+		t.Fatalf("无色彩信息时应原样返回 errNoProfile，err = %v", err)
+	}
+	cfg := useConfig(t, func(c *Config) { c.NormalizeColorProfile = true })
+	if out := processImage(cfg, src, 0); !bytes.Equal(out, src) {
+		t.Error("无色彩信息的图片不应被修改")
+	}
+}
+
+func TestNormalizeColorProfileKeepsSRGBAndCMYK(t *testing.T) {
+	gamma := withPNGChunk(testPNG(t, 4, 4), "gAMA", binary.BigEndian.AppendUint32(nil, 100000))
+	out, err := normalizeColorProfile(gamma)
+	if err != nil || !slices.Equal(pngColorChunks(out), []string{"sRGB"}) {
+		t.Errorf("gAMA 应替换为 sRGB 块: %v, err = %v", pngColorChunks(out), err)
+	}
+
+	cases := map[string][]byte{
+		"PNG sRGB 配置":   withPNGICC(t, testPNG(t, 4, 4), "sRGB IEC61966-2.1", testICCProfile("RGB ", "sRGB")),
+		"PNG 已有 sRGB 块": withPNGChunk(withPNGChunk(testPNG(t, 4, 4), "gAMA", binary.BigEndian.AppendUint32(nil, 45455)), "sRGB", []byte{0}),
+		"JPEG sRGB 配置":  withJPEGICC(testJPEG(t, 4, 4), testICCProfile("RGB ", "sRGB")),
+	}
+	for name, src := range cases {
+		if out, err := normalizeColorProfile(src); err != nil || !bytes.Equal(out, src) {
+			t.Errorf("%s: 已是 sRGB 时应原样返回，err = %v", name, err)
+		}
+	}
+
+	// CMYK 配置去掉后颜色完全错误，保留原图
+	cmyk := withJPEGICC(testJPEG(t, 4, 4), testICCProfile("CMYK", "ADBE"))
+	if out, err := normalizeColorProfile(cmyk); err == nil || !bytes.Equal(out, cmyk) {
+		t.Errorf("CMYK 配置应报错并原样返回，err = %v", err)
+	}
+}
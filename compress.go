@@ -0,0 +1,111 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// 按压缩级别复用 gzip.Writer，避免每个响应重新分配压缩缓冲区
+var gzipPools sync.Map
+
+func getGzipWriter(w io.Writer, level int) *gzip.Writer {
+	pool, _ := gzipPools.LoadOrStore(level, &sync.Pool{New: func() any {
+		gz, _ := gzip.NewWriterLevel(io.Discard, level)
+		return gz
+	}})
+	gz := pool.(*sync.Pool).Get().(*gzip.Writer)
+	gz.Reset(w)
+	return gz
+}
+
+func putGzipWriter(gz *gzip.Writer, level int) {
+	if pool, ok := gzipPools.Load(level); ok {
+		pool.(*sync.Pool).Put(gz)
+	}
+}
+
+// 客户端声明支持 gzip 时压缩响应体；图片字节、已编码或无响应体的响应不压缩
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	level       int
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	h := g.Header()
+	h.Add("Vary", "Accept-Encoding")
+	if code != http.StatusNoContent && code != http.StatusNotModified && code >= 200 &&
+		h.Get("Content-Encoding") == "" && !strings.HasPrefix(h.Get("Content-Type"), "image/") {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = getGzipWriter(g.ResponseWriter, g.level)
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz == nil {
+		return g.ResponseWriter.Write(b)
+	}
+	return g.gz.Write(b)
+}
+
+// SSE 与分块响应需要及时下发已压缩的数据
+func (g *gzipResponseWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+func (g *gzipResponseWriter) close() {
+	if g.gz != nil {
+		g.gz.Close()
+		putGzipWriter(g.gz, g.level)
+		g.gz = nil
+	}
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(coding), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// 响应压缩中间件，压缩级别取 compression.level
+func withCompression(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cc := currentConfig().Compression
+		if !cc.Enabled || !acceptsGzip(r) {
+			next(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, level: cc.Level}
+		defer gw.close()
+		next(gw, r)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 以给定压缩级别经 withCompression 写出 payload，返回响应
+func compressed(t *testing.T, level int, contentType, payload, acceptEncoding string) *httptest.ResponseRecorder {
+	t.Helper()
+	useConfig(t, func(c *Config) { c.Compression = CompressionConfig{Enabled: true, Level: level} })
+	r := httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+	w := httptest.NewRecorder()
+	withCompression(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		io.WriteString(w, payload)
+	})(w, r)
+	return w
+}
+
+func TestCompressionLevel(t *testing.T) {
+	// 有一定冗余但不是简单重复的内容，不同级别的压缩率才有差别
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel"}
+	var sb strings.Builder
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&sb, "%s%d ", words[rng.Intn(len(words))], rng.Intn(100))
+	}
+	payload := sb.String()
+
+	sizes := map[int]int{}
+	for _, level := range []int{gzip.BestSpeed, gzip.BestCompression} {
+		w := compressed(t, level, "application/json", payload, "gzip, br")
+		if w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("level %d: 未压缩响应", level)
+		}
+		sizes[level] = w.Body.Len()
+		gz, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(gz)
+		if string(got) != payload {
+			t.Fatalf("level %d: 解压后内容不一致", level)
+		}
+	}
+	if sizes[gzip.BestCompression] >= sizes[gzip.BestSpeed] {
+		t.Errorf("level 9 压缩后 %d bytes，应小于 level 1 的 %d bytes", sizes[gzip.BestCompression], sizes[gzip.BestSpeed])
+	}
+
+	if w := compressed(t, 6, "image/png", payload, "gzip"); w.Header().Get("Content-Encoding") != "" {
+		t.Error("图片字节不应压缩")
+	}
+	if w := compressed(t, 6, "application/json", payload, "gzip;q=0"); w.Header().Get("Content-Encoding") != "" {
+		t.Error("gzip;q=0 时不应压缩")
+	}
+
+	cfg := defaultConfig()
+	cfg.Compression.Level = 10
+	if err := cfg.prepare(); err == nil {
+		t.Error("compression.level 超出 1~9 时应报错")
+	}
+}
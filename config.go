@@ -0,0 +1,742 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// 服务配置，可通过 -config 指定 JSON 文件覆盖默认值
+type Config struct {
+	Port            string   `json:"port"`
+	UpstreamURL     string   `json:"upstream_url"`
+	UpstreamTimeout Duration `json:"upstream_timeout"`
+	// 收到退出信号后等待处理中的请求与异步任务完成的最长时间
+	ShutdownGracePeriod Duration `json:"shutdown_grace_period"`
+	// 上游对生成请求返回 3xx 时的处理
+	UpstreamRedirects UpstreamRedirectConfig `json:"upstream_redirects"`
+	// 经 Unix 域套接字连接上游（或本地边车代理）时的 socket 路径，为空时使用 TCP
+	UpstreamSocket string `json:"upstream_socket"`
+	// 上游响应中图片数组的点分路径（如 output.images），为空时使用顶层 images / data
+	UpstreamImagesPath string `json:"upstream_images_path"`
+	// 上游接收图片尺寸的字段名（如 image_size、imageSize、resolution），客户端的 size 改写为该字段
+	UpstreamSizeField string `json:"upstream_size_field"`
+	// 每个上游请求都附带的固定字段，客户端提供的同名字段优先
+	RequestTemplate map[string]interface{} `json:"request_template"`
+	// 响应头 X-Effective-Params 给出最终转发给上游的参数（去掉密钥）
+	ExposeEffectiveParams bool `json:"expose_effective_params"`
+	// 上游密钥文件，修改后自动生效；内容为 Key 本身或 {"api_key": "...", "upstream_url": "..."}
+	UpstreamKeyFile string `json:"upstream_key_file"`
+	// 管理接口（/admin/*）的访问令牌，为空时管理接口关闭
+	AdminToken string `json:"admin_token"`
+	// 可信反向代理（IP 或 CIDR），仅对其采信 X-Forwarded-For
+	TrustedProxies []string `json:"trusted_proxies"`
+	// 单个客户端 IP 同时处理的请求数上限，0 表示不限
+	MaxConcurrentPerIP int `json:"max_concurrent_per_ip"`
+	// 按接口（generations / edits / jobs / files）的每客户端请求频率限制
+	RateLimits map[string]RateLimitConfig `json:"rate_limits"`
+	// 按 API Key 单独限流的客户端（Key 哈希，与审计日志的 key_hash 相同），其余请求按客户端 IP 限流
+	RateLimitKeyHashes []string `json:"rate_limit_key_hashes"`
+	// 是否允许 {"warmup": true} 预热请求
+	AllowWarmup bool `json:"allow_warmup"`
+	// 启动及热加载后预先建立的上游连接数，0 表示不预热
+	PrewarmConnections int `json:"prewarm_connections"`
+	// 允许通过 Accept: image/* 直接返回图片字节
+	RawImageOutput bool `json:"raw_image_output"`
+	// 上游响应体的最大字节数，超过时请求失败，0 表示不限制
+	MaxUpstreamResponseBytes int64 `json:"max_upstream_response_bytes"`
+	// b64 模式下载软截止时间，0 表示等待全部完成
+	DownloadSoftDeadline Duration `json:"download_soft_deadline"`
+	// 单张图片下载失败后的重试次数
+	DownloadRetries int `json:"download_retries"`
+	// 单个请求所有重试（下载重试、Key 轮换、对冲请求）合计的上限，0 表示不限制
+	RetryBudget int `json:"retry_budget"`
+	// 下载与转发日志中保留原值的 URL 查询参数，其余参数值脱敏
+	LogURLQueryAllowlist []string `json:"log_url_query_allowlist"`
+	// 图片经过转换（后处理或 output_format）时同时返回下载得到的原图
+	IncludeOriginal bool `json:"include_original"`
+	// b64 响应中附带 failed_indices 字段
+	IncludeFailedIndices bool `json:"include_failed_indices"`
+	// b64 响应中部分图片失败时的状态码：200 或 206
+	PartialSuccessStatus int `json:"partial_success_status"`
+	// 成功响应 JSON 的字段改名，如 {"b64_json": "b64", "url": "image_url"}，兼容旧客户端
+	OutputFieldRenames map[string]string `json:"output_field_renames"`
+	// b64 响应的 created 使用上游返回的生成时间，上游未给出时为当前时间
+	UseUpstreamCreated bool `json:"use_upstream_created"`
+	// Accept: application/zip 时 ZIP 内的目录结构：flat 或 nested（按提示词分子目录）
+	ZipLayout string `json:"zip_layout"`
+	// SSE、multipart/mixed 与分块 b64 响应的最长时长，超过后写出终止事件并结束，0 表示不限制
+	MaxStreamDuration Duration `json:"max_stream_duration"`
+	// b64 响应以分块传输逐张写出，不再等待全部图片完成
+	ChunkedB64Response bool `json:"chunked_b64_response"`
+	// b64_json 超过该长度时拆分为 b64_json_chunks，0 表示不拆分
+	B64ChunkSize int `json:"b64_chunk_size"`
+	// b64 响应中图片的 base64 总字节数超过该值时改为存储并返回 URL（需开启存储），0 表示不切换
+	B64StorageThreshold int64 `json:"b64_storage_threshold"`
+	// 客户端 stream: true 的处理：sse 由代理以 SSE 返回，strip 去掉后按普通请求处理，forward 原样转发
+	StreamMode string `json:"stream_mode"`
+	// b64 响应附带 timings 耗时明细
+	IncludeTimings bool `json:"include_timings"`
+	// b64 响应中内容相同的图片只返回一次，重复的条目以 ref 指向首次出现的位置
+	DedupResponseImages bool `json:"dedup_response_images"`
+	// b64 响应的每个条目附带 size_bytes 字段，为下载的原始字节数
+	IncludeSizeBytes bool `json:"include_size_bytes"`
+	// b64 响应的每个条目附带 index 字段（非标准字段）
+	IncludeImageIndex bool `json:"include_image_index"`
+	// 全部图片失败时返回的占位图文件，为空时不启用
+	FallbackImage string `json:"fallback_image"`
+	// 校验客户端请求体的 JSON Schema 文件，为空时不校验
+	RequestSchema string `json:"request_schema"`
+	// 禁止返回的图片 SHA-256（十六进制），命中时以错误条目代替
+	BlockedImageHashes []string `json:"blocked_image_hashes"`
+	// 所有响应模式中都不返回 revised_prompt
+	OmitRevisedPrompt bool `json:"omit_revised_prompt"`
+	// 生成接口响应的 gzip 压缩
+	Compression CompressionConfig `json:"compression"`
+	// 互斥的请求参数组，同一组中同时出现两个以上时返回 400；默认检查 seed 与 seeds、size 与 image_size，配置为 [] 关闭
+	ExclusiveParams [][]string `json:"exclusive_params"`
+	// 以字符串给出时转换为数字再转发的参数，如 "n": "2"
+	NumericParams []string `json:"numeric_params"`
+	// 请求 Content-Type 的校验：off、lenient（仅拒绝明确的非 JSON 类型）或 strict（必须为 JSON）
+	ContentTypeCheck string `json:"content_type_check"`
+	// 上游以 2xx 返回 error 字段且无法按错误类型判断时返回的状态码
+	UpstreamErrorStatus int `json:"upstream_error_status"`
+	// 上游响应体中途断开或超时（截断）时返回的状态码
+	UpstreamTruncatedStatus int `json:"upstream_truncated_status"`
+	// 上游返回的图片少于 n 时的处理：warn 仅记录日志，pad 以错误条目补足，fail 返回 502
+	ImageCountMismatch string `json:"image_count_mismatch"`
+	// URL 直通模式下上游以 b64_json 内联的图片：inline 原样返回，drop 丢弃内联数据并在条目上以 error 说明
+	MixedImages string `json:"mixed_images"`
+	// 同一请求中相同的图片 URL 只下载一次
+	DedupDownloads bool `json:"dedup_downloads"`
+	// 并发请求中相同的图片 URL 只下载一次
+	SharedDownloads bool `json:"shared_downloads"`
+	// 全局同时进行的图片下载数，0 表示不限
+	DownloadConcurrency int `json:"download_concurrency"`
+	// 下载槽位不足时按图片位置分配，靠前的图片先下载
+	DownloadPriority bool `json:"download_priority"`
+	// 请求带 seeds 数组时，同时进行的按 seed 拆分的上游调用数
+	SeedsConcurrency int `json:"seeds_concurrency"`
+	// 同时进行的 base64 编码/格式转换数，0 表示 CPU 核数
+	EncodeConcurrency int `json:"encode_concurrency"`
+	// 将带有非 sRGB 色彩配置的图片转换为 sRGB
+	NormalizeColorProfile bool `json:"normalize_color_profile"`
+	// 返回前移除图片的 EXIF/XMP 等元数据
+	StripMetadata bool `json:"strip_metadata"`
+	// 动图（多帧 GIF）取第一帧转为静态图的格式：png 或 jpeg，为空表示不转换
+	StillFrames string `json:"still_frames"`
+	// 返回图片的最大边长（像素），超过时按比例缩小，0 表示不限制
+	MaxImageDimension int `json:"max_image_dimension"`
+	// 下载后的锐化与对比度处理
+	Enhance EnhanceConfig `json:"enhance"`
+	// WebP 输出格式
+	WebP WebPConfig `json:"webp"`
+	// 按模型的参数配置
+	Models map[string]ModelConfig `json:"models"`
+	// 上游以 401/429 拒绝 Key 时换用密钥文件 Key 池中的下一个重试
+	KeyRotation KeyRotationConfig `json:"key_rotation"`
+	// 请求带 Cache-Control: no-cache 或 X-No-Cache 时不复用重复提交的结果，也不写入 stale_cache、
+	// 上游失败时不以过期结果兜底
+	HonorNoCache bool `json:"honor_no_cache"`
+	// 合并短时间内重复提交的相同请求
+	DuplicateSubmissions DuplicateSubmissionsConfig `json:"duplicate_submissions"`
+	// 指定客户端始终按 b64_json 返回，不论其请求的 response_format
+	ForceB64 ForceB64Config `json:"force_b64"`
+	// 通过请求头临时替换请求体中的 model
+	ModelOverride ModelOverrideConfig `json:"model_override"`
+	// 模型 → 提示词模板，转发前套用，{prompt} 为原始提示词
+	PromptTemplates map[string]string `json:"prompt_templates"`
+
+	// 请求日志附带客户端 IP 的国家与 ASN
+	GeoIP GeoIPConfig `json:"geoip"`
+
+	Audit         AuditConfig         `json:"audit"`
+	UpstreamAudit UpstreamAuditConfig `json:"upstream_audit"`
+	CloudEvents   CloudEventsConfig   `json:"cloud_events"`
+	Tenant        TenantConfig        `json:"tenant"`
+	Queue         QueueConfig         `json:"queue"`
+	Storage       StorageConfig       `json:"storage"`
+
+	Translation TranslationConfig `json:"translation"`
+	Language    LanguageConfig    `json:"language"`
+	Hedge       HedgeConfig       `json:"hedge"`
+	// 按在途调用数收紧的上游超时
+	AdaptiveTimeout AdaptiveTimeoutConfig `json:"adaptive_timeout"`
+	Cost            CostConfig            `json:"cost"`
+	Signing         SigningConfig         `json:"signing"`
+	// 校验客户端请求签名
+	ClientSigning ClientSigningConfig `json:"client_signing"`
+	StaleCache    StaleCacheConfig    `json:"stale_cache"`
+
+	Async         AsyncConfig         `json:"async"`
+	UpstreamAsync UpstreamAsyncConfig `json:"upstream_async"`
+
+	// 以下为加载后派生的字段
+	trustedProxies []*net.IPNet
+	blockedHashes  map[string]struct{}
+	requestSchema  *jsonschema.Schema
+	fallbackImage  []byte
+}
+
+// 校验配置并计算派生字段
+func (c *Config) prepare() error {
+	nets, err := parseCIDRs(c.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("trusted_proxies: %w", err)
+	}
+	c.trustedProxies = nets
+	if c.FallbackImage != "" {
+		if c.fallbackImage, err = os.ReadFile(c.FallbackImage); err != nil {
+			return fmt.Errorf("fallback_image: %w", err)
+		}
+		if detectFormat(c.fallbackImage) == "" {
+			return fmt.Errorf("fallback_image: %s 不是可识别的图片", c.FallbackImage)
+		}
+	}
+	if c.requestSchema, err = compileRequestSchema(c.RequestSchema); err != nil {
+		return fmt.Errorf("request_schema: %w", err)
+	}
+	c.blockedHashes = make(map[string]struct{}, len(c.BlockedImageHashes))
+	for _, h := range c.BlockedImageHashes {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if len(h) != 64 {
+			return fmt.Errorf("blocked_image_hashes: %q 不是 SHA-256 十六进制值", h)
+		}
+		c.blockedHashes[h] = struct{}{}
+	}
+	for endpoint := range c.RateLimits {
+		if !slices.Contains(rateLimitEndpoints, endpoint) {
+			return fmt.Errorf("rate_limits: 不支持的接口 %q", endpoint)
+		}
+	}
+	switch c.StreamMode {
+	case "sse", "strip", "forward":
+	default:
+		return fmt.Errorf("stream_mode: 不支持的取值 %q", c.StreamMode)
+	}
+	switch c.ImageCountMismatch {
+	case "warn", "pad", "fail":
+	default:
+		return fmt.Errorf("image_count_mismatch: 不支持的取值 %q", c.ImageCountMismatch)
+	}
+	if c.Compression.Level < gzip.BestSpeed || c.Compression.Level > gzip.BestCompression {
+		return fmt.Errorf("compression.level: 取值应在 %d 到 %d 之间", gzip.BestSpeed, gzip.BestCompression)
+	}
+	switch c.StillFrames {
+	case "", "png", "jpeg":
+	default:
+		return fmt.Errorf("still_frames: 不支持的取值 %q", c.StillFrames)
+	}
+	switch c.ContentTypeCheck {
+	case "off", "lenient", "strict":
+	default:
+		return fmt.Errorf("content_type_check: 不支持的取值 %q", c.ContentTypeCheck)
+	}
+	switch c.UpstreamRedirects.Policy {
+	case "follow", "error":
+	default:
+		return fmt.Errorf("upstream_redirects.policy: 不支持的取值 %q", c.UpstreamRedirects.Policy)
+	}
+	switch c.MixedImages {
+	case "inline", "drop":
+	default:
+		return fmt.Errorf("mixed_images: 不支持的取值 %q", c.MixedImages)
+	}
+	switch c.Storage.QuotaPolicy {
+	case "reject", "evict_oldest":
+	default:
+		return fmt.Errorf("storage.quota_policy: 不支持的取值 %q", c.Storage.QuotaPolicy)
+	}
+	slices.SortFunc(c.AdaptiveTimeout.Steps, func(a, b TimeoutStep) int { return a.InFlight - b.InFlight })
+	for _, step := range c.AdaptiveTimeout.Steps {
+		if step.Timeout <= 0 {
+			return fmt.Errorf("adaptive_timeout.steps: in_flight=%d 的超时必须大于 0", step.InFlight)
+		}
+	}
+	for name, m := range c.Models {
+		for _, f := range m.ResponseFormats {
+			if f != "url" && f != "b64_json" {
+				return fmt.Errorf("models.%s.response_formats: 不支持的取值 %q", name, f)
+			}
+		}
+	}
+	if c.WebP.Quality < 0 || c.WebP.Quality > 100 {
+		return fmt.Errorf("webp.quality: 取值应在 0-100 之间，当前为 %d", c.WebP.Quality)
+	}
+	if !slices.Contains(requestPriorities, c.Queue.Priority.Default) {
+		return fmt.Errorf("queue.priority.default: 不支持的优先级 %q", c.Queue.Priority.Default)
+	}
+	for p := range c.Queue.Priority.ShedAt {
+		if !slices.Contains(requestPriorities, p) {
+			return fmt.Errorf("queue.priority.shed_at: 不支持的优先级 %q", p)
+		}
+	}
+	switch c.ZipLayout {
+	case "flat", "nested":
+	default:
+		return fmt.Errorf("zip_layout: 不支持的取值 %q", c.ZipLayout)
+	}
+	if c.MaxStreamDuration < 0 {
+		return fmt.Errorf("max_stream_duration: 不能为负数")
+	}
+	if c.PartialSuccessStatus != http.StatusOK && c.PartialSuccessStatus != http.StatusPartialContent {
+		return fmt.Errorf("partial_success_status: 只支持 200 或 206")
+	}
+	if c.UpstreamAsync.PollInterval <= 0 {
+		return fmt.Errorf("upstream_async.poll_interval: 必须大于 0")
+	}
+	if c.UpstreamSizeField == "" {
+		return fmt.Errorf("upstream_size_field: 不能为空")
+	}
+	if c.B64StorageThreshold > 0 && !c.Storage.Enabled {
+		return fmt.Errorf("b64_storage_threshold: 需要开启 storage")
+	}
+	if c.CloudEvents.Enabled {
+		switch c.CloudEvents.Sink {
+		case "stdout":
+		case "http", "kafka":
+			if c.CloudEvents.URL == "" {
+				return fmt.Errorf("cloud_events.url: %s 输出需要配置地址", c.CloudEvents.Sink)
+			}
+			if c.CloudEvents.Sink == "kafka" && c.CloudEvents.Topic == "" {
+				return fmt.Errorf("cloud_events.topic: kafka 输出需要配置 topic")
+			}
+		default:
+			return fmt.Errorf("cloud_events.sink: 不支持的取值 %q", c.CloudEvents.Sink)
+		}
+	}
+	if c.ClientSigning.Enabled {
+		if _, ok := signingAlgorithms[c.ClientSigning.Algorithm]; !ok {
+			return fmt.Errorf("client_signing.algorithm: 不支持的算法 %q", c.ClientSigning.Algorithm)
+		}
+		if c.ClientSigning.Secret == "" {
+			return fmt.Errorf("client_signing.secret: 开启签名校验时不能为空")
+		}
+		// 时间戳在 ±clock_skew_tolerance 内都有效，nonce 记录得更短时过期后的请求仍可被重放
+		if c.ClientSigning.RequireNonce && c.ClientSigning.NonceTTL < 2*c.ClientSigning.ClockSkewTolerance {
+			return fmt.Errorf("client_signing.nonce_ttl: 应不小于两倍 clock_skew_tolerance（%v），当前为 %v",
+				time.Duration(2*c.ClientSigning.ClockSkewTolerance), time.Duration(c.ClientSigning.NonceTTL))
+		}
+	}
+	if c.Signing.Enabled {
+		if _, ok := signingAlgorithms[c.Signing.Algorithm]; !ok {
+			return fmt.Errorf("signing.algorithm: 不支持的算法 %q", c.Signing.Algorithm)
+		}
+		if c.Signing.Secret == "" {
+			return fmt.Errorf("signing.secret: 开启签名时不能为空")
+		}
+	}
+	return nil
+}
+
+// 每分钟请求数与允许的突发请求数，客户端按 rate_limit_key_hashes 中的 API Key（其余按 IP）区分
+type RateLimitConfig struct {
+	RequestsPerMinute int `json:"requests_per_minute"`
+	Burst             int `json:"burst"`
+}
+
+// 提示词自动翻译配置，使用 LibreTranslate 兼容接口
+type TranslationConfig struct {
+	Enabled        bool   `json:"enabled"`
+	URL            string `json:"url"`
+	APIKey         string `json:"api_key"`
+	TargetLanguage string `json:"target_language"`
+	// 仅对这些模型翻译，为空表示全部模型
+	Models []string `json:"models"`
+	// 超时后使用原提示词
+	Timeout Duration `json:"timeout"`
+}
+
+// 图片增强强度，均为 0 时不处理
+type EnhanceConfig struct {
+	// USM 锐化强度，常用 0.3 ~ 1
+	Sharpen float64 `json:"sharpen"`
+	// 对比度调整，0.1 表示提高 10%，负值降低
+	Contrast float64 `json:"contrast"`
+}
+
+// 提示词语言白名单，使用 ISO 639-1 代码（zh、en、ja 等）
+type LanguageConfig struct {
+	Enabled bool     `json:"enabled"`
+	Allowed []string `json:"allowed"`
+}
+
+// 上游请求签名：对时间戳与请求体计算 HMAC，放入 X-Timestamp / X-Signature
+type SigningConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Secret    string `json:"secret"`
+	Algorithm string `json:"algorithm"`
+}
+
+// 客户端请求签名校验：请求须带 X-Timestamp 与 X-Signature，算法同上游签名
+type ClientSigningConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Secret    string `json:"secret"`
+	Algorithm string `json:"algorithm"`
+	// 允许的客户端与服务器时钟偏差，时间戳超出该范围的请求被拒绝
+	ClockSkewTolerance Duration `json:"clock_skew_tolerance"`
+	// 要求请求带 X-Nonce 并参与签名，nonce_ttl 内重复使用的 nonce 被拒绝
+	RequireNonce bool     `json:"require_nonce"`
+	NonceTTL     Duration `json:"nonce_ttl"`
+}
+
+// 上游失败时返回缓存的过期结果，仅缓存带 seed 的 b64_json 响应
+type StaleCacheConfig struct {
+	Enabled    bool     `json:"enabled"`
+	MaxEntries int      `json:"max_entries"`
+	TTL        Duration `json:"ttl"`
+}
+
+// 上游费用信息的位置，优先读取响应头
+type CostConfig struct {
+	Header string `json:"header"`
+	// 响应体中的点分路径，如 usage.cost
+	Field string `json:"field"`
+}
+
+// 上游请求对冲：首个请求超过延迟仍未响应时再发一份，取先返回者
+type HedgeConfig struct {
+	Enabled bool `json:"enabled"`
+	// 取最近上游耗时的该百分位作为对冲延迟
+	Percentile float64 `json:"percentile"`
+	// 样本数达到该值前使用固定延迟 delay
+	MinSamples int      `json:"min_samples"`
+	Delay      Duration `json:"delay"`
+	MinDelay   Duration `json:"min_delay"`
+}
+
+// 客户端异步任务配置（Prefer: respond-async）
+type AsyncConfig struct {
+	Enabled bool `json:"enabled"`
+	// 预计耗时超过该值的请求自动转为异步，0 表示仅按 Prefer 头判断
+	LongRequestThreshold Duration `json:"long_request_threshold"`
+	// 耗时估算：基础耗时 + 张数 × 步数 × 单步耗时 × 百万像素数
+	EstimateBase    Duration `json:"estimate_base"`
+	EstimatePerStep Duration `json:"estimate_per_step"`
+}
+
+// 上游异步任务轮询配置：上游返回任务 ID 时轮询状态接口直到完成
+type UpstreamAsyncConfig struct {
+	Enabled bool `json:"enabled"`
+	// 状态查询地址，{id} 替换为上游任务 ID
+	StatusURL    string   `json:"status_url"`
+	PollInterval Duration `json:"poll_interval"`
+	PollTimeout  Duration `json:"poll_timeout"`
+}
+
+// 存储模式配置：URL 响应改为返回代理自身托管的图片地址
+type StorageConfig struct {
+	Enabled bool   `json:"enabled"`
+	Dir     string `json:"dir"`
+	// 拼接图片 URL 的外部访问地址
+	PublicBaseURL string `json:"public_base_url"`
+	// 每个客户端（按 API Key）最多保存的图片数量与字节数，0 表示不限
+	MaxImagesPerClient int   `json:"max_images_per_client"`
+	MaxBytesPerClient  int64 `json:"max_bytes_per_client"`
+	// 超出配额时的处理：reject 拒绝保存新图片，evict_oldest 删除该客户端最早的图片
+	QuotaPolicy string `json:"quota_policy"`
+	// 单次保存与读取的超时，超时后该图片存储失败，0 表示不限
+	SaveTimeout Duration `json:"save_timeout"`
+	GetTimeout  Duration `json:"get_timeout"`
+	// 保存图片时附带模型、提示词哈希、seed、租户等请求信息
+	Metadata bool `json:"metadata"`
+}
+
+// 请求排队配置
+type QueueConfig struct {
+	Enabled       bool `json:"enabled"`
+	MaxConcurrent int  `json:"max_concurrent"`
+	// 最多排队的请求数，超出直接返回 503；0 表示不限
+	MaxWaiting int `json:"max_waiting"`
+	// 单个请求的总时间预算（排队 + 处理），0 表示不限
+	RequestBudget Duration `json:"request_budget"`
+	// 排队耗时达到预算的该比例时放弃排队并返回 503
+	MaxWaitFraction float64 `json:"max_wait_fraction"`
+	// 按优先级卸载负载
+	Priority PriorityConfig `json:"priority"`
+}
+
+// 请求优先级取自 header（high / normal / low），缺省或无法识别时为 default。
+// shed_at 为各优先级的卸载阈值：处理中的请求数达到 max_concurrent 的该比例时，
+// 该优先级的请求直接返回 503 而不排队；未配置阈值的优先级只受排队限制
+type PriorityConfig struct {
+	Header  string             `json:"header"`
+	Default string             `json:"default"`
+	ShedAt  map[string]float64 `json:"shed_at"`
+}
+
+// 单个模型的参数配置
+type ModelConfig struct {
+	// 客户端未传 n 时注入的默认值，0 表示不注入
+	DefaultN int `json:"default_n"`
+	// 允许的最大 n，超出返回 400；0 表示不限
+	MaxN int `json:"max_n"`
+	// 模型能直接返回的 response_format（url、b64_json），为空表示都支持
+	ResponseFormats []string `json:"response_formats"`
+}
+
+// 响应压缩；level 为 gzip 级别 1（最快）~ 9（压缩率最高）
+type CompressionConfig struct {
+	Enabled bool `json:"enabled"`
+	Level   int  `json:"level"`
+}
+
+// 生成请求的重定向：follow 带请求体重新提交，error 视为上游错误
+type UpstreamRedirectConfig struct {
+	Policy       string `json:"policy"`
+	MaxRedirects int    `json:"max_redirects"`
+}
+
+// 上游 Key 轮换；429 后的冷却优先取上游 Retry-After
+type KeyRotationConfig struct {
+	Enabled         bool     `json:"enabled"`
+	Cooldown        Duration `json:"cooldown"`
+	InvalidCooldown Duration `json:"invalid_cooldown"`
+}
+
+// MaxMind 格式（.mmdb）的 GeoIP 数据库，如 GeoLite2-Country 与 GeoLite2-ASN；两者可只配置其一
+type GeoIPConfig struct {
+	Enabled         bool   `json:"enabled"`
+	CountryDatabase string `json:"country_database"`
+	ASNDatabase     string `json:"asn_database"`
+}
+
+// 重复提交检测：window 内客户端 IP、API Key 与请求体都相同的请求复用首个请求的结果
+type DuplicateSubmissionsConfig struct {
+	Enabled bool     `json:"enabled"`
+	Window  Duration `json:"window"`
+}
+
+// 始终返回 b64_json 的客户端，按 API Key 哈希（与审计日志的 key_hash 相同）或请求头识别
+type ForceB64Config struct {
+	KeyHashes []string `json:"key_hashes"`
+	Header    string   `json:"header"`
+	Values    []string `json:"values"`
+}
+
+// 按请求头覆盖模型
+type ModelOverrideConfig struct {
+	Enabled bool   `json:"enabled"`
+	Header  string `json:"header"`
+	// 允许覆盖成的模型，为空时仅允许 models 中配置过的模型
+	Allowed []string `json:"allowed"`
+}
+
+// 上游调用审计日志配置，每次调用上游记录模型、n、尺寸、状态码与耗时，与请求审计日志分开输出
+type UpstreamAuditConfig struct {
+	Enabled bool `json:"enabled"`
+	// 输出目标：文件路径、"stdout"、"stderr" 或 "syslog"
+	Output string `json:"output"`
+}
+
+// CloudEvents 事件输出，记录请求到达与生成完成
+type CloudEventsConfig struct {
+	Enabled bool `json:"enabled"`
+	// 输出目标："http"、"kafka"（经 Kafka REST Proxy）或 "stdout"
+	Sink string `json:"sink"`
+	// http 为事件接收地址，kafka 为 REST Proxy 地址
+	URL string `json:"url"`
+	// kafka 写入的 topic
+	Topic string `json:"topic"`
+	// 事件的 source 属性
+	Source string `json:"source"`
+	// 单次投递超时
+	Timeout Duration `json:"timeout"`
+}
+
+// 自适应上游超时：在途调用数达到某一档的 in_flight 时改用该档的超时
+type AdaptiveTimeoutConfig struct {
+	Enabled bool          `json:"enabled"`
+	Steps   []TimeoutStep `json:"steps"`
+}
+
+type TimeoutStep struct {
+	InFlight int      `json:"in_flight"`
+	Timeout  Duration `json:"timeout"`
+}
+
+// 租户标识，取值作为指标标签与日志字段
+type TenantConfig struct {
+	// 携带租户 ID 的请求头
+	Header string `json:"header"`
+	// 未配置 allowed 时最多区分的租户数，超出的记为 other
+	MaxTenants int `json:"max_tenants"`
+	// 允许作为标签的租户 ID，为空时按 max_tenants 限制
+	Allowed []string `json:"allowed"`
+}
+
+// 开启后 output_format 与 Accept 可以选择 webp；编码器不可用或编码失败时回退为 PNG
+type WebPConfig struct {
+	Enabled bool `json:"enabled"`
+	// 编码质量 0-100
+	Quality int `json:"quality"`
+	// cwebp 可执行文件名或路径
+	Encoder string `json:"encoder"`
+}
+
+// 审计日志配置
+type AuditConfig struct {
+	Enabled bool `json:"enabled"`
+	// 输出目标：文件路径、"stdout"、"stderr" 或 "syslog"
+	Output string `json:"output"`
+	// 是否在审计事件中记录原始提示词，默认仅记录哈希
+	IncludePrompt bool `json:"include_prompt"`
+}
+
+// Duration 支持在 JSON 中以 "15s"、"500ms" 形式书写时长
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		// 兼容直接写纳秒数
+		var n int64
+		if err := json.Unmarshal(b, &n); err != nil {
+			return fmt.Errorf("invalid duration: %s", b)
+		}
+		*d = Duration(n)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Port:                    ":3000",
+		UpstreamURL:             "https://api.siliconflow.cn/v1/images/generations",
+		UpstreamTimeout:         Duration(15 * time.Second),
+		DedupDownloads:          true,
+		SeedsConcurrency:        4,
+		UpstreamErrorStatus:     http.StatusBadGateway,
+		UpstreamTruncatedStatus: http.StatusGatewayTimeout,
+		ImageCountMismatch:      "warn",
+		MixedImages:             "inline",
+		ShutdownGracePeriod:     Duration(30 * time.Second),
+		ModelOverride:           ModelOverrideConfig{Header: "X-Model-Override"},
+		ContentTypeCheck:        "off",
+		PartialSuccessStatus:    http.StatusOK,
+		ZipLayout:               "flat",
+		UpstreamSizeField:       "image_size",
+		ExclusiveParams:         [][]string{{"seed", "seeds"}, {"size", "image_size"}},
+		NumericParams:           []string{"n", "seed", "batch_size", "num_inference_steps", "guidance_scale"},
+		Compression:             CompressionConfig{Level: 6},
+		UpstreamRedirects:       UpstreamRedirectConfig{Policy: "follow", MaxRedirects: 3},
+		KeyRotation: KeyRotationConfig{
+			Cooldown:        Duration(time.Minute),
+			InvalidCooldown: Duration(10 * time.Minute),
+		},
+		HonorNoCache:         true,
+		DuplicateSubmissions: DuplicateSubmissionsConfig{Window: Duration(10 * time.Second)},
+		StreamMode:           "sse",
+		Audit: AuditConfig{
+			Output: "stdout",
+		},
+		UpstreamAudit: UpstreamAuditConfig{
+			Output: "stdout",
+		},
+		WebP: WebPConfig{
+			Quality: 80,
+			Encoder: "cwebp",
+		},
+		Tenant: TenantConfig{
+			Header:     "X-Tenant-Id",
+			MaxTenants: 50,
+		},
+		CloudEvents: CloudEventsConfig{
+			Sink:    "stdout",
+			Source:  "/sc-proxy",
+			Timeout: Duration(5 * time.Second),
+		},
+		Queue: QueueConfig{
+			MaxConcurrent:   16,
+			MaxWaitFraction: 0.5,
+			Priority: PriorityConfig{
+				Header:  "X-Priority",
+				Default: "normal",
+			},
+		},
+		Storage: StorageConfig{
+			Dir:           "data/images",
+			PublicBaseURL: "http://localhost:3000",
+			QuotaPolicy:   "reject",
+		},
+		Async: AsyncConfig{
+			EstimateBase:    Duration(2 * time.Second),
+			EstimatePerStep: Duration(100 * time.Millisecond),
+		},
+		Signing: SigningConfig{
+			Algorithm: "hmac-sha256",
+		},
+		ClientSigning: ClientSigningConfig{
+			Algorithm:          "hmac-sha256",
+			ClockSkewTolerance: Duration(5 * time.Minute),
+			NonceTTL:           Duration(10 * time.Minute),
+		},
+		StaleCache: StaleCacheConfig{
+			MaxEntries: 256,
+			TTL:        Duration(24 * time.Hour),
+		},
+		Hedge: HedgeConfig{
+			Percentile: 95,
+			MinSamples: 20,
+			Delay:      Duration(10 * time.Second),
+			MinDelay:   Duration(500 * time.Millisecond),
+		},
+		Translation: TranslationConfig{
+			TargetLanguage: "en",
+			Timeout:        Duration(3 * time.Second),
+		},
+		UpstreamAsync: UpstreamAsyncConfig{
+			PollInterval: Duration(time.Second),
+			PollTimeout:  Duration(10 * time.Minute),
+		},
+	}
+}
+
+// 读取配置文件，未指定路径时使用默认配置
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+	// 未指定配置文件时同样需要 prepare 计算派生字段
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取配置文件失败: %w", err)
+		}
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("解析配置文件失败: %w", err)
+		}
+	}
+	if err := cfg.prepare(); err != nil {
+		return nil, fmt.Errorf("配置无效: %w", err)
+	}
+	return cfg, nil
+}
+
+var config atomic.Pointer[Config]
+
+func init() {
+	config.Store(defaultConfig())
+}
+
+// 当前生效的配置，热加载时整体替换，请求内应只取一次
+func currentConfig() *Config {
+	return config.Load()
+}
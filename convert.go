@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+// 输出格式对应的扩展名与 Content-Type
+var outputFormats = map[string]struct {
+	ext         string
+	contentType string
+}{
+	"png":  {".png", "image/png"},
+	"jpeg": {".jpg", "image/jpeg"},
+	"webp": {".webp", "image/webp"},
+}
+
+// 规范化客户端传入的 output_format，空字符串表示保持原格式
+func normalizeOutputFormat(format string) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	switch format {
+	case "":
+		return "", nil
+	case "jpg":
+		format = "jpeg"
+	}
+	if _, ok := outputFormats[format]; !ok || format == "webp" && webpOutput == nil {
+		return "", fmt.Errorf("unsupported output_format: %s", format)
+	}
+	return format, nil
+}
+
+// 识别图片字节对应的输出格式，无法识别时返回空字符串
+func detectFormat(data []byte) string {
+	switch http.DetectContentType(data) {
+	case "image/png":
+		return "png"
+	case "image/jpeg":
+		return "jpeg"
+	case "image/gif":
+		return "gif"
+	case "image/webp":
+		return "webp"
+	}
+	return ""
+}
+
+// 按目标格式重新编码，目标为空或与原格式一致时原样返回
+func convertImage(data []byte, format string) ([]byte, error) {
+	if format == "" || detectFormat(data) == format {
+		return data, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	return encodeImage(img, format)
+}
+
+func encodeImage(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	case "webp":
+		return encodeWebPOrPNG(img)
+	default:
+		err = fmt.Errorf("unsupported output_format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 图片字节对应的扩展名与 Content-Type
+func formatInfo(data []byte) (ext, contentType string) {
+	if info, ok := outputFormats[detectFormat(data)]; ok {
+		return info.ext, info.contentType
+	}
+	contentType = http.DetectContentType(data)
+	switch contentType {
+	case "image/gif":
+		return ".gif", contentType
+	case "image/webp":
+		return ".webp", contentType
+	}
+	return ".bin", contentType
+}
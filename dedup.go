@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 一次生成请求的完整响应，供窗口内的重复提交直接复用
+type submission struct {
+	done     chan struct{}
+	status   int
+	header   http.Header
+	body     []byte
+	finished time.Time
+	canceled bool // 首个请求的客户端已断开，结果不可复用
+}
+
+type submissionGroup struct {
+	mu    sync.Mutex
+	calls map[string]*submission
+}
+
+var submissions = &submissionGroup{calls: make(map[string]*submission)}
+
+// 复用时不照搬的响应头：压缩与 trailer 由本次响应自行决定
+var submissionSkipHeaders = map[string]bool{
+	"Content-Encoding": true,
+	"Content-Length":   true,
+	"Vary":             true,
+	"Trailer":          true,
+}
+
+// 请求指纹：客户端 IP、API Key、路径、Accept 与请求体相同即视为重复提交
+func submissionFingerprint(r *http.Request, ip string, body []byte) string {
+	h := sha256.New()
+	for _, s := range []string{ip, r.Header.Get("Authorization"), r.URL.RequestURI(), r.Header.Get("Accept")} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 返回指纹对应的进行中或窗口内已完成的请求；没有时登记一个新请求，owner 为 true 表示由调用方执行
+func (g *submissionGroup) join(key string, window time.Duration, now time.Time) (call *submission, owner bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for k, c := range g.calls {
+		if !c.finished.IsZero() && now.Sub(c.finished) > window {
+			delete(g.calls, k)
+		}
+	}
+	if c, ok := g.calls[key]; ok {
+		return c, false
+	}
+	c := &submission{done: make(chan struct{})}
+	g.calls[key] = c
+	return c, true
+}
+
+// 请求完成后记录响应；5xx 结果只交给正在等待的重复请求，不在窗口内保留。
+// 首个请求被取消时（常见于客户端超时后重试）不共享其结果，等待方各自处理
+func (g *submissionGroup) finish(key string, c *submission, rec *submissionRecorder, canceled bool) {
+	c.canceled = canceled
+	c.status = rec.Status()
+	c.header = rec.Header().Clone()
+	c.body = rec.body.Bytes()
+	g.mu.Lock()
+	c.finished = time.Now()
+	if c.status >= 500 || canceled {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+	close(c.done)
+}
+
+// 在写给客户端的同时保留一份响应体
+type submissionRecorder struct {
+	statusRecorder
+	body bytes.Buffer
+}
+
+func (s *submissionRecorder) Write(b []byte) (int, error) {
+	s.body.Write(b)
+	return s.statusRecorder.Write(b)
+}
+
+func (c *submission) replay(w http.ResponseWriter) {
+	for k, v := range c.header {
+		if !submissionSkipHeaders[k] {
+			w.Header()[k] = v
+		}
+	}
+	w.Header().Set("X-Duplicate-Submission", "true")
+	w.WriteHeader(c.status)
+	w.Write(c.body)
+}
+
+// 重复提交合并中间件：窗口内指纹相同的请求不再调用上游，等待并复用首个请求的响应
+func withDuplicateDetection(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := currentConfig()
+		if !cfg.DuplicateSubmissions.Enabled || r.Method != http.MethodPost || bypassCache(cfg, r) {
+			next(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		ip := clientIP(r, cfg.trustedProxies)
+		key := submissionFingerprint(r, ip, body)
+		call, owner := submissions.join(key, time.Duration(cfg.DuplicateSubmissions.Window), time.Now())
+		if !owner {
+			log.Printf("[DEDUP] IP %s 重复提交，复用相同请求的结果", ip)
+			select {
+			case <-call.done:
+			case <-r.Context().Done():
+				return
+			}
+			if !call.canceled {
+				call.replay(w)
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		rec := &submissionRecorder{statusRecorder: statusRecorder{ResponseWriter: w}}
+		defer func() { submissions.finish(key, call, rec, r.Context().Err() != nil) }()
+		next(rec, r)
+	}
+}
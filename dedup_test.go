@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDuplicateSubmissionsMerged(t *testing.T) {
+	var calls atomic.Int32
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(`{"images":[{"url":"https://cdn.example/a.png"}]}`))
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.DuplicateSubmissions.Enabled = true
+	})
+	swapGlobal(t, &submissions, &submissionGroup{calls: make(map[string]*submission)})
+	h := withDuplicateDetection(handleGenerations)
+	body := `{"prompt":"a red fox"}`
+
+	// 两个几乎同时到达的相同请求
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, 2)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = serveGenerations(t, h, body)
+		}()
+		time.Sleep(5 * time.Millisecond)
+	}
+	wg.Wait()
+	// 窗口内已完成的结果同样复用
+	results = append(results, serveGenerations(t, h, body))
+
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("上游调用了 %d 次, want 1", got)
+	}
+	dups := 0
+	for i, w := range results {
+		if w.Code != http.StatusOK || w.Body.String() != results[0].Body.String() {
+			t.Errorf("第 %d 个请求 status = %d, body = %s", i, w.Code, w.Body)
+		}
+		if w.Header().Get("X-Duplicate-Submission") == "true" {
+			dups++
+		}
+	}
+	if dups != 2 {
+		t.Errorf("带 X-Duplicate-Submission 的响应有 %d 个, want 2", dups)
+	}
+
+	// 请求体不同的请求正常调用上游
+	if w := serveGenerations(t, h, `{"prompt":"a blue fox"}`); w.Code != http.StatusOK || calls.Load() != 2 {
+		t.Errorf("不同请求 status = %d, 上游调用 %d 次, want 2", w.Code, calls.Load())
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 单个变体的下载结果
+type imageSlot struct {
+	typ      string
+	data     []byte
+	err      string
+	elapsed  time.Duration // 下载与后处理耗时
+	size     int           // 下载（或内联解码）得到的原始字节数
+	original []byte        // 开启 include_original 且后处理改变了图片时为下载得到的原图
+}
+
+// 结果在 [图片][变体] 中的位置
+type slotRef struct {
+	index   int
+	variant int
+}
+
+// 一次下载任务，相同 URL 合并为一个任务并回填到所有位置
+type downloadTask struct {
+	url     string
+	b64     string // 上游内联的 base64 数据，非空时不下载
+	targets []slotRef
+}
+
+type downloadResult struct {
+	task     *downloadTask
+	data     []byte
+	err      error
+	elapsed  time.Duration
+	size     int
+	original []byte
+}
+
+// 下载失败的分类信息，便于对照 CDN 问题复现
+type downloadError struct {
+	class  string // network / timeout / canceled / http_status / read / request
+	status int
+	err    error
+}
+
+func (e *downloadError) Error() string {
+	if e.class == "http_status" {
+		return fmt.Sprintf("HTTP %d", e.status)
+	}
+	return e.err.Error()
+}
+
+func (e *downloadError) Unwrap() error { return e.err }
+
+// 网络错误、超时、429 与 5xx 可重试
+func (e *downloadError) retryable() bool {
+	switch e.class {
+	case "network", "timeout", "read":
+		return true
+	case "http_status":
+		return e.status == http.StatusTooManyRequests || e.status >= 500
+	}
+	return false
+}
+
+func classifyDownloadError(ctx context.Context, err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled) || ctx.Err() == context.Canceled:
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+	return "network"
+}
+
+// 下载单张图片，失败时按 download_retries 重试，ctx 取消时中止
+func downloadImage(ctx context.Context, cfg *Config, url string, index int) ([]byte, error) {
+	attempts := 1 + max(cfg.DownloadRetries, 0)
+	var lastErr *downloadError
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			if !takeRetry(ctx, "download") {
+				break
+			}
+			select {
+			case <-time.After(time.Duration(attempt-1) * 200 * time.Millisecond):
+			case <-ctx.Done():
+				return nil, lastErr
+			}
+		}
+		data, err := downloadOnce(ctx, cfg, url, index)
+		if err == nil {
+			return data, nil
+		}
+		lastErr = err
+		log.Printf("[ERROR %d] 下载失败 url=%s class=%s attempt=%d/%d status=%d err=%v",
+			index, sanitizeURL(url, cfg.LogURLQueryAllowlist), err.class, attempt, attempts, err.status, err.err)
+		if !err.retryable() {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+func downloadOnce(ctx context.Context, cfg *Config, url string, index int) ([]byte, *downloadError) {
+	log.Printf("[DOWNLOAD %d] 开始下载: %s", index, sanitizeURL(url, cfg.LogURLQueryAllowlist))
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, &downloadError{class: "request", err: sanitizeURLError(err, cfg.LogURLQueryAllowlist)}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, &downloadError{class: classifyDownloadError(ctx, err), err: sanitizeURLError(err, cfg.LogURLQueryAllowlist)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &downloadError{class: "http_status", status: resp.StatusCode, err: errors.New(resp.Status)}
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		class := classifyDownloadError(ctx, err)
+		if class == "network" {
+			class = "read"
+		}
+		return nil, &downloadError{class: class, status: resp.StatusCode, err: sanitizeURLError(err, cfg.LogURLQueryAllowlist)}
+	}
+
+	log.Printf("[SUCCESS %d] 下载完成，大小: %d bytes, 耗时: %v",
+		index, len(data), time.Since(start))
+	return data, nil
+}
+
+var errImageBlocked = errors.New("image withheld by content policy")
+
+// 图片字节的 SHA-256 是否在 blocked_image_hashes 中
+func (c *Config) imageBlocked(data []byte) bool {
+	if len(c.blockedHashes) == 0 {
+		return false
+	}
+	sum := sha256.Sum256(data)
+	_, ok := c.blockedHashes[hex.EncodeToString(sum[:])]
+	return ok
+}
+
+// 解码上游内联的 base64 图片，并确认是可识别的图片，避免把截断或错误的数据返回给客户端
+func decodeUpstreamB64(b64 string, index int) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(b64))
+	if err != nil {
+		log.Printf("[ERROR %d] 上游 base64 无效: %v", index, err)
+		return nil, errors.New("invalid base64 image data")
+	}
+	// 完整解码以发现截断的数据；webp 没有可用的解码器，仅按文件头识别
+	format := detectFormat(data)
+	if format == "" {
+		log.Printf("[ERROR %d] 上游 base64 不是可识别的图片", index)
+		return nil, errors.New("invalid image data")
+	}
+	if format != "webp" {
+		if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+			log.Printf("[ERROR %d] 上游 base64 不是有效图片: %v", index, err)
+			return nil, errors.New("invalid image data")
+		}
+	}
+	return data, nil
+}
+
+// 日志中的 URL 仅保留白名单内的查询参数，其余参数值替换为 REDACTED，
+// 避免签名等敏感信息落入日志
+func sanitizeURL(raw string, allowlist []string) string {
+	u, err := neturl.Parse(raw)
+	if err != nil || u.RawQuery == "" {
+		return raw
+	}
+	q := u.Query()
+	for key, values := range q {
+		if slices.Contains(allowlist, key) {
+			continue
+		}
+		for i := range values {
+			values[i] = "REDACTED"
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// net/http 返回的 *url.Error 在错误信息中带有完整 URL，按 sanitizeURL 脱敏后再记录或返回给客户端
+func sanitizeURLError(err error, allowlist []string) error {
+	ue, ok := err.(*neturl.Error)
+	if !ok {
+		return err
+	}
+	return &neturl.Error{Op: ue.Op, URL: sanitizeURL(ue.URL, allowlist), Err: ue.Err}
+}
+
+// 并发下载全部图片及其变体，返回结果按 [图片][变体] 原始顺序排列。
+// 配置了软截止时间时，到点后未完成的变体以错误占位。
+func fetchImages(parent context.Context, cfg *Config, images []Image) [][]imageSlot {
+	return fetchImagesStream(parent, cfg, images, nil)
+}
+
+// 同 fetchImages，每个变体完成（或超时占位）时在调用方 goroutine 中回调 onDone
+func fetchImagesStream(parent context.Context, cfg *Config, images []Image, onDone func(ref slotRef, slot imageSlot)) [][]imageSlot {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	slots := make([][]imageSlot, len(images))
+	done := make([][]bool, len(images))
+	var tasks []*downloadTask
+	byURL := make(map[string]*downloadTask)
+	for i, img := range images {
+		variants := img.variantList()
+		slots[i] = make([]imageSlot, len(variants))
+		done[i] = make([]bool, len(variants))
+		for v, variant := range variants {
+			slots[i][v].typ = variant.Type
+			ref := slotRef{index: i, variant: v}
+			if variant.URL == "" && variant.B64JSON == "" {
+				slots[i][v].err = cmp.Or(img.failure, "upstream returned fewer images than requested")
+				done[i][v] = true
+				if onDone != nil {
+					onDone(ref, slots[i][v])
+				}
+				continue
+			}
+			if variant.B64JSON != "" {
+				tasks = append(tasks, &downloadTask{b64: variant.B64JSON, targets: []slotRef{ref}})
+				continue
+			}
+			if task, ok := byURL[variant.URL]; ok && cfg.DedupDownloads {
+				log.Printf("[DEDUP %d] 复用相同 URL 的下载: %s", i, sanitizeURL(variant.URL, cfg.LogURLQueryAllowlist))
+				task.targets = append(task.targets, ref)
+				continue
+			}
+			task := &downloadTask{url: variant.URL, targets: []slotRef{ref}}
+			byURL[variant.URL] = task
+			tasks = append(tasks, task)
+		}
+	}
+
+	// 启动协程前按图片顺序登记下载槽位，同一请求内靠前的图片总是先拿到槽位
+	tickets := make([]*slotWaiter, len(tasks))
+	for k, task := range tasks {
+		if task.b64 != "" {
+			continue
+		}
+		priority := 0
+		if cfg.DownloadPriority {
+			priority = task.targets[0].index
+		}
+		tickets[k] = downloads.enqueue(priority)
+	}
+
+	resultChan := make(chan downloadResult, len(tasks))
+	for k, task := range tasks {
+		go func(task *downloadTask, ticket *slotWaiter) {
+			index := task.targets[0].index
+			start := time.Now()
+			var data []byte
+			var err error
+			if task.b64 != "" {
+				data, err = decodeUpstreamB64(task.b64, index)
+			} else if err = downloads.wait(ctx, ticket); err == nil {
+				if cfg.SharedDownloads {
+					data, err = sharedDownloads.download(ctx, cfg, task.url, index)
+				} else {
+					data, err = downloadImage(ctx, cfg, task.url, index)
+				}
+				downloads.release()
+			}
+			if err == nil && cfg.imageBlocked(data) {
+				log.Printf("[BLOCK %d] 图片哈希命中黑名单，已拦截", index)
+				err = errImageBlocked
+			}
+			size := len(data)
+			var original []byte
+			if err == nil {
+				downloaded := data
+				withEncodeSlot(func() { data = processImage(cfg, data, index) })
+				if cfg.IncludeOriginal && !bytes.Equal(downloaded, data) {
+					original = downloaded
+				}
+			}
+			resultChan <- downloadResult{task: task, data: data, err: err, elapsed: time.Since(start), size: size, original: original}
+		}(task, tickets[k])
+	}
+
+	var deadline <-chan time.Time
+	if cfg.DownloadSoftDeadline > 0 {
+		timer := time.NewTimer(time.Duration(cfg.DownloadSoftDeadline))
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+collect:
+	for range tasks {
+		select {
+		case res := <-resultChan:
+			if res.err != nil {
+				log.Printf("[WARN] 部分图片下载失败: %v", res.err)
+			}
+			for _, ref := range res.task.targets {
+				done[ref.index][ref.variant] = true
+				slot := &slots[ref.index][ref.variant]
+				slot.elapsed = res.elapsed
+				slot.size = res.size
+				if res.err != nil {
+					slot.err = res.err.Error()
+				} else {
+					slot.data, slot.original = res.data, res.original
+				}
+				if onDone != nil {
+					onDone(ref, *slot)
+				}
+			}
+		case <-deadline:
+			log.Printf("[WARN] 已达软截止时间 %v，返回部分结果", time.Duration(cfg.DownloadSoftDeadline))
+			break collect
+		}
+	}
+
+	for i := range slots {
+		for v := range slots[i] {
+			if !done[i][v] {
+				slots[i][v].err = "download deadline exceeded"
+				if onDone != nil {
+					onDone(slotRef{index: i, variant: v}, slots[i][v])
+				}
+			}
+		}
+	}
+	return slots
+}
+
+// 成功下载的图片数量（以主变体为准）
+func countDownloaded(slots [][]imageSlot) int {
+	n := 0
+	for _, s := range slots {
+		if len(s) > 0 && s[0].err == "" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+// 全局下载并发槽位；槽位不足时按优先级（数值小者优先）、同优先级按到达顺序分配
+type downloadSlots struct {
+	mu      sync.Mutex
+	free    int
+	seq     uint64
+	waiters slotWaiters
+}
+
+type slotWaiter struct {
+	priority int
+	seq      uint64
+	ready    chan struct{}
+	granted  bool
+	pos      int // 在堆中的位置，出堆后为 -1
+}
+
+type slotWaiters []*slotWaiter
+
+func (s slotWaiters) Len() int { return len(s) }
+func (s slotWaiters) Less(i, j int) bool {
+	if s[i].priority != s[j].priority {
+		return s[i].priority < s[j].priority
+	}
+	return s[i].seq < s[j].seq
+}
+func (s slotWaiters) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+	s[i].pos, s[j].pos = i, j
+}
+func (s *slotWaiters) Push(x any) {
+	w := x.(*slotWaiter)
+	w.pos = len(*s)
+	*s = append(*s, w)
+}
+func (s *slotWaiters) Pop() any {
+	old := *s
+	w := old[len(old)-1]
+	*s, w.pos = old[:len(old)-1], -1
+	return w
+}
+
+var downloads *downloadSlots
+
+// n <= 0 时不限制下载并发，返回 nil
+func newDownloadSlots(n int) *downloadSlots {
+	if n <= 0 {
+		return nil
+	}
+	return &downloadSlots{free: n}
+}
+
+// 登记一个槽位请求：有空闲槽位且无人排队时立即分配，否则按优先级排队。
+// 调用方在启动下载协程前按图片顺序依次登记，槽位分配顺序不受协程调度影响
+func (d *downloadSlots) enqueue(priority int) *slotWaiter {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w := &slotWaiter{priority: priority, ready: make(chan struct{}), pos: -1}
+	if d.free > 0 && len(d.waiters) == 0 {
+		d.free--
+		w.granted = true
+		close(w.ready)
+		return w
+	}
+	d.seq++
+	w.seq = d.seq
+	heap.Push(&d.waiters, w)
+	return w
+}
+
+// 等待 enqueue 登记的槽位分配，ctx 取消时放弃等待
+func (d *downloadSlots) wait(ctx context.Context, w *slotWaiter) error {
+	if d == nil {
+		return nil
+	}
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		d.mu.Lock()
+		if w.granted {
+			// 取消与分配同时发生，归还已分配的槽位
+			d.mu.Unlock()
+			d.release()
+		} else {
+			heap.Remove(&d.waiters, w.pos)
+			d.mu.Unlock()
+		}
+		return ctx.Err()
+	}
+}
+
+func (d *downloadSlots) release() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.waiters) == 0 {
+		d.free++
+		return
+	}
+	w := heap.Pop(&d.waiters).(*slotWaiter)
+	w.granted = true
+	close(w.ready)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 记录图片请求路径顺序的图片服务器
+func newOrderedImageServer(t *testing.T, png []byte) (url string, order func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var paths []string
+	srv := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, strings.TrimPrefix(r.URL.Path, "/"))
+		mu.Unlock()
+		w.Write(png)
+	})
+	return srv.URL, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(paths)
+	}
+}
+
+func TestDownloadSlotsDispatchInIndexOrder(t *testing.T) {
+	imgURL, order := newOrderedImageServer(t, testPNG(t, 2, 2))
+	var urls, want []string
+	for i := range 6 {
+		urls = append(urls, fmt.Sprintf("%s/%d", imgURL, i))
+		want = append(want, fmt.Sprint(i))
+	}
+	cfg := useConfig(t, func(c *Config) { c.DownloadPriority = true })
+	swapGlobal(t, &downloads, newDownloadSlots(1))
+
+	images := make([]Image, len(urls))
+	for i, u := range urls {
+		images[i] = Image{URL: u}
+	}
+	// 多次运行以排除协程调度带来的偶然顺序
+	for run := range 20 {
+		before := len(order())
+		fetchImages(context.Background(), cfg, images)
+		if got := order()[before:]; !slices.Equal(got, want) {
+			t.Fatalf("第 %d 次运行的下载顺序 = %v, want %v", run+1, got, want)
+		}
+	}
+}
+
+func TestDownloadPriorityAcrossRequests(t *testing.T) {
+	imgURL, order := newOrderedImageServer(t, testPNG(t, 2, 2))
+	cfg := useConfig(t, func(c *Config) { c.DownloadPriority = true })
+	slots := newDownloadSlots(1)
+	swapGlobal(t, &downloads, slots)
+
+	// 先占住唯一的槽位，使两个请求的下载都进入排队
+	held := slots.enqueue(0)
+	if err := slots.wait(context.Background(), held); err != nil {
+		t.Fatal(err)
+	}
+	queued := func(n int) {
+		t.Helper()
+		waitFor(t, func() bool {
+			slots.mu.Lock()
+			defer slots.mu.Unlock()
+			return len(slots.waiters) == n
+		})
+	}
+
+	var wg sync.WaitGroup
+	fetch := func(name string, n int) {
+		images := make([]Image, n)
+		for i := range images {
+			images[i] = Image{URL: fmt.Sprintf("%s/%s%d", imgURL, name, i)}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fetchImages(context.Background(), cfg, images)
+		}()
+	}
+	fetch("a", 3)
+	queued(3)
+	fetch("b", 1)
+	queued(4)
+
+	slots.release()
+	wg.Wait()
+	// 后到请求的第一张图片排在先到请求靠后的图片之前
+	if got, want := order(), []string{"a0", "b0", "a1", "a2"}; !slices.Equal(got, want) {
+		t.Errorf("下载顺序 = %v, want %v", got, want)
+	}
+}
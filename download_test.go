@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 在请求取消前一直不返回的图片服务器
+func newSlowImageServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSoftDeadlineReturnsPartialResults(t *testing.T) {
+	fast := newImageServer(t, testPNG(t, 4, 4))
+	slow := newSlowImageServer(t)
+	up := newUpstream(t, []string{fast.URL + "/a.png", slow.URL + "/b.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.DownloadSoftDeadline = Duration(200 * time.Millisecond)
+	})
+
+	start := time.Now()
+	w := postGenerations(t, `{"prompt":"x","n":2,"response_format":"b64_json"}`)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("软截止时间未生效，耗时 %v", elapsed)
+	}
+	if w.Code != 200 {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	resp := decodeB64Response(t, w)
+	if len(resp.Data) != 2 {
+		t.Fatalf("data 条目数 = %d, want 2", len(resp.Data))
+	}
+	if resp.Data[0].B64JSON == "" || resp.Data[0].Error != "" {
+		t.Errorf("已完成的图片应正常返回: %+v", resp.Data[0])
+	}
+	if resp.Data[1].B64JSON != "" || resp.Data[1].Error != "download deadline exceeded" {
+		t.Errorf("慢图片应以错误占位: %+v", resp.Data[1])
+	}
+}
+
+// 统计 GET 次数的图片服务器
+func newCountingImageServer(t *testing.T, data []byte, hits *atomic.Int64) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDuplicateURLsDownloadOnce(t *testing.T) {
+	png := testPNG(t, 2, 2)
+	var hits atomic.Int64
+	srv := newCountingImageServer(t, png, &hits)
+	cfg := useConfig(t, nil)
+
+	url := srv.URL + "/same.png"
+	slots := fetchImages(context.Background(), cfg, []Image{{URL: url}, {URL: srv.URL + "/other.png"}, {URL: url}})
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("相同 URL 应只下载一次，共 GET %d 次", n)
+	}
+	for i, s := range slots {
+		if s[0].err != "" || !bytes.Equal(s[0].data, png) {
+			t.Errorf("位置 %d 未填充: %+v", i, s[0].err)
+		}
+	}
+
+	hits.Store(0)
+	cfg = useConfig(t, func(c *Config) { c.DedupDownloads = false })
+	fetchImages(context.Background(), cfg, []Image{{URL: url}, {URL: url}})
+	if n := hits.Load(); n != 2 {
+		t.Errorf("关闭 dedup_downloads 后应各自下载，共 GET %d 次", n)
+	}
+}
+
+func TestDownloadFailureLogSanitized(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusNotFound)
+	}))
+	t.Cleanup(srv.Close)
+	cfg := useConfig(t, func(c *Config) { c.LogURLQueryAllowlist = []string{"size"} })
+	logs := captureLog(t)
+
+	url := srv.URL + "/img.png?size=large&signature=s3cr3t"
+	slots := fetchImages(context.Background(), cfg, []Image{{URL: url}, {URL: url}})
+	if slots[0][0].err != "HTTP 404" {
+		t.Fatalf("err = %q", slots[0][0].err)
+	}
+	out := logs.String()
+	if strings.Contains(out, "s3cr3t") {
+		t.Errorf("日志包含未脱敏的查询参数:\n%s", out)
+	}
+	for _, want := range []string{
+		"url=" + srv.URL + "/img.png?signature=REDACTED&size=large",
+		"class=http_status", "attempt=1/1", "status=404",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("失败日志缺少 %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestDownloadNetworkErrorSanitized(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	addr := srv.URL
+	srv.Close()
+	cfg := useConfig(t, nil)
+	logs := captureLog(t)
+
+	slots := fetchImages(context.Background(), cfg, []Image{{URL: addr + "/img.png?token=s3cr3t"}})
+	if strings.Contains(logs.String(), "s3cr3t") || strings.Contains(slots[0][0].err, "s3cr3t") {
+		t.Errorf("网络错误中的 URL 未脱敏:\n%s\nerr=%s", logs, slots[0][0].err)
+	}
+	if !strings.Contains(logs.String(), "class=network") {
+		t.Errorf("缺少错误类别:\n%s", logs)
+	}
+}
+
+func TestInvalidUpstreamB64Flagged(t *testing.T) {
+	png := testPNG(t, 2, 2)
+	valid := base64.StdEncoding.EncodeToString(png)
+	truncated := base64.StdEncoding.EncodeToString(png[:len(png)/2])
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data":[{"b64_json":%q},{"b64_json":"not*base64!"},{"b64_json":%q}]}`, valid, truncated)
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.IncludeFailedIndices = true
+	})
+
+	resp := decodeB64Response(t, postGenerations(t, `{"prompt":"x","n":3,"response_format":"b64_json"}`))
+	if len(resp.Data) != 3 {
+		t.Fatalf("data 条目数 = %d, want 3", len(resp.Data))
+	}
+	if resp.Data[0].B64JSON != valid || resp.Data[0].Error != "" {
+		t.Errorf("有效的内联图片应原样返回: %+v", resp.Data[0].Error)
+	}
+	if resp.Data[1].B64JSON != "" || resp.Data[1].Error != "invalid base64 image data" {
+		t.Errorf("无效 base64 应标记为失败: b64 %d bytes, error %q", len(resp.Data[1].B64JSON), resp.Data[1].Error)
+	}
+	if resp.Data[2].B64JSON != "" || resp.Data[2].Error != "invalid image data" {
+		t.Errorf("截断的图片应标记为失败: b64 %d bytes, error %q", len(resp.Data[2].B64JSON), resp.Data[2].Error)
+	}
+	if fmt.Sprint(resp.FailedIndices) != "[1 2]" {
+		t.Errorf("failed_indices = %v, want [1 2]", resp.FailedIndices)
+	}
+}
+
+func TestBlockedImageHashWithheld(t *testing.T) {
+	bad, good := testPNG(t, 3, 3), testPNG(t, 2, 2)
+	badSrv, goodSrv := newImageServer(t, bad), newImageServer(t, good)
+	up := newUpstream(t, []string{badSrv.URL + "/a.png", goodSrv.URL + "/b.png"}, nil)
+	sum := sha256.Sum256(bad)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.BlockedImageHashes = []string{strings.ToUpper(hex.EncodeToString(sum[:]))}
+	})
+
+	resp := decodeB64Response(t, postGenerations(t, `{"prompt":"x","n":2,"response_format":"b64_json"}`))
+	if len(resp.Data) != 2 {
+		t.Fatalf("data 条目数 = %d", len(resp.Data))
+	}
+	if resp.Data[0].B64JSON != "" || resp.Data[0].Error != errImageBlocked.Error() {
+		t.Errorf("命中黑名单的图片应以错误条目代替: b64 %d bytes, error %q", len(resp.Data[0].B64JSON), resp.Data[0].Error)
+	}
+	if resp.Data[1].B64JSON != base64.StdEncoding.EncodeToString(good) {
+		t.Error("未命中的图片应正常返回")
+	}
+}
+
+func TestFallbackImageOnTotalFailure(t *testing.T) {
+	placeholder := testPNG(t, 3, 3)
+	path := filepath.Join(t.TempDir(), "fallback.png")
+	if err := os.WriteFile(path, placeholder, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	missing := newUpstreamFunc(t, http.NotFound)
+	up := newUpstream(t, []string{missing.URL + "/0.png", missing.URL + "/1.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.FallbackImage = path
+	})
+
+	w := postGenerations(t, `{"prompt":"x","n":2,"response_format":"b64_json"}`)
+	if w.Header().Get("X-Fallback-Image") != "true" {
+		t.Error("缺少 X-Fallback-Image: true")
+	}
+	resp := decodeB64Response(t, w)
+	if len(resp.Data) != 2 {
+		t.Fatalf("data 条目数 = %d, want 2", len(resp.Data))
+	}
+	want := base64.StdEncoding.EncodeToString(placeholder)
+	for i, item := range resp.Data {
+		if item.B64JSON != want || item.Error != "" {
+			t.Errorf("data[%d] 应为占位图", i)
+		}
+	}
+
+	// 只要有一张成功就不使用占位图
+	img := newImageServer(t, testPNG(t, 2, 2))
+	partial := newUpstream(t, []string{missing.URL + "/0.png", img.URL + "/1.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = partial.URL
+		c.FallbackImage = path
+	})
+	if w := postGenerations(t, `{"prompt":"x","n":2,"response_format":"b64_json"}`); w.Header().Get("X-Fallback-Image") != "" {
+		t.Error("部分成功时不应返回占位图")
+	}
+}
+
+func TestSharedDownloadsAcrossRequests(t *testing.T) {
+	png := testPNG(t, 2, 2)
+	var hits atomic.Int32
+	img := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		time.Sleep(150 * time.Millisecond)
+		w.Write(png)
+	})
+	up := newUpstream(t, []string{img.URL + "/same.png"}, nil)
+
+	for _, shared := range []bool{true, false} {
+		useConfig(t, func(c *Config) {
+			c.UpstreamURL = up.URL
+			c.SharedDownloads = shared
+		})
+		hits.Store(0)
+		results := make(chan *httptest.ResponseRecorder, 2)
+		for range 2 {
+			go func() { results <- postGenerations(t, `{"prompt":"x","seed":1,"response_format":"b64_json"}`) }()
+		}
+		want := base64.StdEncoding.EncodeToString(png)
+		for range 2 {
+			if resp := decodeB64Response(t, <-results); len(resp.Data) != 1 || resp.Data[0].B64JSON != want {
+				t.Errorf("shared_downloads=%v: 请求未拿到图片", shared)
+			}
+		}
+		wantHits := int32(2)
+		if shared {
+			wantHits = 1
+		}
+		if got := hits.Load(); got != wantHits {
+			t.Errorf("shared_downloads=%v: 下载次数 = %d, want %d", shared, got, wantHits)
+		}
+	}
+}
+
+func TestSizeBytesMatchesDownload(t *testing.T) {
+	png := testPNG(t, 8, 8)
+	img := newImageServer(t, png)
+	missing := newUpstreamFunc(t, http.NotFound)
+	up := newUpstream(t, []string{img.URL + "/a.png", missing.URL + "/b.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.IncludeSizeBytes = true
+	})
+
+	resp := decodeB64Response(t, postGenerations(t, `{"prompt":"x","n":2,"response_format":"b64_json"}`))
+	if len(resp.Data) != 2 {
+		t.Fatalf("data 条目数 = %d, want 2", len(resp.Data))
+	}
+	if resp.Data[0].SizeBytes != len(png) {
+		t.Errorf("size_bytes = %d, want %d", resp.Data[0].SizeBytes, len(png))
+	}
+	if resp.Data[1].SizeBytes != 0 {
+		t.Errorf("失败条目不应带 size_bytes，实际 %d", resp.Data[1].SizeBytes)
+	}
+
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	if w := postGenerations(t, `{"prompt":"x","response_format":"b64_json"}`); strings.Contains(w.Body.String(), "size_bytes") {
+		t.Error("未开启 include_size_bytes 时不应返回该字段")
+	}
+}
+
+func TestMixedURLAndInlineImages(t *testing.T) {
+	downloaded, inline := testPNG(t, 2, 2), testPNG(t, 3, 3)
+	img := newImageServer(t, downloaded)
+	inlineB64 := base64.StdEncoding.EncodeToString(inline)
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"images":[{"url":"%[1]s/a.png"},{"b64_json":"%[2]s"},{"url":"%[1]s/c.png"}]}`, img.URL, inlineB64)
+	})
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+
+	resp := decodeB64Response(t, postGenerations(t, `{"prompt":"x","n":3,"response_format":"b64_json"}`))
+	want := []string{base64.StdEncoding.EncodeToString(downloaded), inlineB64, base64.StdEncoding.EncodeToString(downloaded)}
+	if len(resp.Data) != 3 {
+		t.Fatalf("data 条目数 = %d, want 3", len(resp.Data))
+	}
+	for i, item := range resp.Data {
+		if item.B64JSON != want[i] {
+			t.Errorf("data[%d] 内容不正确", i)
+		}
+	}
+
+	// URL 直通模式：inline 原样返回内联数据，drop 丢弃并以 error 标明
+	for policy, check := range map[string]func(Image) bool{
+		"inline": func(img Image) bool { return img.B64JSON == inlineB64 && img.Error == "" },
+		"drop":   func(img Image) bool { return img.B64JSON == "" && img.Error == errInlineImageDropped },
+	} {
+		useConfig(t, func(c *Config) {
+			c.UpstreamURL = up.URL
+			c.MixedImages = policy
+		})
+		w := postGenerations(t, `{"prompt":"x","n":3}`)
+		var urlResp struct {
+			Images []Image `json:"images"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &urlResp); err != nil {
+			t.Fatal(err)
+		}
+		if len(urlResp.Images) != 3 || urlResp.Images[0].URL != img.URL+"/a.png" || urlResp.Images[0].Error != "" {
+			t.Fatalf("mixed_images=%s: images = %+v", policy, urlResp.Images)
+		}
+		if !check(urlResp.Images[1]) {
+			t.Errorf("mixed_images=%s: 内联条目 = error %q, b64_json 长度 %d", policy, urlResp.Images[1].Error, len(urlResp.Images[1].B64JSON))
+		}
+	}
+}
+
+func TestPartialSuccessStatus(t *testing.T) {
+	png := testPNG(t, 2, 2)
+	img := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(png)
+	})
+	body := `{"prompt":"x","n":2,"response_format":"b64_json"}`
+
+	for _, status := range []int{http.StatusOK, http.StatusPartialContent} {
+		up := newUpstream(t, []string{img.URL + "/a.png", img.URL + "/missing.png"}, nil)
+		useConfig(t, func(c *Config) {
+			c.UpstreamURL = up.URL
+			c.PartialSuccessStatus = status
+			c.IncludeFailedIndices = true
+		})
+		w := postGenerations(t, body)
+		if w.Code != status {
+			t.Errorf("部分失败时 status = %d, want %d", w.Code, status)
+		}
+		resp := decodeB64Response(t, w)
+		if len(resp.Data) != 2 || resp.Data[0].B64JSON == "" || resp.Data[1].Error == "" {
+			t.Errorf("部分成功的响应体不正确: %s", w.Body)
+		}
+		if fmt.Sprint(resp.FailedIndices) != "[1]" {
+			t.Errorf("failed_indices = %v, want [1]", resp.FailedIndices)
+		}
+	}
+
+	// 全部成功时不受影响
+	up := newUpstream(t, []string{img.URL + "/a.png", img.URL + "/b.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.PartialSuccessStatus = http.StatusPartialContent
+	})
+	if w := postGenerations(t, body); w.Code != http.StatusOK {
+		t.Errorf("全部成功时 status = %d, want 200", w.Code)
+	}
+}
+
+func TestIncludeOriginalWithConversion(t *testing.T) {
+	animated := testGIF(t, color.RGBA{0xff, 0, 0, 0xff}, color.RGBA{0, 0, 0xff, 0xff})
+	img := newImageServer(t, animated)
+	up := newUpstream(t, []string{img.URL + "/a.gif"}, nil)
+	body := `{"prompt":"x","response_format":"b64_json"}`
+
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.StillFrames = "png"
+		c.IncludeOriginal = true
+	})
+	resp := decodeB64Response(t, postGenerations(t, body))
+	if len(resp.Data) != 1 {
+		t.Fatalf("data = %+v", resp.Data)
+	}
+	converted, _ := base64.StdEncoding.DecodeString(resp.Data[0].B64JSON)
+	original, _ := base64.StdEncoding.DecodeString(resp.Data[0].OriginalB64)
+	if detectFormat(converted) != "png" || detectFormat(original) != "gif" {
+		t.Errorf("b64_json 为 %q，original_b64 为 %q，want png 与 gif", detectFormat(converted), detectFormat(original))
+	}
+	if !bytes.Equal(original, animated) {
+		t.Error("original_b64 应为下载得到的原图")
+	}
+
+	// 图片未被改变时不附带原图
+	static := newImageServer(t, testPNG(t, 2, 2))
+	up2 := newUpstream(t, []string{static.URL + "/a.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up2.URL
+		c.StillFrames = "png"
+		c.IncludeOriginal = true
+	})
+	if resp := decodeB64Response(t, postGenerations(t, body)); resp.Data[0].OriginalB64 != "" {
+		t.Error("未转换的图片不应附带 original_b64")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+var errDownscaleSkipped = errors.New("image within max dimension")
+
+// 宽或高超过 maxDim 时按比例缩小到长边等于 maxDim，并按原格式重新编码；仅处理 PNG 与 JPEG
+func downscaleImage(data []byte, maxDim int) ([]byte, error) {
+	if maxDim <= 0 {
+		return nil, errDownscaleSkipped
+	}
+	format := detectFormat(data)
+	if format != "png" && format != "jpeg" {
+		return nil, errDownscaleSkipped
+	}
+	// 先只读尺寸，未超限的图片不做完整解码
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image config: %w", err)
+	}
+	w, h := cfg.Width, cfg.Height
+	if w <= maxDim && h <= maxDim {
+		return nil, errDownscaleSkipped
+	}
+	if w >= h {
+		w, h = maxDim, max(h*maxDim/w, 1)
+	} else {
+		w, h = max(w*maxDim/h, 1), maxDim
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), xdraw.Src, nil)
+	return encodeImage(dst, format)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"net/http"
+	"testing"
+)
+
+func TestMaxImageDimensionDownscales(t *testing.T) {
+	large, small := testPNG(t, 400, 100), testPNG(t, 50, 30)
+	img := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/large.png" {
+			w.Write(large)
+			return
+		}
+		w.Write(small)
+	})
+	up := newUpstream(t, []string{img.URL + "/large.png", img.URL + "/small.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.MaxImageDimension = 200
+	})
+
+	resp := decodeB64Response(t, postGenerations(t, `{"prompt":"x","n":2,"response_format":"b64_json"}`))
+	if len(resp.Data) != 2 {
+		t.Fatalf("data 条目数 = %d, want 2", len(resp.Data))
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Data[0].B64JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" || cfg.Width != 200 || cfg.Height != 50 {
+		t.Errorf("超限图片应按比例缩小为 200x50 的 PNG，实际 %dx%d %s", cfg.Width, cfg.Height, format)
+	}
+	if resp.Data[1].B64JSON != base64.StdEncoding.EncodeToString(small) {
+		t.Error("未超过上限的图片应原样返回")
+	}
+}
+
+func TestDownscaleKeepsAspectForTallImages(t *testing.T) {
+	out, err := downscaleImage(testPNG(t, 60, 300), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 20 || cfg.Height != 100 {
+		t.Errorf("尺寸 = %dx%d, want 20x100", cfg.Width, cfg.Height)
+	}
+	if _, err := downscaleImage(testPNG(t, 100, 100), 100); err != errDownscaleSkipped {
+		t.Errorf("恰好等于上限时 err = %v, want errDownscaleSkipped", err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// 字段名包含这些词时视为密钥，不出现在 X-Effective-Params 中
+var secretParamWords = []string{"key", "token", "secret", "password", "authorization"}
+
+// 超过该长度的字符串（如内联图片）以长度代替
+const effectiveParamMaxString = 256
+
+// 转发给上游的最终参数，去掉密钥并缩短大字段，供调试参数转换（尺寸映射、默认值、别名）使用
+func effectiveParams(body map[string]interface{}) string {
+	out, _ := json.Marshal(redactParams(body))
+	return string(out)
+}
+
+func redactParams(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			if isSecretParam(k) {
+				m[k] = "REDACTED"
+				continue
+			}
+			m[k] = redactParams(val)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, val := range v {
+			list[i] = redactParams(val)
+		}
+		return list
+	case string:
+		if len(v) > effectiveParamMaxString {
+			return fmt.Sprintf("(%d bytes)", len(v))
+		}
+	}
+	return v
+}
+
+func isSecretParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, w := range secretParamWords {
+		if strings.Contains(name, w) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestEffectiveParamsHeader(t *testing.T) {
+	var forwarded map[string]interface{}
+	up := newUpstream(t, []string{"https://cdn.example/a.png", "https://cdn.example/b.png"}, &forwarded)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.ExposeEffectiveParams = true
+		c.Models = map[string]ModelConfig{"m": {DefaultN: 2}}
+		c.RequestTemplate = map[string]interface{}{"api_key": "sk-template", "num_inference_steps": float64(20)}
+	})
+
+	w := postGenerations(t, `{"model":"m","prompt":"x","size":"512x512","image":"`+strings.Repeat("A", 300)+`"}`)
+	var params map[string]interface{}
+	if err := json.Unmarshal([]byte(w.Header().Get("X-Effective-Params")), &params); err != nil {
+		t.Fatalf("X-Effective-Params 不是有效 JSON: %v (%q)", err, w.Header().Get("X-Effective-Params"))
+	}
+	if _, ok := params["size"]; ok || params["image_size"] != "512x512" {
+		t.Errorf("应反映 size→image_size 映射: %v", params)
+	}
+	if params["n"] != float64(2) || params["num_inference_steps"] != float64(20) {
+		t.Errorf("应包含注入的默认值与模板字段: %v", params)
+	}
+	if params["api_key"] != "REDACTED" {
+		t.Errorf("api_key = %v, want REDACTED", params["api_key"])
+	}
+	if params["image"] != "(300 bytes)" {
+		t.Errorf("长字符串应以长度代替，image = %v", params["image"])
+	}
+	if forwarded["api_key"] != "sk-template" {
+		t.Error("上游仍应收到原始的 api_key")
+	}
+
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	if w := postGenerations(t, `{"prompt":"x"}`); w.Header().Get("X-Effective-Params") != "" {
+		t.Error("未开启 expose_effective_params 时不应返回该响应头")
+	}
+}
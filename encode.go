@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/base64"
+	"runtime"
+)
+
+// CPU 密集的编码/转换阶段独立限流，与下载并发互不影响
+var encodeSlots = make(chan struct{}, runtime.NumCPU())
+
+func newEncodeSlots(n int) chan struct{} {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	return make(chan struct{}, n)
+}
+
+// 在编码槽位内执行 CPU 密集任务
+func withEncodeSlot(fn func()) {
+	encodeSlots <- struct{}{}
+	defer func() { <-encodeSlots }()
+	fn()
+}
+
+func encodeBase64(data []byte) (s string) {
+	withEncodeSlot(func() {
+		s = base64.StdEncoding.EncodeToString(data)
+	})
+	return s
+}
+
+// 按 size 拆分过长的 base64 字符串，未超过时返回 nil
+func splitB64(s string, size int) []string {
+	if size <= 0 || len(s) <= size {
+		return nil
+	}
+	chunks := make([]string, 0, (len(s)+size-1)/size)
+	for len(s) > size {
+		chunks = append(chunks, s[:size])
+		s = s[size:]
+	}
+	return append(chunks, s)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEncodeStageRespectsLimit(t *testing.T) {
+	swapGlobal(t, &encodeSlots, newEncodeSlots(2))
+
+	var active, peak atomic.Int64
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			withEncodeSlot(func() {
+				n := active.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				active.Add(-1)
+			})
+		}()
+	}
+	wg.Wait()
+	if p := peak.Load(); p != 2 {
+		t.Fatalf("同时进行的编码数峰值 = %d, want 2", p)
+	}
+}
+
+func BenchmarkEncodeBase64(b *testing.B) {
+	data := make([]byte, 4<<20)
+	b.SetBytes(int64(len(data)))
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			encodeBase64(data)
+		}
+	})
+}
+
+func TestB64ChunkSize(t *testing.T) {
+	large, small := testPNG(t, 64, 64), testPNG(t, 1, 1)
+	largeSrv, smallSrv := newImageServer(t, large), newImageServer(t, small)
+	up := newUpstream(t, []string{largeSrv.URL + "/a.png", smallSrv.URL + "/b.png"}, nil)
+	const size = 128
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.B64ChunkSize = size
+	})
+
+	resp := decodeB64Response(t, postGenerations(t, `{"prompt":"x","n":2,"response_format":"b64_json"}`))
+	full := base64.StdEncoding.EncodeToString(large)
+	item := resp.Data[0]
+	if item.B64JSON != "" || len(item.B64JSONChunks) != (len(full)+size-1)/size {
+		t.Fatalf("超长图片应拆分为 %d 段，实际 b64_json %d bytes、%d 段", (len(full)+size-1)/size, len(item.B64JSON), len(item.B64JSONChunks))
+	}
+	for i, chunk := range item.B64JSONChunks[:len(item.B64JSONChunks)-1] {
+		if len(chunk) != size {
+			t.Errorf("第 %d 段长度 = %d, want %d", i, len(chunk), size)
+		}
+	}
+	if strings.Join(item.B64JSONChunks, "") != full {
+		t.Error("依次拼接各段应得到完整的 base64")
+	}
+
+	if item := resp.Data[1]; item.B64JSON != base64.StdEncoding.EncodeToString(small) || item.B64JSONChunks != nil {
+		t.Error("未超过长度的图片不应拆分")
+	}
+}
+
+func TestDuplicateImagesReturnedAsRefs(t *testing.T) {
+	same, other := testPNG(t, 2, 2), testPNG(t, 3, 3)
+	img := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/b.png" {
+			w.Write(other)
+			return
+		}
+		w.Write(same)
+	})
+	up := newUpstream(t, []string{img.URL + "/a.png", img.URL + "/b.png", img.URL + "/c.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.DedupResponseImages = true
+	})
+
+	resp := decodeB64Response(t, postGenerations(t, `{"prompt":"x","n":3,"response_format":"b64_json"}`))
+	if len(resp.Data) != 3 {
+		t.Fatalf("data 条目数 = %d, want 3", len(resp.Data))
+	}
+	if resp.Data[0].B64JSON != base64.StdEncoding.EncodeToString(same) || resp.Data[0].Ref != nil {
+		t.Error("首次出现的图片应完整返回")
+	}
+	if resp.Data[1].B64JSON != base64.StdEncoding.EncodeToString(other) || resp.Data[1].Ref != nil {
+		t.Error("内容不同的图片应完整返回")
+	}
+	if resp.Data[2].B64JSON != "" || resp.Data[2].Ref == nil || *resp.Data[2].Ref != 0 {
+		t.Errorf("重复的图片应以 ref 引用位置 0: b64_json 长度 %d, ref %v", len(resp.Data[2].B64JSON), resp.Data[2].Ref)
+	}
+
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	if resp := decodeB64Response(t, postGenerations(t, `{"prompt":"x","n":3,"response_format":"b64_json"}`)); resp.Data[2].B64JSON == "" || resp.Data[2].Ref != nil {
+		t.Error("未开启 dedup_response_images 时应完整返回每张图片")
+	}
+}
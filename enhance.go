@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+var errEnhanceSkipped = errors.New("enhancement not applicable")
+
+// 对图片做锐化（USM）与对比度调整后按原格式重新编码；仅处理 PNG 与 JPEG
+func enhanceImage(cfg EnhanceConfig, data []byte) ([]byte, error) {
+	if cfg.Sharpen <= 0 && cfg.Contrast == 0 {
+		return nil, errEnhanceSkipped
+	}
+	format := detectFormat(data)
+	if format != "png" && format != "jpeg" {
+		return nil, errEnhanceSkipped
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	img := image.NewNRGBA(src.Bounds())
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+
+	if cfg.Sharpen > 0 {
+		unsharpMask(img, cfg.Sharpen)
+	}
+	if cfg.Contrast != 0 {
+		adjustContrast(img, cfg.Contrast)
+	}
+	return encodeImage(img, format)
+}
+
+// 3x3 高斯核
+var gaussianKernel = [3][3]float64{
+	{1.0 / 16, 2.0 / 16, 1.0 / 16},
+	{2.0 / 16, 4.0 / 16, 2.0 / 16},
+	{1.0 / 16, 2.0 / 16, 1.0 / 16},
+}
+
+// out = src + amount * (src - blur)，alpha 通道保持不变
+func unsharpMask(img *image.NRGBA, amount float64) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	orig := append([]uint8(nil), img.Pix...)
+	at := func(x, y, c int) float64 {
+		x = min(max(x, 0), w-1)
+		y = min(max(y, 0), h-1)
+		return float64(orig[y*img.Stride+x*4+c])
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			for c := 0; c < 3; c++ {
+				var blur float64
+				for ky := -1; ky <= 1; ky++ {
+					for kx := -1; kx <= 1; kx++ {
+						blur += gaussianKernel[ky+1][kx+1] * at(x+kx, y+ky, c)
+					}
+				}
+				v := at(x, y, c)
+				img.Pix[y*img.Stride+x*4+c] = clampUint8(v + amount*(v-blur))
+			}
+		}
+	}
+}
+
+// 以中灰为中心拉伸（contrast > 0）或压缩（contrast < 0）
+func adjustContrast(img *image.NRGBA, contrast float64) {
+	factor := 1 + contrast
+	for i := 0; i < len(img.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			img.Pix[i+c] = clampUint8((float64(img.Pix[i+c])-128)*factor + 128)
+		}
+	}
+}
+
+func clampUint8(v float64) uint8 {
+	switch {
+	case v < 0:
+		return 0
+	case v > 255:
+		return 255
+	}
+	return uint8(v + 0.5)
+}
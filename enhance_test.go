@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// 2px 宽的明暗竖条纹，每个像素都邻近边缘，锐化效果明显
+func testStripePNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := range 16 {
+		for x := range 16 {
+			v := uint8(96)
+			if x%4 >= 2 {
+				v = 160
+			}
+			img.Set(x, y, color.NRGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// 两张同尺寸图片 RGB 通道的平均绝对差
+func meanAbsDiff(t *testing.T, a, b []byte) float64 {
+	t.Helper()
+	ia, _, err := image.Decode(bytes.NewReader(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ib, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum float64
+	bounds := ia.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ca := color.NRGBAModel.Convert(ia.At(x, y)).(color.NRGBA)
+			cb := color.NRGBAModel.Convert(ib.At(x, y)).(color.NRGBA)
+			for _, d := range []int{int(ca.R) - int(cb.R), int(ca.G) - int(cb.G), int(ca.B) - int(cb.B)} {
+				sum += float64(max(d, -d))
+			}
+		}
+	}
+	return sum / float64(bounds.Dx()*bounds.Dy()*3)
+}
+
+func TestEnhanceChangesImage(t *testing.T) {
+	src := testStripePNG(t)
+	for name, cfg := range map[string]EnhanceConfig{
+		"锐化":  {Sharpen: 1},
+		"对比度": {Contrast: 0.5},
+	} {
+		out, err := enhanceImage(cfg, src)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if d := meanAbsDiff(t, src, out); d < 5 {
+			t.Errorf("%s 后平均差异 %.2f，处理未生效", name, d)
+		}
+	}
+}
+
+func TestEnhanceSkips(t *testing.T) {
+	if _, err := enhanceImage(EnhanceConfig{}, testStripePNG(t)); !errors.Is(err, errEnhanceSkipped) {
+		t.Errorf("未配置强度时 err = %v, want errEnhanceSkipped", err)
+	}
+	if _, err := enhanceImage(EnhanceConfig{Sharpen: 1}, []byte("GIF89a")); !errors.Is(err, errEnhanceSkipped) {
+		t.Errorf("非 PNG/JPEG 时 err = %v, want errEnhanceSkipped", err)
+	}
+
+	// 解码失败时保留原图
+	broken := testStripePNG(t)[:40]
+	cfg := useConfig(t, func(c *Config) { c.Enhance.Sharpen = 1 })
+	if out := processImage(cfg, broken, 0); !bytes.Equal(out, broken) {
+		t.Error("解码失败的图片应原样保留")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 默认推理步数，客户端未指定时使用
+const defaultInferenceSteps = 20
+
+// 粗略估算生成耗时：基础耗时 + 张数 × 步数 × 单步耗时 × 百万像素数
+func estimateGenerationTime(cfg AsyncConfig, reqBody map[string]interface{}) time.Duration {
+	n := intParam(reqBody["n"], intParam(reqBody["batch_size"], 1))
+	steps := intParam(reqBody["num_inference_steps"], intParam(reqBody["steps"], defaultInferenceSteps))
+
+	megapixels := 1.0
+	for _, key := range []string{"size", "image_size"} {
+		if size, ok := reqBody[key].(string); ok {
+			if w, h, ok := parseSize(size); ok {
+				megapixels = float64(w*h) / 1e6
+			}
+			break
+		}
+	}
+
+	perImage := float64(cfg.EstimatePerStep) * float64(steps) * megapixels
+	return time.Duration(cfg.EstimateBase) + time.Duration(float64(n)*perImage)
+}
+
+// 解析 "1024x1024" 形式的尺寸
+func parseSize(s string) (w, h int, ok bool) {
+	ws, hs, found := strings.Cut(strings.ToLower(strings.TrimSpace(s)), "x")
+	if !found {
+		return 0, 0, false
+	}
+	w, err1 := strconv.Atoi(ws)
+	h, err2 := strconv.Atoi(hs)
+	if err1 != nil || err2 != nil || w <= 0 || h <= 0 {
+		return 0, 0, false
+	}
+	return w, h, true
+}
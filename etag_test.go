@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIfNoneMatchReturns304(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	up := newUpstream(t, []string{img.URL + "/a.png"}, nil)
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	body := `{"prompt":"x","seed":42,"response_format":"b64_json"}`
+
+	first := postGenerations(t, body)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("status = %d, ETag = %q", first.Code, etag)
+	}
+	if again := postGenerations(t, body); again.Header().Get("ETag") != etag {
+		t.Fatalf("相同内容的 ETag 不一致: %q != %q", again.Header().Get("ETag"), etag)
+	}
+
+	w := postGenerations(t, body, "If-None-Match", `"other", `+etag)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("If-None-Match 命中时 status = %d, body %d bytes, want 304 且无响应体", w.Code, w.Body.Len())
+	}
+	if w := postGenerations(t, body, "If-None-Match", `"other"`); w.Code != http.StatusOK {
+		t.Errorf("ETag 不匹配时 status = %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CloudEvents 事件类型
+const (
+	eventRequestReceived     = "com.siliconcloud.image.request.received"
+	eventGenerationCompleted = "com.siliconcloud.image.generation.completed"
+)
+
+// CloudEvents 1.0 结构化格式
+type CloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Time            string      `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+}
+
+// 生成事件的数据部分；received 事件仅包含 model 与 n
+type GenerationEventData struct {
+	RequestID  string `json:"request_id"`
+	Model      string `json:"model,omitempty"`
+	N          int    `json:"n"`
+	Status     int    `json:"status,omitempty"`
+	Images     int    `json:"images,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+}
+
+type eventEmitter struct {
+	cfg    CloudEventsConfig
+	client *http.Client
+	mu     sync.Mutex
+	w      io.Writer
+}
+
+var cloudEvents *eventEmitter
+
+// 根据配置创建事件发送器，未启用时返回 nil
+func newEventEmitter(cfg CloudEventsConfig) *eventEmitter {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &eventEmitter{
+		cfg:    cfg,
+		client: &http.Client{Timeout: time.Duration(cfg.Timeout)},
+		w:      os.Stdout,
+	}
+}
+
+// 发送一个事件；HTTP 与 Kafka 在后台发送，失败只记录日志，不影响请求
+func (e *eventEmitter) Emit(typ string, data GenerationEventData) {
+	if e == nil {
+		return
+	}
+	ev := CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              randomName(""),
+		Source:          e.cfg.Source,
+		Type:            typ,
+		Time:            time.Now().UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+	if e.cfg.Sink == "stdout" {
+		e.writeLine(ev)
+		return
+	}
+	go func() {
+		if err := e.send(ev); err != nil {
+			log.Printf("[ERROR] CloudEvent 发送失败 type=%s: %v", typ, err)
+		}
+	}()
+}
+
+func (e *eventEmitter) writeLine(ev CloudEvent) {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("[ERROR] CloudEvent 序列化失败: %v", err)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w.Write(append(line, '\n'))
+}
+
+// HTTP 以结构化模式投递；Kafka 经 Kafka REST Proxy 写入 topic，key 为事件 ID
+func (e *eventEmitter) send(ev CloudEvent) error {
+	url, contentType := e.cfg.URL, "application/cloudevents+json"
+	var payload interface{} = ev
+	if e.cfg.Sink == "kafka" {
+		url = strings.TrimRight(e.cfg.URL, "/") + "/topics/" + e.cfg.Topic
+		contentType = "application/vnd.kafka.json.v2+json"
+		payload = map[string]interface{}{
+			"records": []map[string]interface{}{{"key": ev.ID, "value": ev}},
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sink 返回 %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCloudEventDeliveredOverHTTP(t *testing.T) {
+	received := make(chan CloudEvent, 4)
+	sink := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/cloudevents+json" {
+			t.Errorf("Content-Type = %q, want application/cloudevents+json", ct)
+		}
+		var ev CloudEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("事件不是有效 JSON: %v", err)
+		}
+		received <- ev
+	})
+	up := newUpstream(t, []string{"https://cdn.example/a.png", "https://cdn.example/b.png"}, nil)
+	cfg := useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.CloudEvents = CloudEventsConfig{Enabled: true, Sink: "http", URL: sink.URL, Source: "/test", Timeout: Duration(time.Second)}
+	})
+	swapGlobal(t, &cloudEvents, newEventEmitter(cfg.CloudEvents))
+
+	postGenerations(t, `{"model":"m","prompt":"x","n":2}`)
+
+	var completed *CloudEvent
+	deadline := time.After(2 * time.Second)
+	for completed == nil {
+		select {
+		case ev := <-received:
+			if ev.Type == eventGenerationCompleted {
+				completed = &ev
+			}
+		case <-deadline:
+			t.Fatal("未收到 generation.completed 事件")
+		}
+	}
+	if completed.SpecVersion != "1.0" || completed.ID == "" || completed.Source != "/test" || completed.DataContentType != "application/json" {
+		t.Errorf("CloudEvent 属性不正确: %+v", completed)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, completed.Time); err != nil {
+		t.Errorf("time = %q 不是 RFC 3339", completed.Time)
+	}
+	data, _ := completed.Data.(map[string]interface{})
+	if data["model"] != "m" || data["n"] != float64(2) || data["status"] != float64(200) || data["images"] != float64(2) || data["request_id"] == "" {
+		t.Errorf("data = %v", completed.Data)
+	}
+}
+
+func TestCloudEventsConfigValidated(t *testing.T) {
+	for _, ce := range []CloudEventsConfig{
+		{Enabled: true, Sink: "http"},
+		{Enabled: true, Sink: "kafka", URL: "http://rest-proxy"},
+		{Enabled: true, Sink: "nats"},
+	} {
+		cfg := defaultConfig()
+		cfg.CloudEvents = ce
+		if err := cfg.prepare(); err == nil {
+			t.Errorf("%+v 应报错", ce)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// 客户端 IP 的国家与 ASN，用于滥用分析时丰富请求日志
+type geoInfo struct {
+	Country string
+	ASN     uint64
+	ASOrg   string
+}
+
+// 按配置打开的 GeoIP 数据库，库缺失或损坏时对应查询返回空
+type geoResolver struct {
+	country *maxminddb.Reader
+	asn     *maxminddb.Reader
+}
+
+// GeoLite2/GeoIP2 Country 与 ASN 库中用到的字段
+type geoCountryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+type geoASNRecord struct {
+	Number       uint64 `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+var geo *geoResolver
+
+func newGeoResolver(cfg GeoIPConfig) *geoResolver {
+	if !cfg.Enabled {
+		return nil
+	}
+	open := func(path string) *maxminddb.Reader {
+		if path == "" {
+			return nil
+		}
+		db, err := maxminddb.Open(path)
+		if err != nil {
+			log.Printf("[WARN] GeoIP 数据库 %s 不可用，日志中不记录对应字段: %v", path, err)
+			return nil
+		}
+		return db
+	}
+	return &geoResolver{country: open(cfg.CountryDatabase), asn: open(cfg.ASNDatabase)}
+}
+
+// 只收录 IPv4 的库查询 IPv6 地址时库会报错，直接视为未收录
+func supportsIP(db *maxminddb.Reader, ip net.IP) bool {
+	return db.Metadata.IPVersion == 6 || ip.To4() != nil
+}
+
+func (g *geoResolver) lookup(ip string) geoInfo {
+	var info geoInfo
+	parsed := net.ParseIP(ip)
+	if g == nil || parsed == nil {
+		return info
+	}
+	if g.country != nil && supportsIP(g.country, parsed) {
+		var rec geoCountryRecord
+		if err := g.country.Lookup(parsed, &rec); err != nil {
+			log.Printf("[WARN] GeoIP 国家查询失败: %v", err)
+		} else {
+			info.Country = rec.Country.ISOCode
+			if info.Country == "" {
+				info.Country = rec.RegisteredCountry.ISOCode
+			}
+		}
+	}
+	if g.asn != nil && supportsIP(g.asn, parsed) {
+		var rec geoASNRecord
+		if err := g.asn.Lookup(parsed, &rec); err != nil {
+			log.Printf("[WARN] GeoIP ASN 查询失败: %v", err)
+		} else {
+			info.ASN, info.ASOrg = rec.Number, rec.Organization
+		}
+	}
+	return info
+}
+
+// 请求日志中追加的 ip/country/asn 字段；未开启时为空
+func (g *geoResolver) logFields(ip string) string {
+	if g == nil {
+		return ""
+	}
+	info := g.lookup(ip)
+	s := " ip=" + ip
+	if info.Country != "" {
+		s += " country=" + info.Country
+	}
+	if info.ASN != 0 {
+		s += " asn=AS" + strconv.FormatUint(info.ASN, 10)
+	}
+	if info.ASOrg != "" {
+		s += " as_org=" + strconv.Quote(info.ASOrg)
+	}
+	return s
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// 按 MaxMind DB 格式编码测试数据，只支持 string、uint32 与 map，长度不超过 284。
+// 仅用于生成测试库，读取由 maxminddb-golang 负责
+func encodeMMDB(t testing.TB, v interface{}) []byte {
+	t.Helper()
+	ctrl := func(typ, size int) []byte {
+		switch {
+		case size < 29:
+			return []byte{byte(typ<<5 | size)}
+		case size < 285:
+			return []byte{byte(typ<<5 | 29), byte(size - 29)}
+		}
+		t.Fatalf("测试数据过长: %d", size)
+		return nil
+	}
+	switch v := v.(type) {
+	case string:
+		return append(ctrl(2, len(v)), v...)
+	case uint32:
+		b := binary.BigEndian.AppendUint32(nil, v)
+		return append(ctrl(6, 4), b...)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		out := ctrl(7, len(v))
+		for _, k := range keys {
+			out = append(out, encodeMMDB(t, k)...)
+			out = append(out, encodeMMDB(t, v[k])...)
+		}
+		return out
+	}
+	t.Fatalf("不支持的类型 %T", v)
+	return nil
+}
+
+// 生成只收录 prefix 网段的 IPv4 库（record_size 32），网段内的 IP 均查到 record
+func buildMMDB(t testing.TB, prefix string, record map[string]interface{}) []byte {
+	t.Helper()
+	_, ipnet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := ipnet.IP.To4()
+	bits, _ := ipnet.Mask.Size()
+	nodeCount := uint32(bits)
+
+	// 第 i 个节点沿网段的第 i 位指向下一个节点，另一侧为“未收录”；最后一个节点指向数据段起点
+	var tree []byte
+	for i := range bits {
+		bit := ip[i/8] >> (7 - i%8) & 1
+		next := uint32(i + 1)
+		if i == bits-1 {
+			next = nodeCount + 16
+		}
+		recs := [2]uint32{nodeCount, nodeCount}
+		recs[bit] = next
+		tree = binary.BigEndian.AppendUint32(tree, recs[0])
+		tree = binary.BigEndian.AppendUint32(tree, recs[1])
+	}
+
+	buf := append(tree, make([]byte, 16)...)
+	buf = append(buf, encodeMMDB(t, record)...)
+	buf = append(buf, "\xab\xcd\xefMaxMind.com"...)
+	return append(buf, encodeMMDB(t, map[string]interface{}{
+		"binary_format_major_version": uint32(2),
+		"binary_format_minor_version": uint32(0),
+		"build_epoch":                 uint32(1700000000),
+		"database_type":               "Test",
+		"description":                 map[string]interface{}{},
+		"node_count":                  nodeCount,
+		"record_size":                 uint32(32),
+		"ip_version":                  uint32(4),
+	})...)
+}
+
+func writeMMDB(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testGeoResolver(t *testing.T) *geoResolver {
+	t.Helper()
+	country := buildMMDB(t, "192.0.2.0/24", map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "JP"},
+	})
+	asn := buildMMDB(t, "192.0.2.0/24", map[string]interface{}{
+		"autonomous_system_number":       uint32(64500),
+		"autonomous_system_organization": "Example Net",
+	})
+	return newGeoResolver(GeoIPConfig{
+		Enabled:         true,
+		CountryDatabase: writeMMDB(t, "country.mmdb", country),
+		ASNDatabase:     writeMMDB(t, "asn.mmdb", asn),
+	})
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	g := testGeoResolver(t)
+	if g.country == nil || g.asn == nil {
+		t.Fatal("测试库应能正常打开")
+	}
+	if got := g.lookup("192.0.2.77"); got != (geoInfo{Country: "JP", ASN: 64500, ASOrg: "Example Net"}) {
+		t.Errorf("192.0.2.77: %+v", got)
+	}
+	if got := g.lookup("198.51.100.1"); got != (geoInfo{}) {
+		t.Errorf("未收录的 IP 应返回空: %+v", got)
+	}
+	if got := g.lookup("2001:db8::1"); got != (geoInfo{}) {
+		t.Errorf("IPv4 库查询 IPv6 应返回空: %+v", got)
+	}
+}
+
+func TestGeoIPFieldsLogged(t *testing.T) {
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, nil)
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	swapGlobal(t, &geo, testGeoResolver(t))
+
+	// httptest 请求的 RemoteAddr 为 192.0.2.1
+	logs := captureLog(t)
+	postGenerations(t, `{"prompt":"x"}`)
+	want := `ip=192.0.2.1 country=JP asn=AS64500 as_org="Example Net"`
+	if !strings.Contains(logs.String(), want) {
+		t.Errorf("[REQUEST] 日志缺少 %q:\n%s", want, logs)
+	}
+
+	// 数据库缺失时只记录 IP，请求照常处理
+	swapGlobal(t, &geo, newGeoResolver(GeoIPConfig{Enabled: true, CountryDatabase: filepath.Join(t.TempDir(), "missing.mmdb")}))
+	logs = captureLog(t)
+	if w := postGenerations(t, `{"prompt":"x"}`); w.Code != 200 {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if !strings.Contains(logs.String(), " ip=192.0.2.1\n") {
+		t.Errorf("数据库缺失时应只记录 IP:\n%s", logs)
+	}
+	if strings.Contains(logs.String(), "country=") {
+		t.Errorf("数据库缺失时不应记录 country:\n%s", logs)
+	}
+}
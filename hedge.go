@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 最近的上游响应耗时，用于计算对冲延迟
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	size    int
+}
+
+var upstreamLatency = &latencyTracker{size: 200}
+
+func (t *latencyTracker) Observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.samples) < t.size {
+		t.samples = append(t.samples, d)
+		return
+	}
+	t.samples[t.next] = d
+	t.next = (t.next + 1) % t.size
+}
+
+// 返回第 p 百分位（0-100）的耗时，样本不足 minSamples 时返回 false
+func (t *latencyTracker) Percentile(p float64, minSamples int) (time.Duration, bool) {
+	t.mu.Lock()
+	sorted := append([]time.Duration(nil), t.samples...)
+	t.mu.Unlock()
+	if len(sorted) == 0 || len(sorted) < minSamples {
+		return 0, false
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(float64(len(sorted)-1) * p / 100)
+	return sorted[min(max(idx, 0), len(sorted)-1)], true
+}
+
+// 对冲延迟：样本充足时取配置的百分位，否则使用固定延迟
+func hedgeDelay(cfg HedgeConfig) time.Duration {
+	delay := time.Duration(cfg.Delay)
+	if p, ok := upstreamLatency.Percentile(cfg.Percentile, cfg.MinSamples); ok {
+		delay = p
+	}
+	return max(delay, time.Duration(cfg.MinDelay))
+}
+
+type hedgeResult struct {
+	resp    *http.Response
+	err     error
+	cancel  context.CancelFunc
+	attempt int
+}
+
+// 响应体关闭时再取消对应请求的 ctx
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// 发送上游请求；开启对冲时若超过延迟仍未响应，再发一份相同请求，取先返回者并取消另一方
+// 每次实际发出的调用结束时回调 record
+func doUpstream(client *http.Client, cfg HedgeConfig, req *http.Request, body []byte, record func(resp *http.Response, err error, latency time.Duration)) (*http.Response, error) {
+	start := time.Now()
+	if !cfg.Enabled {
+		resp, err := client.Do(req)
+		record(resp, err, time.Since(start))
+		if err == nil {
+			upstreamLatency.Observe(time.Since(start))
+		}
+		return resp, err
+	}
+
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+	launch := func(attempt int) {
+		ctx, cancel := context.WithCancel(req.Context())
+		cancels = append(cancels, cancel)
+		clone := req.Clone(ctx)
+		clone.Body = io.NopCloser(bytes.NewReader(body))
+		go func() {
+			begin := time.Now()
+			resp, err := client.Do(clone)
+			record(resp, err, time.Since(begin))
+			results <- hedgeResult{resp: resp, err: err, cancel: cancel, attempt: attempt}
+		}()
+	}
+
+	launch(1)
+	inflight := 1
+	timer := time.NewTimer(hedgeDelay(cfg))
+	defer timer.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case <-timer.C:
+			if inflight == 1 && takeRetry(req.Context(), "hedge") {
+				log.Printf("[HEDGE] 上游 %v 未响应，发起对冲请求", time.Since(start))
+				upstreamHedges.WithLabelValues("fired").Inc()
+				launch(2)
+				inflight++
+			}
+		case res := <-results:
+			inflight--
+			if res.err != nil {
+				res.cancel()
+				lastErr = res.err
+				if inflight == 0 {
+					return nil, lastErr
+				}
+				continue
+			}
+			upstreamLatency.Observe(time.Since(start))
+			if res.attempt == 2 {
+				log.Printf("[HEDGE] 对冲请求先返回，耗时: %v", time.Since(start))
+				upstreamHedges.WithLabelValues("won").Inc()
+			}
+			// 取消仍在进行的另一方
+			if inflight > 0 {
+				for i, cancel := range cancels {
+					if i+1 != res.attempt {
+						cancel()
+					}
+				}
+				go drainHedgeLoser(results)
+			}
+			res.resp.Body = cancelOnClose{ReadCloser: res.resp.Body, cancel: res.cancel}
+			return res.resp, nil
+		}
+	}
+}
+
+func drainHedgeLoser(results <-chan hedgeResult) {
+	res := <-results
+	res.cancel()
+	if res.resp != nil {
+		res.resp.Body.Close()
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedgeReturnsFasterResponse(t *testing.T) {
+	var calls atomic.Int32
+	loserCanceled := make(chan struct{})
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		// 读完请求体后服务端才会感知连接断开
+		io.ReadAll(r.Body)
+		if calls.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+				close(loserCanceled)
+			case <-time.After(2 * time.Second):
+				w.Write([]byte(`{"images":[{"url":"https://cdn.example/slow.png"}]}`))
+			}
+			return
+		}
+		w.Write([]byte(`{"images":[{"url":"https://cdn.example/fast.png"}]}`))
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Hedge.Enabled = true
+		c.Hedge.Delay = Duration(30 * time.Millisecond)
+		c.Hedge.MinDelay = 0
+		c.Hedge.MinSamples = 1000
+	})
+	swapGlobal(t, &upstreamLatency, &latencyTracker{size: 200})
+
+	start := time.Now()
+	w := postGenerations(t, `{"prompt":"x"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "fast.png") {
+		t.Fatalf("status = %d, body = %s, want 对冲请求的结果", w.Code, w.Body)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("耗时 %v，对冲未生效", elapsed)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("上游调用次数 = %d, want 2", calls.Load())
+	}
+	select {
+	case <-loserCanceled:
+	case <-time.After(time.Second):
+		t.Error("较慢的请求未被取消")
+	}
+}
+
+func TestHedgeDisabledSendsSingleRequest(t *testing.T) {
+	var calls atomic.Int32
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(80 * time.Millisecond)
+		w.Write([]byte(`{"images":[{"url":"https://cdn.example/a.png"}]}`))
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Hedge.Delay = Duration(10 * time.Millisecond)
+	})
+	if w := postGenerations(t, `{"prompt":"x"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("默认关闭对冲时上游调用次数 = %d, want 1", calls.Load())
+	}
+}
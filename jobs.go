@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// 后台生成任务
+type Job struct {
+	mu         sync.Mutex
+	id         string
+	status     string
+	progress   float64
+	statusCode int
+	body       []byte
+	createdAt  time.Time
+	finishedAt time.Time
+}
+
+// 任务状态接口的响应
+type JobStatus struct {
+	ID       string          `json:"id"`
+	Status   string          `json:"status"`
+	Progress float64         `json:"progress"`
+	Result   json.RawMessage `json:"result,omitempty"`
+	Error    json.RawMessage `json:"error,omitempty"`
+}
+
+func (j *Job) setProgress(p float64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	// 进度只增不减
+	if p > j.progress {
+		j.progress = p
+	}
+}
+
+func (j *Job) setStatus(status string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = status
+}
+
+func (j *Job) finish(statusCode int, body []byte) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.statusCode = statusCode
+	j.body = body
+	j.finishedAt = time.Now()
+	if statusCode < 400 {
+		j.status = jobSucceeded
+		j.progress = 100
+	} else {
+		j.status = jobFailed
+	}
+}
+
+func (j *Job) snapshot() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := JobStatus{ID: j.id, Status: j.status, Progress: j.progress}
+	switch j.status {
+	case jobSucceeded:
+		s.Result = rawJSONOrString(j.body)
+	case jobFailed:
+		s.Error = rawJSONOrString(j.body)
+	}
+	return s
+}
+
+// 任务结果若不是合法 JSON 则作为字符串返回
+func rawJSONOrString(b []byte) json.RawMessage {
+	b = bytes.TrimSpace(b)
+	if json.Valid(b) {
+		return b
+	}
+	s, _ := json.Marshal(string(b))
+	return s
+}
+
+type jobStore struct {
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	ttl     time.Duration
+	running sync.WaitGroup
+	closed  bool // 关闭中，不再接受新任务
+}
+
+var jobs = &jobStore{jobs: make(map[string]*Job), ttl: time.Hour}
+
+// 创建任务并计入运行中的任务，关闭中返回 false；任务结束时须调用 done
+func (s *jobStore) create() (*Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, false
+	}
+	s.purgeLocked()
+	job := &Job{id: "job_" + randomName(""), status: jobQueued, createdAt: time.Now()}
+	s.jobs[job.id] = job
+	s.running.Add(1)
+	return job, true
+}
+
+func (s *jobStore) done() { s.running.Done() }
+
+// 停止接受新任务并等待运行中的任务完成，ctx 到期时返回仍未完成的任务
+func (s *jobStore) drain(ctx context.Context) []string {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var abandoned []string
+	for id, job := range s.jobs {
+		job.mu.Lock()
+		if job.finishedAt.IsZero() {
+			abandoned = append(abandoned, id)
+		}
+		job.mu.Unlock()
+	}
+	return abandoned
+}
+
+// 查询任务，顺带清理过期任务，避免长时间没有新任务时已完成的结果一直占用内存
+func (s *jobStore) get(id string) (*Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeLocked()
+	job, ok := s.jobs[id]
+	return job, ok
+}
+
+// 清理已完成且超过保留时间的任务
+func (s *jobStore) purgeLocked() {
+	for id, job := range s.jobs {
+		job.mu.Lock()
+		expired := !job.finishedAt.IsZero() && time.Since(job.finishedAt) > s.ttl
+		job.mu.Unlock()
+		if expired {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+type progressKey struct{}
+
+// 向当前请求关联的任务上报进度，非异步请求时忽略
+func reportProgress(ctx context.Context, p float64) {
+	if job, ok := ctx.Value(progressKey{}).(*Job); ok {
+		job.setProgress(p)
+	}
+}
+
+// 在内存中缓存后台任务写出的响应
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// 客户端要求异步处理（Prefer: respond-async）
+func wantsAsync(r *http.Request) bool {
+	for _, pref := range strings.Split(r.Header.Get("Prefer"), ",") {
+		if strings.EqualFold(strings.TrimSpace(pref), "respond-async") {
+			return true
+		}
+	}
+	return false
+}
+
+// 异步中间件：客户端要求异步或预计耗时超过阈值时，立即返回 202 与任务地址，生成在后台继续
+func withAsync(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ac := currentConfig().Async
+		if !ac.Enabled {
+			next(w, r)
+			return
+		}
+		if wantsAsync(r) || isLongGeneration(ac, r) {
+			startJob(w, r, next)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// 按请求参数估算耗时，超过 long_request_threshold 时转为异步。
+// 读取后的请求体会还原，供后续处理继续使用
+func isLongGeneration(ac AsyncConfig, r *http.Request) bool {
+	if ac.LongRequestThreshold <= 0 {
+		return false
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	var reqBody map[string]interface{}
+	if json.Unmarshal(body, &reqBody) != nil {
+		return false
+	}
+	estimate := estimateGenerationTime(ac, reqBody)
+	if estimate <= time.Duration(ac.LongRequestThreshold) {
+		return false
+	}
+	log.Printf("[JOB] 预计耗时 %v 超过阈值 %v，转为异步任务", estimate, time.Duration(ac.LongRequestThreshold))
+	return true
+}
+
+func startJob(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+
+	job, ok := jobs.create()
+	if !ok {
+		log.Printf("[REJECT] 服务正在关闭，不再接受异步任务")
+		http.Error(w, `{"error":"Server is shutting down"}`, http.StatusServiceUnavailable)
+		return
+	}
+	ctx := context.WithValue(context.WithoutCancel(r.Context()), progressKey{}, job)
+	bg := r.Clone(ctx)
+	bg.Body = io.NopCloser(bytes.NewReader(body))
+	bg.Header.Del("Prefer")
+
+	go func() {
+		defer jobs.done()
+		job.setStatus(jobRunning)
+		rec := &bufferedResponse{header: make(http.Header)}
+		next(rec, bg)
+		job.finish(rec.status, rec.body.Bytes())
+		log.Printf("[JOB] 任务 %s 完成，状态码: %d", job.id, rec.status)
+	}()
+
+	log.Printf("[JOB] 已创建异步任务: %s", job.id)
+	location := "/v1/images/jobs/" + job.id
+	w.Header().Set("Location", location)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job.snapshot())
+}
+
+// 查询任务状态与进度
+func handleJobStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, `{"error":"Job not found"}`, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job.snapshot())
+}
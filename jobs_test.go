@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func pollJob(t *testing.T, mux *http.ServeMux, location string) JobStatus {
+	t.Helper()
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, location, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d", location, w.Code)
+	}
+	var s JobStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// 轮询任务状态直到 cond 成立
+func waitJob(t *testing.T, mux *http.ServeMux, location string, cond func(JobStatus) bool) JobStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s := pollJob(t, mux, location)
+		if cond(s) {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待任务状态超时，最后状态: %+v", s)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJobStatusReflectsUpstreamProgress(t *testing.T) {
+	var finished atomic.Bool
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			w.Write([]byte(`{"request_id":"up-1","status":"InQueue"}`))
+		case !finished.Load():
+			w.Write([]byte(`{"status":"InProgress","progress":0.4}`))
+		default:
+			w.Write([]byte(`{"status":"Succeed","results":{"images":[{"url":"https://cdn.example/a.png"}]}}`))
+		}
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Async.Enabled = true
+		c.UpstreamAsync.Enabled = true
+		c.UpstreamAsync.StatusURL = up.URL + "/status/{id}"
+		c.UpstreamAsync.PollInterval = Duration(10 * time.Millisecond)
+	})
+	swapGlobal(t, &jobs, &jobStore{jobs: make(map[string]*Job), ttl: time.Hour})
+	t.Cleanup(func() { finished.Store(true); jobs.running.Wait() })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/images/jobs/{id}", handleJobStatus)
+
+	w := serveGenerations(t, withAsync(handleGenerations), `{"prompt":"x"}`, "Prefer", "respond-async")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", w.Code)
+	}
+	location := w.Header().Get("Location")
+
+	s := waitJob(t, mux, location, func(s JobStatus) bool { return s.Progress > 0 })
+	if s.Status != jobRunning || s.Progress != 40 {
+		t.Fatalf("上游报告 0.4 时任务应为 running/40，实际 %s/%v", s.Status, s.Progress)
+	}
+
+	finished.Store(true)
+	s = waitJob(t, mux, location, func(s JobStatus) bool { return s.Status != jobRunning })
+	if s.Status != jobSucceeded || s.Progress != 100 || len(s.Result) == 0 {
+		t.Fatalf("完成后的任务状态不正确: %+v", s)
+	}
+}
+
+func TestLongGenerationReturns202(t *testing.T) {
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Async.Enabled = true
+		c.Async.LongRequestThreshold = Duration(10 * time.Second)
+	})
+	swapGlobal(t, &jobs, &jobStore{jobs: make(map[string]*Job), ttl: time.Hour})
+	t.Cleanup(func() { jobs.running.Wait() })
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/images/jobs/{id}", handleJobStatus)
+	h := withAsync(handleGenerations)
+
+	// 预计 2s + 4 × 50 × 100ms × 1.05MP ≈ 23s，超过阈值
+	w := serveGenerations(t, h, `{"prompt":"x","n":4,"num_inference_steps":50,"image_size":"1024x1024"}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", w.Code)
+	}
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, "/v1/images/jobs/job_") {
+		t.Fatalf("Location = %q", location)
+	}
+	s := waitJob(t, mux, location, func(s JobStatus) bool { return s.Status == jobSucceeded || s.Status == jobFailed })
+	if s.Status != jobSucceeded || !strings.Contains(string(s.Result), "cdn.example") {
+		t.Errorf("后台生成结果不正确: %+v", s)
+	}
+
+	// 预计 2s + 20 × 100ms = 4s，同步返回
+	if w := serveGenerations(t, h, `{"prompt":"x"}`); w.Code != http.StatusOK {
+		t.Errorf("短请求 status = %d, want 200", w.Code)
+	}
+}
+
+func TestShutdownDrainsAsyncJobs(t *testing.T) {
+	release := make(chan struct{})
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"images":[{"url":"https://cdn.example/a.png"}]}`))
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Async.Enabled = true
+	})
+	swapGlobal(t, &jobs, &jobStore{jobs: make(map[string]*Job), ttl: time.Hour})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/images/jobs/{id}", handleJobStatus)
+	h := withAsync(handleGenerations)
+
+	w := serveGenerations(t, h, `{"prompt":"x"}`, "Prefer", "respond-async")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", w.Code)
+	}
+	location := w.Header().Get("Location")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	drained := make(chan []string, 1)
+	go func() { drained <- jobs.drain(ctx) }()
+
+	// 关闭期间不再接受新任务
+	waitFor(t, func() bool {
+		jobs.mu.Lock()
+		defer jobs.mu.Unlock()
+		return jobs.closed
+	})
+	if w := serveGenerations(t, h, `{"prompt":"x"}`, "Prefer", "respond-async"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("关闭期间的异步请求 status = %d, want 503", w.Code)
+	}
+
+	close(release)
+	select {
+	case abandoned := <-drained:
+		if len(abandoned) != 0 {
+			t.Errorf("宽限期内完成的任务不应被放弃: %v", abandoned)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain 未在任务完成后返回")
+	}
+	if s := pollJob(t, mux, location); s.Status != jobSucceeded {
+		t.Errorf("排队中的任务应在宽限期内完成，状态 %s", s.Status)
+	}
+}
+
+func TestShutdownReportsAbandonedJobs(t *testing.T) {
+	release := make(chan struct{})
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"images":[{"url":"https://cdn.example/a.png"}]}`))
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Async.Enabled = true
+	})
+	swapGlobal(t, &jobs, &jobStore{jobs: make(map[string]*Job), ttl: time.Hour})
+	t.Cleanup(func() { close(release); jobs.running.Wait() })
+
+	w := serveGenerations(t, withAsync(handleGenerations), `{"prompt":"x"}`, "Prefer", "respond-async")
+	id := strings.TrimPrefix(w.Header().Get("Location"), "/v1/images/jobs/")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if abandoned := jobs.drain(ctx); len(abandoned) != 1 || abandoned[0] != id {
+		t.Errorf("超过宽限期应返回未完成的任务 %s，实际 %v", id, abandoned)
+	}
+}
+
+// 轮询直到 cond 成立
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("等待条件成立超时")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUpstreamAsyncPollIntervalMustBePositive(t *testing.T) {
+	for _, d := range []Duration{0, Duration(-time.Second)} {
+		cfg := defaultConfig()
+		cfg.UpstreamAsync.PollInterval = d
+		if err := cfg.prepare(); err == nil {
+			t.Errorf("upstream_async.poll_interval=%v 时应报错", time.Duration(d))
+		}
+	}
+}
+
+func TestFinishedJobsPurgedOnLookup(t *testing.T) {
+	s := &jobStore{jobs: make(map[string]*Job), ttl: time.Minute}
+	old, _ := s.create()
+	fresh, _ := s.create()
+	running, _ := s.create()
+	defer s.done()
+	for _, job := range []*Job{old, fresh} {
+		job.finish(http.StatusOK, []byte(`{}`))
+		s.done()
+	}
+	old.finishedAt = time.Now().Add(-2 * time.Minute)
+
+	// 没有新任务时，查询本身也应清理过期任务
+	if _, ok := s.get(fresh.id); !ok {
+		t.Fatal("未过期的任务应能查到")
+	}
+	if _, ok := s.jobs[old.id]; ok {
+		t.Error("查询时应清理超过保留时间的已完成任务")
+	}
+	if _, ok := s.get(running.id); !ok {
+		t.Error("运行中的任务不应被清理")
+	}
+}
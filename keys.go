@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 上游 Key 的冷却截止时间：429 后暂停使用一段时间，401 视为失效并暂停更久
+type keyCooldowns struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+var cooldowns = &keyCooldowns{until: make(map[string]time.Time)}
+
+func (c *keyCooldowns) coolingDown(key string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return now.Before(c.until[key])
+}
+
+func (c *keyCooldowns) set(key string, until time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.until[key] = until
+}
+
+// 密钥文件中的 Key 池：api_key 在前，其后为 api_keys
+func (s *upstreamSecrets) keyPool() []string {
+	var pool []string
+	for _, k := range append([]string{s.APIKey}, s.APIKeys...) {
+		if k != "" && !slices.Contains(pool, k) {
+			pool = append(pool, k)
+		}
+	}
+	return pool
+}
+
+// 本次调用依次尝试的 Authorization。开启 key_rotation 且未使用租户 Key 时，
+// 按 Key 池顺序排列，冷却中的 Key 排在最后
+func upstreamAuthorizations(cfg *Config, clientHeader http.Header) []string {
+	first := upstreamAuthorization(cfg, clientHeader)
+	s := secrets.Load()
+	if !cfg.KeyRotation.Enabled || s == nil {
+		return []string{first}
+	}
+	pool := s.keyPool()
+	if _, tenant := tenantUpstreamKey(s, cfg, clientHeader); tenant || len(pool) < 2 {
+		return []string{first}
+	}
+	now := time.Now()
+	var ready, cooling []string
+	for _, k := range pool {
+		if cooldowns.coolingDown(k, now) {
+			cooling = append(cooling, "Bearer "+k)
+		} else {
+			ready = append(ready, "Bearer "+k)
+		}
+	}
+	return append(ready, cooling...)
+}
+
+// 上游以 401/429 拒绝该 Key 时记录冷却并返回 true，由调用方换下一个 Key 重试
+func rotateUpstreamKey(cfg KeyRotationConfig, auth string, resp *http.Response) bool {
+	var cooldown time.Duration
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		cooldown = time.Duration(cfg.Cooldown)
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			cooldown = time.Duration(secs) * time.Second
+		}
+	case http.StatusUnauthorized:
+		cooldown = time.Duration(cfg.InvalidCooldown)
+	default:
+		return false
+	}
+	key := strings.TrimPrefix(auth, "Bearer ")
+	cooldowns.set(key, time.Now().Add(cooldown))
+	log.Printf("[ROTATE] 上游 Key %s 返回 %d，冷却 %v 后换用下一个 Key", shortHash(key), resp.StatusCode, cooldown)
+	return true
+}
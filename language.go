@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// 无法可靠判断语言时返回的标识，此时不拦截
+const languageUndetermined = "und"
+
+// 拉丁字母语言按常见虚词区分
+var latinStopwords = map[string][]string{
+	"en": {"the", "a", "an", "of", "and", "with", "in", "on", "is", "at", "by", "for"},
+	"fr": {"le", "la", "les", "des", "une", "un", "et", "avec", "dans", "sur", "du"},
+	"de": {"der", "die", "das", "und", "mit", "ein", "eine", "im", "auf", "von"},
+	"es": {"el", "la", "los", "las", "una", "un", "y", "con", "en", "del", "sobre"},
+	"it": {"il", "lo", "la", "gli", "una", "un", "e", "con", "nel", "sul", "di"},
+	"pt": {"o", "a", "os", "as", "uma", "um", "e", "com", "no", "na", "do", "da"},
+}
+
+// 粗略识别提示词语言：先按文字系统判断，拉丁字母再按虚词判断；
+// 文字混杂、样本过短或虚词无明显倾向时返回 und
+func detectLanguage(text string) string {
+	counts := map[string]int{}
+	total := 0
+	for _, r := range text {
+		var script string
+		switch {
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			script = "ja"
+		case unicode.Is(unicode.Han, r):
+			script = "han"
+		case unicode.Is(unicode.Hangul, r):
+			script = "ko"
+		case unicode.Is(unicode.Cyrillic, r):
+			script = "ru"
+		case unicode.Is(unicode.Arabic, r):
+			script = "ar"
+		case unicode.Is(unicode.Thai, r):
+			script = "th"
+		case unicode.Is(unicode.Latin, r):
+			script = "latin"
+		default:
+			continue
+		}
+		counts[script]++
+		total++
+	}
+	if total < 3 {
+		return languageUndetermined
+	}
+	// 日文同时包含假名与汉字
+	if counts["ja"] > 0 {
+		counts["ja"] += counts["han"]
+		delete(counts, "han")
+	}
+	best, bestCount := "", 0
+	for script, n := range counts {
+		if n > bestCount {
+			best, bestCount = script, n
+		}
+	}
+	if float64(bestCount) < 0.6*float64(total) {
+		return languageUndetermined
+	}
+	switch best {
+	case "han":
+		return "zh"
+	case "latin":
+		return detectLatinLanguage(text)
+	}
+	return best
+}
+
+func detectLatinLanguage(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	scores := map[string]int{}
+	for _, w := range words {
+		for lang, stop := range latinStopwords {
+			if slices.Contains(stop, w) {
+				scores[lang]++
+			}
+		}
+	}
+	best, bestScore, second := "", 0, 0
+	for lang, n := range scores {
+		switch {
+		case n > bestScore:
+			best, second, bestScore = lang, bestScore, n
+		case n > second:
+			second = n
+		}
+	}
+	if bestScore < 2 || bestScore == second {
+		return languageUndetermined
+	}
+	return best
+}
+
+// 提示词语言不在白名单内时返回错误；无法判断时放行
+func checkPromptLanguage(cfg LanguageConfig, prompt string) error {
+	if !cfg.Enabled || len(cfg.Allowed) == 0 {
+		return nil
+	}
+	lang := detectLanguage(prompt)
+	if lang == languageUndetermined || slices.Contains(cfg.Allowed, lang) {
+		return nil
+	}
+	return fmt.Errorf("prompt language %q is not allowed", lang)
+}
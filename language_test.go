@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	cases := map[string]string{
+		"一只在草地上奔跑的橘猫":                           "zh",
+		"草原を走る猫のイラスト":                           "ja",
+		"a cat running on the grass with a hat": "en",
+		"un chat avec une robe dans la rue":     "fr",
+		"кошка бежит по траве":                  "ru",
+		"cat":                                   languageUndetermined,
+		"portrait, 4k, octane render":           languageUndetermined,
+	}
+	for text, want := range cases {
+		if got := detectLanguage(text); got != want {
+			t.Errorf("detectLanguage(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestPromptLanguageAllowlist(t *testing.T) {
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Language.Enabled = true
+		c.Language.Allowed = []string{"en"}
+	})
+
+	if w := postGenerations(t, `{"prompt":"a cat running on the grass with a hat"}`); w.Code != http.StatusOK {
+		t.Errorf("白名单内的语言 status = %d, want 200", w.Code)
+	}
+	w := postGenerations(t, `{"prompt":"一只在草地上奔跑的橘猫"}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `prompt language \"zh\" is not allowed`) {
+		t.Errorf("白名单外的语言 status = %d, body = %s, want 400", w.Code, w.Body)
+	}
+	// 无法判断语言时放行
+	if w := postGenerations(t, `{"prompt":"portrait, 4k, octane render"}`); w.Code != http.StatusOK {
+		t.Errorf("无法判断语言时 status = %d, want 200", w.Code)
+	}
+}
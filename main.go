@@ -2,21 +2,37 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // API 响应结构体
 type OriginResponse struct {
 	Images  []Image       `json:"images"`
-	Timings TimingDetails `json:"timings"` // 分解成独立结构体
-	Seed    json.Number   `json:"seed"`    // 处理可能为字符串或数字的字段
+	Data    []Image       `json:"data,omitempty"` // OpenAI 形式的上游以 data 返回
+	Timings TimingDetails `json:"timings"`        // 分解成独立结构体
+	Seed    json.Number   `json:"seed"`           // 处理可能为字符串或数字的字段
+	created int64         // 上游给出的生成时间（Unix 秒），没有时为 0
+}
+
+// 响应的 created：开启 use_upstream_created 且上游给出时使用上游的生成时间，否则为当前时间
+func responseCreated(cfg *Config, originResp OriginResponse) int64 {
+	if cfg.UseUpstreamCreated && originResp.created > 0 {
+		return originResp.created
+	}
+	return time.Now().Unix()
 }
 
 // 新增 Timing 结构体处理灵活数据类型
@@ -26,19 +42,119 @@ type TimingDetails struct {
 
 // 修改 image 结构体能应对上游字段变化
 type Image struct {
-	URL           string      `json:"url"`
-	RevisedPrompt string      `json:"revised_prompt,omitempty"`
-	ExtraFields   interface{} `json:"-"` // 捕获未定义字段
+	URL           string         `json:"url"`
+	B64JSON       string         `json:"b64_json,omitempty"` // 上游直接内联图片数据时使用
+	RevisedPrompt string         `json:"revised_prompt,omitempty"`
+	Variants      []ImageVariant `json:"variants,omitempty"` // 同一张图的多个变体（原图、放大图等）
+	Error         string         `json:"error,omitempty"`    // URL 直通模式下内联图片被丢弃时的原因
+	ExtraFields   interface{}    `json:"-"`                  // 捕获未定义字段
+	failure       string         // 按 seed 拆分调用时该 seed 的失败原因
+}
+
+// 图片变体
+type ImageVariant struct {
+	URL           string `json:"url"`
+	B64JSON       string `json:"b64_json,omitempty"`
+	Type          string `json:"type,omitempty"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// 兼容 images[] 元素为变体数组的分组写法
+func (img *Image) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '[' {
+		var group []ImageVariant
+		if err := json.Unmarshal(b, &group); err != nil {
+			return err
+		}
+		*img = Image{Variants: group}
+		if len(group) > 0 {
+			img.URL = group[0].URL
+			img.B64JSON = group[0].B64JSON
+			img.RevisedPrompt = group[0].RevisedPrompt
+		}
+		return nil
+	}
+
+	type plain Image
+	var p plain
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	*img = Image(p)
+	if img.URL == "" && img.B64JSON == "" && len(img.Variants) > 0 {
+		img.URL = img.Variants[0].URL
+		img.B64JSON = img.Variants[0].B64JSON
+	}
+	if img.RevisedPrompt == "" && len(img.Variants) > 0 {
+		img.RevisedPrompt = img.Variants[0].RevisedPrompt
+	}
+	return nil
+}
+
+// 图片的全部变体，未分组时仅包含自身
+func (img Image) variantList() []ImageVariant {
+	if len(img.Variants) > 0 {
+		return img.Variants
+	}
+	return []ImageVariant{{URL: img.URL, B64JSON: img.B64JSON}}
+}
+
+// URL 直通模式下内联图片被丢弃时条目上的 error
+const errInlineImageDropped = "inline b64_json image dropped in url mode"
+
+// URL 直通模式下丢弃上游以 b64_json 内联的图片（含变体），被丢弃的条目带上 error，返回被丢弃的图片数
+func dropInlineImages(images []Image) int {
+	dropped := 0
+	for i := range images {
+		img := &images[i]
+		if img.B64JSON != "" {
+			img.B64JSON, img.Error = "", errInlineImageDropped
+			dropped++
+		}
+		for v := range img.Variants {
+			if img.Variants[v].B64JSON != "" {
+				img.Variants[v].B64JSON, img.Variants[v].Error = "", errInlineImageDropped
+			}
+		}
+	}
+	return dropped
 }
 
 type OpenAIResponse struct {
-	Created int64            `json:"created"`
-	Data    []OpenAIDataItem `json:"data"`
+	Created       int64            `json:"created"`
+	Data          []OpenAIDataItem `json:"data"`
+	FailedIndices []int            `json:"failed_indices,omitempty"` // 下载失败的位置，便于客户端只重试这些
+	Timings       *ResponseTimings `json:"timings,omitempty"`        // 开启 include_timings 时的耗时明细
+	Usage         *ResponseUsage   `json:"usage,omitempty"`
+}
+
+// 上游报告的本次调用费用
+type ResponseUsage struct {
+	Cost json.Number `json:"cost"`
 }
 
 type OpenAIDataItem struct {
-	B64JSON       string `json:"b64_json"`
-	RevisedPrompt string `json:"revised_prompt,omitempty"`
+	Index         *int            `json:"index,omitempty"` // 开启 include_image_index 时为请求中的位置
+	B64JSON       string          `json:"b64_json"`
+	B64JSONChunks []string        `json:"b64_json_chunks,omitempty"` // 超过 b64_chunk_size 时按顺序拆分，b64_json 为空
+	RevisedPrompt string          `json:"revised_prompt,omitempty"`
+	Error         string          `json:"error,omitempty"`        // 下载失败时的原因
+	SizeBytes     int             `json:"size_bytes,omitempty"`   // 开启 include_size_bytes 时为下载的字节数
+	OriginalB64   string          `json:"original_b64,omitempty"` // 开启 include_original 且图片经过转换时为下载得到的原图
+	Ref           *int            `json:"ref,omitempty"`          // 开启 dedup_response_images 时，与该位置的图片内容相同
+	Variants      []OpenAIVariant `json:"variants,omitempty"`
+}
+
+// 分组变体的转换结果，第一个变体同时作为条目本身的 b64_json
+type OpenAIVariant struct {
+	Type          string   `json:"type,omitempty"`
+	B64JSON       string   `json:"b64_json"`
+	B64JSONChunks []string `json:"b64_json_chunks,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	SizeBytes     int      `json:"size_bytes,omitempty"`
+	OriginalB64   string   `json:"original_b64,omitempty"`
 }
 
 // 安全日志标头处理
@@ -65,16 +181,53 @@ func readBody(r io.Reader) string {
 }
 
 // 转发处理器
-func handleGenerations(w http.ResponseWriter, r *http.Request) {
-	targetURL := "https://api.siliconflow.cn/v1/images/generations"
+func handleGenerations(rw http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	targetURL := upstreamURL(cfg)
 	startTime := time.Now()
 
+	w := &statusRecorder{ResponseWriter: rw}
+	r = r.WithContext(withRetryBudget(r.Context(), cfg.RetryBudget))
+	ev := AuditEvent{
+		Event:   "image.generation",
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		KeyHash: hashAPIKey(r.Header.Get("Authorization")),
+	}
+
 	// 记录请求信息
-	log.Printf("[REQUEST] %s %s", r.Method, r.URL.Path)
+	tenant := tenants.label(cfg.Tenant, r.Header.Get(cfg.Tenant.Header))
+	log.Printf("[REQUEST] %s %s tenant=%s%s", r.Method, r.URL.Path, tenant, geo.logFields(clientIP(r, cfg.trustedProxies)))
+	requestID := randomName("")
+	// 响应的返回形式，确定之前被拒绝的请求记为 unknown
+	responseKind := "unknown"
 	defer func() {
-		log.Printf("[COMPLETE] 总耗时: %v", time.Since(startTime))
+		log.Printf("[COMPLETE] 总耗时: %v tenant=%s format=%s bytes=%d", time.Since(startTime), tenant, responseKind, w.bytes)
+		responseSize.WithLabelValues(responseKind).Observe(float64(w.bytes))
+		ev.Status = w.Status()
+		ev.Outcome = auditOutcome(ev.Status)
+		ev.DurationMs = time.Since(startTime).Milliseconds()
+		requestsTotal.WithLabelValues(tenant, ev.Outcome).Inc()
+		requestDuration.WithLabelValues(tenant).Observe(time.Since(startTime).Seconds())
+		stats.record(ev.Status, time.Since(startTime), ev.Images)
+		audit.Emit(ev)
+		cloudEvents.Emit(eventGenerationCompleted, GenerationEventData{
+			RequestID: requestID, Model: ev.Model, N: ev.N, Status: ev.Status, Images: ev.Images, DurationMs: ev.DurationMs,
+		})
 	}()
 
+	if err := checkTenantClient(cfg, r.Header); err != nil {
+		log.Printf("[REJECT] 租户 %q: %v", r.Header.Get(cfg.Tenant.Header), err)
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	if err := checkContentType(cfg.ContentTypeCheck, r.Header.Get("Content-Type")); err != nil {
+		log.Printf("[REJECT] %v", err)
+		writeError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+
 	// 读取并处理请求体
 	var reqBody map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
@@ -84,123 +237,605 @@ func handleGenerations(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	// 字段映射
-	if size, ok := reqBody["size"]; ok {
-		reqBody["image_size"] = size
-		delete(reqBody, "size")
+	// 预热模式：只建立上游连接，不生成也不下载图片
+	if warmup, _ := reqBody["warmup"].(bool); warmup {
+		if !cfg.AllowWarmup {
+			http.Error(w, `{"error":"Warmup is disabled"}`, http.StatusBadRequest)
+			return
+		}
+		if err := warmUpstream(r.Context(), cfg, r.Header); err != nil {
+			log.Printf("[ERROR] 预热失败: %v", err)
+			http.Error(w, `{"error":"Upstream service unavailable"}`, http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
 	}
 
-	// 转发请求
-	client := &http.Client{Timeout: 15 * time.Second}
-	bodyBytes, _ := json.Marshal(reqBody)
-	proxyReq, _ := http.NewRequest(http.MethodPost, targetURL, bytes.NewReader(bodyBytes))
+	// 字符串形式的数字参数先转换，schema 与后续参数校验都按数字处理
+	var pe *paramError
+	if err := coerceNumericParams(cfg.NumericParams, reqBody); errors.As(err, &pe) {
+		log.Printf("[REJECT] 参数 %s 无效: %v", pe.param, err)
+		writeError(w, http.StatusBadRequest, err.Error(), pe.param)
+		return
+	}
 
-	// 复制标头
-	for k, v := range r.Header {
-		proxyReq.Header[k] = v
+	if !validateRequestSchema(w, cfg, reqBody) {
+		return
 	}
 
-	log.Printf("[FORWARD] 请求体: %s", string(bodyBytes))
+	if err := applyModelOverride(cfg, r.Header, reqBody); err != nil {
+		log.Printf("[REJECT] %v", err)
+		writeError(w, http.StatusBadRequest, err.Error(), "model")
+		return
+	}
+
+	ev.Model, _ = reqBody["model"].(string)
+	ev.User, _ = reqBody["user"].(string)
 
-	// 发送请求
-	resp, err := client.Do(proxyReq)
+	err := checkExclusiveParams(cfg.ExclusiveParams, reqBody)
+	if err == nil {
+		err = validateGenerationParams(reqBody)
+	}
+	if errors.As(err, &pe) {
+		log.Printf("[REJECT] 参数 %s 无效: %v", pe.param, err)
+		writeError(w, http.StatusBadRequest, err.Error(), pe.param)
+		return
+	}
+
+	// seeds 数组：每个 seed 单独调用上游，未给出 n 时以 seeds 数量为准
+	seeds, err := parseSeeds(reqBody)
 	if err != nil {
-		log.Printf("[ERROR] API请求失败: %v", err)
-		http.Error(w, `{"error":"Upstream service unavailable"}`, http.StatusBadGateway)
+		log.Printf("[REJECT] seeds 参数无效: %v", err)
+		writeError(w, http.StatusBadRequest, err.Error(), "seeds")
 		return
 	}
-	defer resp.Body.Close()
+	if seeds != nil {
+		reqBody["n"] = len(seeds)
+	}
 
-	var originResp OriginResponse
-	if err := json.NewDecoder(resp.Body).Decode(&originResp); err != nil {
-		log.Printf("[ERROR] 原始响应内容: %s", readBody(resp.Body)) // 需要实现 readBody 函数
-		log.Printf("[ERROR] 响应解析失败: %v", err)
-		http.Error(w, `{"error":"Invalid upstream response"}`, http.StatusInternalServerError)
+	// 按模型补全或限制 n
+	modelCfg := cfg.Models[ev.Model]
+	if _, ok := reqBody["n"]; !ok && modelCfg.DefaultN > 0 {
+		reqBody["n"] = modelCfg.DefaultN
+	}
+	ev.N = intParam(reqBody["n"], 1)
+	cloudEvents.Emit(eventRequestReceived, GenerationEventData{RequestID: requestID, Model: ev.Model, N: ev.N})
+	if modelCfg.MaxN > 0 && ev.N > modelCfg.MaxN {
+		log.Printf("[REJECT] 模型 %s 的 n=%d 超过上限 %d", ev.Model, ev.N, modelCfg.MaxN)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("n must be at most %d for model %s", modelCfg.MaxN, ev.Model), "n")
 		return
 	}
+	clientPrompt, _ := reqBody["prompt"].(string)
+	if prompt, ok := reqBody["prompt"].(string); ok {
+		ev.PromptHash = shortHash(prompt)
+		if cfg.Audit.IncludePrompt {
+			ev.Prompt = prompt
+		}
+	}
 
-	// 判断响应格式
-	responseFormat, _ := reqBody["response_format"].(string)
-	if responseFormat != "b64_json" {
-		log.Printf("[SKIP] 直接返回URL格式")
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(originResp)
+	if prompt, ok := reqBody["prompt"].(string); ok {
+		if err := checkPromptLanguage(cfg.Language, prompt); err != nil {
+			log.Printf("[REJECT] %v", err)
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// 翻译为英文后按模型附加品牌/安全指令，客户端不可见
+	if prompt, ok := reqBody["prompt"].(string); ok {
+		prompt = translatePrompt(r.Context(), cfg.Translation, ev.Model, prompt)
+		reqBody["prompt"] = applyPromptTemplate(cfg.PromptTemplates, ev.Model, prompt)
+	}
+
+	if err := validateOutputModes(cfg, r, reqBody); err != nil {
+		log.Printf("[REJECT] 输出模式冲突: %v", err)
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	rawFormat, raw := rawImageFormat(cfg, r)
 
-	// 并发下载转换图片
-	resultChan := make(chan OpenAIDataItem, len(originResp.Images))
-	errorChan := make(chan error, len(originResp.Images))
+	// 存储模式下由代理负责输出格式，不转发给上游
+	var outputFormat string
+	if cfg.Storage.Enabled {
+		format, _ := reqBody["output_format"].(string)
+		delete(reqBody, "output_format")
+		var err error
+		if outputFormat, err = normalizeOutputFormat(format); err != nil {
+			http.Error(w, `{"error":"Unsupported output_format"}`, http.StatusBadRequest)
+			return
+		}
+	}
 
-	downloadImage := func(url string, index int) {
-		log.Printf("[DOWNLOAD %d] 开始下载: %s", index, url)
-		start := time.Now()
+	// 字段映射：size 改写为上游使用的字段名
+	if size, ok := reqBody["size"]; ok && cfg.UpstreamSizeField != "size" {
+		reqBody[cfg.UpstreamSizeField] = size
+		delete(reqBody, "size")
+	}
 
-		resp, err := http.Get(url)
-		if err != nil {
-			log.Printf("[ERROR %d] 下载失败: %v", index, err)
-			errorChan <- err
+	// stream: true 由代理自身以 SSE 提供，不转发给上游
+	var sse bool
+	if stream, _ := reqBody["stream"].(bool); stream && cfg.StreamMode != "forward" {
+		delete(reqBody, "stream")
+		sse = cfg.StreamMode == "sse"
+	}
+
+	// 合并固定字段模板，客户端已提供的字段优先
+	for k, v := range cfg.RequestTemplate {
+		if _, ok := reqBody[k]; !ok {
+			reqBody[k] = v
+		}
+	}
+
+	if !raw && forceB64(cfg.ForceB64, r, ev.KeyHash) {
+		log.Printf("[FORMAT] 客户端配置为始终返回 b64_json，忽略 response_format=%v", reqBody["response_format"])
+		reqBody["response_format"] = "b64_json"
+	}
+
+	// 按模型支持的返回格式改写上游请求，客户端看到的仍是其请求的格式
+	responseFormat, _ := reqBody["response_format"].(string)
+	needURL := responseFormat != "b64_json" && store == nil && !raw && !sse &&
+		!wantsMultipartRelated(r) && !wantsMultipartMixed(r) && !wantsZip(r)
+	if err := applyModelFormats(modelCfg, ev.Model, reqBody, responseFormat, needURL); err != nil {
+		log.Printf("[REJECT] %v", err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch {
+	case raw:
+		responseKind = "raw"
+	case sse:
+		responseKind = "sse"
+	case wantsMultipartMixed(r) || wantsMultipartRelated(r):
+		responseKind = "multipart"
+	case wantsZip(r):
+		responseKind = "zip"
+	case responseFormat == "b64_json":
+		responseKind = "b64_json"
+	default:
+		responseKind = "url"
+	}
+
+	// 转发请求
+	client := upstreamClient(cfg)
+	bodyBytes, _ := json.Marshal(reqBody)
+	if cfg.ExposeEffectiveParams {
+		w.Header().Set("X-Effective-Params", effectiveParams(reqBody))
+	}
+	// 过期结果只用于普通 b64 JSON 响应：SSE、分块、zip、multipart、raw 的响应格式不同，不能以缓存的 JSON 代替
+	var cacheKey string
+	if responseKind == "b64_json" && !cfg.ChunkedB64Response {
+		cacheKey = resultCacheKey(reqBody, bodyBytes, wantsBareArray(r), ev.KeyHash, r.Header.Get(cfg.Tenant.Header), r.Header.Get("Accept"))
+	}
+	if cacheKey != "" && bypassCache(cfg, r) {
+		log.Printf("[CACHE] 客户端要求跳过缓存，本次请求不写入缓存，上游失败时也不返回过期结果")
+		cacheKey = ""
+	}
+	size, _ := reqBody[cfg.UpstreamSizeField].(string)
+	record := func(n int) func(*http.Response, error, time.Duration) {
+		return func(resp *http.Response, err error, latency time.Duration) {
+			call := UpstreamCallEvent{KeyHash: ev.KeyHash, Model: ev.Model, N: n, Size: size, LatencyMs: latency.Milliseconds()}
+			if err != nil {
+				call.Error = err.Error()
+			} else {
+				call.Status = resp.StatusCode
+			}
+			upstreamAudit.EmitUpstream(call)
+		}
+	}
+	upstreamStart := time.Now()
+
+	var originResp OriginResponse
+	var cost string
+	if seeds != nil {
+		results := callUpstreamSeeds(r.Context(), cfg, client, targetURL, r.Header, reqBody, seeds, record(1))
+		originResp, cost = mergeSeedResults(cfg, seeds, results)
+		if countUpstreamImages(originResp.Images) == 0 {
+			log.Printf("[ERROR] 全部 %d 个 seed 的上游调用失败", len(seeds))
+			if serveStale(w, cacheKey) {
+				return
+			}
+			http.Error(w, `{"error":"Upstream service unavailable"}`, http.StatusBadGateway)
+			return
+		}
+	} else {
+		up := callUpstream(r.Context(), cfg, client, targetURL, r.Header, bodyBytes, record(ev.N))
+		if up.err != nil {
+			switch up.stage {
+			case "request":
+				log.Printf("[ERROR] API请求失败: %v", up.err)
+			case "read":
+				log.Printf("[ERROR] 读取上游响应失败: %v", up.err)
+			default:
+				log.Printf("[ERROR] 上游任务失败: %v", up.err)
+			}
+			if serveStale(w, cacheKey) {
+				return
+			}
+			if upstreamTruncated(up.err) {
+				writeUpstreamTruncated(w, cfg.UpstreamTruncatedStatus)
+				return
+			}
+			if errors.Is(up.err, errUpstreamRedirect) {
+				writeError(w, http.StatusBadGateway, "Upstream redirected the request")
+				return
+			}
+			if errors.Is(up.err, errUpstreamTooLarge) {
+				writeError(w, http.StatusBadGateway, fmt.Sprintf("Upstream response exceeds %d bytes", cfg.MaxUpstreamResponseBytes))
+				return
+			}
+			if up.stage == "job" {
+				http.Error(w, `{"error":"Upstream job failed"}`, http.StatusBadGateway)
+				return
+			}
+			http.Error(w, `{"error":"Upstream service unavailable"}`, http.StatusBadGateway)
+			return
+		}
+		if up.status >= 500 && serveStale(w, cacheKey) {
+			log.Printf("[ERROR] 上游返回 %d: %s", up.status, up.body)
 			return
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			err := fmt.Errorf("HTTP %d", resp.StatusCode)
-			log.Printf("[ERROR %d] 响应错误: %v", index, err)
-			errorChan <- err
+		if up.status >= 400 && !json.Valid(up.body) {
+			log.Printf("[ERROR] 上游返回 %d 非 JSON 错误页: %.200s", up.status, up.body)
+			writeUpstreamHTMLError(w, up.status, up.body)
 			return
 		}
 
-		data, err := io.ReadAll(resp.Body)
+		originResp, err = parseUpstreamResponse(up.body, cfg.UpstreamImagesPath)
 		if err != nil {
-			log.Printf("[ERROR %d] 读取失败: %v", index, err)
-			errorChan <- err
+			log.Printf("[ERROR] 原始响应内容: %s", up.body)
+			log.Printf("[ERROR] 响应解析失败: %v", err)
+			if upstreamTruncated(err) {
+				writeUpstreamTruncated(w, cfg.UpstreamTruncatedStatus)
+				return
+			}
+			http.Error(w, `{"error":"Invalid upstream response"}`, http.StatusInternalServerError)
 			return
 		}
+		if len(originResp.Images) == 0 {
+			if e, ok := upstreamBodyError(up.body); ok {
+				log.Printf("[ERROR] 上游返回 %d 且响应体包含错误: %s", up.status, up.body)
+				writeUpstreamBodyError(w, up.status, cfg.UpstreamErrorStatus, e)
+				return
+			}
+		}
+		cost = upstreamCost(cfg.Cost, up.header, up.body)
+	}
+	upstreamElapsed := time.Since(upstreamStart)
 
-		b64 := base64.StdEncoding.EncodeToString(data)
-		log.Printf("[SUCCESS %d] 下载完成，大小: %d bytes, 耗时: %v",
-			index, len(data), time.Since(start))
+	normalizeRevisedPrompts(originResp.Images, cfg.OmitRevisedPrompt)
+	if cost != "" {
+		w.Header().Set("X-Cost", cost)
+	}
+	if got := len(originResp.Images); got != ev.N {
+		log.Printf("[WARN] 上游返回 %d 张图片，请求 n=%d", got, ev.N)
+		if got < ev.N {
+			switch cfg.ImageCountMismatch {
+			case "fail":
+				writeError(w, http.StatusBadGateway, fmt.Sprintf("Upstream returned %d images, expected %d", got, ev.N))
+				return
+			case "pad":
+				// 空条目在下载阶段以错误占位
+				originResp.Images = append(originResp.Images, make([]Image, ev.N-got)...)
+			}
+		}
+	}
+
+	// 存储的图片附带请求信息
+	storeCtx := r.Context()
+	if cfg.Storage.Metadata {
+		storeCtx = withObjectMetadata(storeCtx, ObjectMetadata{
+			Model: ev.Model, PromptHash: ev.PromptHash, Seed: originResp.Seed.String(), Tenant: tenant, RequestID: requestID,
+		})
+	}
 
-		resultChan <- OpenAIDataItem{
-			B64JSON:       b64,
-			RevisedPrompt: originResp.Images[index].RevisedPrompt,
+	// 判断响应格式
+	if sse && !raw {
+		ev.Images = streamSSE(r.Context(), w, cfg, originResp.Images, startTime, originResp.Seed.String())
+		return
+	}
+	if wantsMultipartMixed(r) && !raw {
+		ev.Images = streamMultipartMixed(r.Context(), w, cfg, originResp.Images, startTime, originResp.Seed.String())
+		return
+	}
+	if responseFormat != "b64_json" && !wantsMultipartRelated(r) && !wantsZip(r) && !raw {
+		if store != nil {
+			slots := fetchImages(r.Context(), cfg, originResp.Images)
+			applyFallbackImage(w, cfg, slots)
+			items := storeImages(storeCtx, store, quotaClient(r, cfg), originResp.Images, slots, outputFormat, cfg.IncludeOriginal)
+			for _, item := range items {
+				if item.URL != "" {
+					ev.Images++
+				}
+			}
+			writeStoredResponse(w, cfg, items)
+			return
+		}
+		log.Printf("[SKIP] 直接返回URL格式")
+		ev.Images = len(originResp.Images)
+		if cfg.MixedImages == "drop" {
+			if n := dropInlineImages(originResp.Images); n > 0 {
+				log.Printf("[WARN] 上游以 b64_json 返回了 %d 张图片，URL 模式下已丢弃", n)
+				ev.Images -= n
+			}
 		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(encodeResponse(cfg.OutputFieldRenames, originResp))
+		return
 	}
 
-	for i, img := range originResp.Images {
-		go downloadImage(img.URL, i)
+	if cfg.ChunkedB64Response && !raw && !wantsMultipartRelated(r) && !wantsZip(r) {
+		downloadStart := time.Now()
+		ev.Images = streamB64Chunked(r.Context(), w, cfg, originResp.Images, responseCreated(cfg, originResp), wantsBareArray(r), func(slots [][]imageSlot) chunkedTail {
+			var t chunkedTail
+			if _, err := strconv.ParseFloat(cost, 64); err == nil {
+				t.Usage = &ResponseUsage{Cost: json.Number(cost)}
+			}
+			if cfg.IncludeTimings {
+				t.Timings = buildResponseTimings(originResp, slots, upstreamElapsed, time.Since(downloadStart), time.Since(startTime))
+			}
+			return t
+		})
+		return
 	}
 
-	// 收集结果
-	results := make([]OpenAIDataItem, 0, len(originResp.Images))
-	for range originResp.Images {
-		select {
-		case res := <-resultChan:
-			results = append(results, res)
-		case err := <-errorChan:
-			log.Printf("[WARN] 部分图片下载失败: %v", err)
-			results = append(results, OpenAIDataItem{B64JSON: ""})
+	// 并发下载转换图片
+	downloadStart := time.Now()
+	slots := fetchImages(r.Context(), cfg, originResp.Images)
+	downloadElapsed := time.Since(downloadStart)
+	fallback := applyFallbackImage(w, cfg, slots)
+
+	if raw {
+		writeRawImage(w, r, slots, rawFormat)
+		ev.Images = min(countDownloaded(slots), 1)
+		return
+	}
+
+	if wantsMultipartRelated(r) {
+		writeMultipartRelated(w, originResp.Images, slots)
+		ev.Images = countDownloaded(slots)
+		return
+	}
+
+	if wantsZip(r) {
+		writeZipArchive(w, cfg.ZipLayout, clientPrompt, originResp.Seed.String(), originResp.Images, slots)
+		ev.Images = countDownloaded(slots)
+		return
+	}
+
+	// base64 总量过大时改为存储并返回 URL
+	if size := b64PayloadSize(slots); cfg.B64StorageThreshold > 0 && size > cfg.B64StorageThreshold && store != nil {
+		log.Printf("[STORE] b64 总量 %d bytes 超过阈值 %d，改为返回存储 URL", size, cfg.B64StorageThreshold)
+		responseKind = "url"
+		items := storeImages(storeCtx, store, quotaClient(r, cfg), originResp.Images, slots, outputFormat, cfg.IncludeOriginal)
+		for _, item := range items {
+			if item.URL != "" {
+				ev.Images++
+			}
 		}
+		w.Header().Set("X-Storage-Fallback", "true")
+		writeStoredResponse(w, cfg, items)
+		return
+	}
+
+	results := make([]OpenAIDataItem, len(originResp.Images))
+	refs := newImageRefs(cfg)
+	for i, img := range originResp.Images {
+		results[i] = buildDataItem(cfg, img, slots[i], i)
+		refs.apply(&results[i], slots[i], i)
 	}
 
 	// 构造响应
 	openaiResp := OpenAIResponse{
-		Created: time.Now().Unix(),
+		Created: responseCreated(cfg, originResp),
 		Data:    results,
 	}
+	if _, err := strconv.ParseFloat(cost, 64); err == nil {
+		openaiResp.Usage = &ResponseUsage{Cost: json.Number(cost)}
+	}
+	if cfg.IncludeTimings {
+		openaiResp.Timings = buildResponseTimings(originResp, slots, upstreamElapsed, downloadElapsed, time.Since(startTime))
+	}
+
+	ev.Images = countDownloaded(slots)
+
+	var failed []int
+	for i, item := range results {
+		if item.Error != "" {
+			failed = append(failed, i)
+		}
+	}
+	if len(failed) > 0 {
+		w.Header().Set("X-Failed-Images", strconv.Itoa(len(failed)))
+		if cfg.IncludeFailedIndices {
+			openaiResp.FailedIndices = failed
+		}
+	}
+
+	// created 与 timings 每次不同，ETag 只按其余内容计算，固定 seed 的重复请求可以命中 304
+	var payload interface{} = openaiResp
+	if wantsBareArray(r) {
+		payload = openaiResp.Data
+	}
+	hashed := openaiResp
+	hashed.Created = 0
+	hashed.Timings = nil
+	content, _ := json.Marshal(hashed)
+	if checkNotModified(w, r, contentETag([]byte(strconv.FormatBool(wantsBareArray(r))), content)) {
+		log.Printf("[SUCCESS] 内容未变化，返回 304")
+		return
+	}
 
 	log.Printf("[SUCCESS] 返回数据 - 图片数量: %d", len(results))
+	out := encodeResponse(cfg.OutputFieldRenames, payload)
+	if len(failed) == 0 && !fallback {
+		staleCache.Put(cacheKey, "application/json", out)
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(openaiResp)
+	if len(failed) > 0 && ev.Images > 0 {
+		w.WriteHeader(cfg.PartialSuccessStatus)
+	}
+	w.Write(out)
+}
+
+// 客户端要求省略 {created, data} 外层，仅返回 data 数组
+func wantsBareArray(r *http.Request) bool {
+	return r.URL.Query().Get("shape") == "array" ||
+		strings.EqualFold(r.Header.Get("X-Response-Shape"), "array")
+}
+
+// 以 {"error": "..."} 形式返回错误
+// 给出 param 时按 OpenAI 错误结构返回并指明出错的参数，便于 SDK 定位到具体字段
+func writeError(w http.ResponseWriter, status int, message string, param ...string) {
+	if len(param) > 0 {
+		typ, code := openAIErrorKind(status)
+		writeOpenAIError(w, status, openAIError{Message: message, Type: typ, Code: code, Param: param[0]})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// 全部成功变体编码为 base64 后的总字节数
+func b64PayloadSize(slots [][]imageSlot) int64 {
+	var total int64
+	for _, variants := range slots {
+		for _, slot := range variants {
+			if slot.err == "" {
+				total += int64(base64.StdEncoding.EncodedLen(len(slot.data)) + base64.StdEncoding.EncodedLen(len(slot.original)))
+			}
+		}
+	}
+	return total
+}
+
+// 由一张图片各变体的下载结果构造 b64 响应条目
+func buildDataItem(cfg *Config, img Image, slots []imageSlot, index int) OpenAIDataItem {
+	variants := make([]OpenAIVariant, len(slots))
+	for v, slot := range slots {
+		variants[v] = OpenAIVariant{Type: slot.typ, Error: slot.err}
+		if slot.err == "" {
+			variants[v].B64JSON = encodeBase64(slot.data)
+			if cfg.IncludeSizeBytes {
+				variants[v].SizeBytes = slot.size
+			}
+			if slot.original != nil {
+				variants[v].OriginalB64 = encodeBase64(slot.original)
+			}
+		}
+		if chunks := splitB64(variants[v].B64JSON, cfg.B64ChunkSize); chunks != nil {
+			variants[v].B64JSON, variants[v].B64JSONChunks = "", chunks
+		}
+	}
+	item := OpenAIDataItem{
+		B64JSON:       variants[0].B64JSON,
+		B64JSONChunks: variants[0].B64JSONChunks,
+		RevisedPrompt: img.RevisedPrompt,
+		Error:         variants[0].Error,
+		SizeBytes:     variants[0].SizeBytes,
+		OriginalB64:   variants[0].OriginalB64,
+	}
+	if len(img.Variants) > 0 {
+		item.Variants = variants
+	}
+	if cfg.IncludeImageIndex {
+		item.Index = &index
+	}
+	return item
+}
+
+// 按内容哈希记录已返回的图片，重复出现的图片只返回一次，其余位置以 ref 引用
+type imageRefs map[[sha256.Size]byte]int
+
+func newImageRefs(cfg *Config) imageRefs {
+	if !cfg.DedupResponseImages {
+		return nil
+	}
+	return make(imageRefs)
+}
+
+// 条目与之前某张图片内容相同时清空图片数据并设置 ref；带分组变体或失败的条目不处理
+func (refs imageRefs) apply(item *OpenAIDataItem, slots []imageSlot, index int) {
+	if refs == nil || len(slots) != 1 || slots[0].err != "" {
+		return
+	}
+	key := sha256.Sum256(slots[0].data)
+	if first, ok := refs[key]; ok {
+		item.B64JSON, item.B64JSONChunks, item.OriginalB64, item.Ref = "", nil, "", &first
+		return
+	}
+	refs[key] = index
+}
+
+// 读取 JSON 数字参数，缺省时返回 def
+func intParam(v interface{}, def int) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case float64:
+		return int(n)
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return int(i)
+		}
+	}
+	return def
 }
 
 func main() {
-	http.HandleFunc("/v1/images/generations", handleGenerations)
+	flag.StringVar(&configPath, "config", "", "配置文件路径 (JSON)")
+	flag.Parse()
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatal("[FATAL] 配置加载失败: ", err)
+	}
+	config.Store(cfg)
+
+	if audit, err = newAuditLogger(cfg.Audit); err != nil {
+		log.Fatal("[FATAL] 审计日志初始化失败: ", err)
+	}
+
+	upstreamAuditCfg := AuditConfig{Enabled: cfg.UpstreamAudit.Enabled, Output: cfg.UpstreamAudit.Output}
+	if upstreamAudit, err = newAuditLogger(upstreamAuditCfg); err != nil {
+		log.Fatal("[FATAL] 上游审计日志初始化失败: ", err)
+	}
+	cloudEvents = newEventEmitter(cfg.CloudEvents)
+
+	if cfg.UpstreamKeyFile != "" {
+		if err := watchSecretsFile(cfg.UpstreamKeyFile); err != nil {
+			log.Fatal("[FATAL] 密钥文件加载失败: ", err)
+		}
+	}
+
+	queue = newRequestQueue(cfg.Queue)
+	encodeSlots = newEncodeSlots(cfg.EncodeConcurrency)
+	downloads = newDownloadSlots(cfg.DownloadConcurrency)
+	quota = newStorageQuota(cfg.Storage)
+	staleCache = newResultCache(cfg.StaleCache)
+	webpOutput = newWebPEncoder(cfg.WebP)
+	geo = newGeoResolver(cfg.GeoIP)
+	if store, err = newStorage(cfg.Storage); err != nil {
+		log.Fatal("[FATAL] 存储初始化失败: ", err)
+	}
+	store = withStorageTimeouts(store, cfg.Storage)
+	go prewarmUpstream(cfg)
+
+	http.HandleFunc("/v1/images/generations", withRateLimit("generations", withCompression(withSignatureCheck(withDuplicateDetection(withIPLimit(withAsync(withQueue(handleGenerations))))))))
+	http.HandleFunc("POST /v1/images/edits", withRateLimit("edits", withCompression(withSignatureCheck(withEditForm(withDuplicateDetection(withIPLimit(withAsync(withQueue(handleGenerations)))))))))
+	http.HandleFunc("GET /v1/images/jobs/{id}", withRateLimit("jobs", handleJobStatus))
+	http.HandleFunc("/files/", withRateLimit("files", handleFiles))
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("POST /admin/reload", withAdminAuth(handleReload))
+	http.HandleFunc("GET /admin/stats", withAdminAuth(handleStats))
+	http.HandleFunc("GET /admin/storage-usage", withAdminAuth(handleStorageUsage))
 
-	port := ":3000"
+	port := cfg.Port
 	log.Printf("[SERVER] 服务启动在 http://localhost%s", port)
-	if err := http.ListenAndServe(port, nil); err != nil {
+	if err := serve(port); err != nil {
 		log.Fatal("[FATAL] 启动失败: ", err)
 	}
 }
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 以默认配置为基础构造测试配置并设为当前配置，测试结束后恢复
+func useConfig(t *testing.T, mutate func(*Config)) *Config {
+	t.Helper()
+	c := defaultConfig()
+	if mutate != nil {
+		mutate(c)
+	}
+	if err := c.prepare(); err != nil {
+		t.Fatalf("配置无效: %v", err)
+	}
+	prev := config.Load()
+	config.Store(c)
+	t.Cleanup(func() { config.Store(prev) })
+	return c
+}
+
+// 替换包级变量，测试结束后恢复
+func swapGlobal[T any](t *testing.T, p *T, v T) {
+	t.Helper()
+	prev := *p
+	*p = v
+	t.Cleanup(func() { *p = prev })
+}
+
+// w×h 的测试 PNG，像素按位置取色，避免不同尺寸的图片内容相同
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 255 / max(w-1, 1)), G: uint8(y * 255 / max(h-1, 1)), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// 对任意路径都返回 data 的图片服务器
+func newImageServer(t *testing.T, data []byte) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// 返回给定图片 URL 的上游，seed 固定为 42；recv 非 nil 时记录收到的请求体
+func newUpstream(t *testing.T, urls []string, recv *map[string]interface{}) *httptest.Server {
+	t.Helper()
+	images := make([]map[string]string, len(urls))
+	for i, u := range urls {
+		images[i] = map[string]string{"url": u}
+	}
+	body, _ := json.Marshal(map[string]interface{}{"images": images, "seed": 42})
+	return newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		if recv != nil {
+			json.NewDecoder(r.Body).Decode(recv)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+}
+
+func newUpstreamFunc(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// 以 JSON 请求体调用生成接口，header 按键值对给出
+func postGenerations(t *testing.T, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serveGenerations(t, handleGenerations, body, header...)
+}
+
+func serveGenerations(t *testing.T, h http.HandlerFunc, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/v1/images/generations", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+// 并发安全的缓冲区，下载等 goroutine 会同时写日志
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// 捕获测试期间的运行日志
+func captureLog(t *testing.T) *syncBuffer {
+	t.Helper()
+	buf := &syncBuffer{}
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return buf
+}
+
+// b64 响应的反序列化结果
+func decodeB64Response(t *testing.T, w *httptest.ResponseRecorder) OpenAIResponse {
+	t.Helper()
+	var resp OpenAIResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("响应不是有效 JSON: %v\n%s", err, w.Body.String())
+	}
+	return resp
+}
+
+func TestGroupedVariantsKeepGrouping(t *testing.T) {
+	small, large := testPNG(t, 2, 2), testPNG(t, 8, 8)
+	smallSrv, largeSrv := newImageServer(t, small), newImageServer(t, large)
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"images":[
+			[{"url":%q,"type":"original","revised_prompt":"first"},{"url":%q,"type":"upscaled"}],
+			{"url":%q,"revised_prompt":"second"}
+		]}`, smallSrv.URL+"/1.png", largeSrv.URL+"/1.png", smallSrv.URL+"/2.png")
+	})
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+
+	w := postGenerations(t, `{"prompt":"x","n":2,"response_format":"b64_json"}`)
+	resp := decodeB64Response(t, w)
+	if len(resp.Data) != 2 {
+		t.Fatalf("分组变体应按请求的图片计数，data 条目数 = %d", len(resp.Data))
+	}
+	first := resp.Data[0]
+	if len(first.Variants) != 2 || first.Variants[0].Type != "original" || first.Variants[1].Type != "upscaled" {
+		t.Fatalf("第一张图的变体分组不正确: %+v", first.Variants)
+	}
+	if first.B64JSON != encodeBase64(small) || first.Variants[1].B64JSON != encodeBase64(large) {
+		t.Errorf("变体内容与 URL 不对应")
+	}
+	if first.RevisedPrompt != "first" {
+		t.Errorf("第一张图 revised_prompt = %q", first.RevisedPrompt)
+	}
+	second := resp.Data[1]
+	if second.Variants != nil || second.RevisedPrompt != "second" || second.B64JSON != encodeBase64(small) {
+		t.Errorf("未分组的图片不应带 variants，且 revised_prompt 不得错位: %+v", second)
+	}
+}
+
+func TestBareArrayShape(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	up := newUpstream(t, []string{img.URL + "/a.png"}, nil)
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"header": postGenerations(t, `{"prompt":"x","response_format":"b64_json"}`, "X-Response-Shape", "array"),
+		"query": serveGenerations(t, func(w http.ResponseWriter, r *http.Request) {
+			r.URL.RawQuery = "shape=array"
+			handleGenerations(w, r)
+		}, `{"prompt":"x","response_format":"b64_json"}`),
+	} {
+		var items []OpenAIDataItem
+		if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+			t.Fatalf("%s: 响应不是 JSON 数组: %s", name, w.Body)
+		}
+		if len(items) != 1 || items[0].B64JSON == "" {
+			t.Errorf("%s: 数组内容不正确: %s", name, w.Body)
+		}
+	}
+
+	resp := decodeB64Response(t, postGenerations(t, `{"prompt":"x","response_format":"b64_json"}`))
+	if resp.Created == 0 || len(resp.Data) != 1 {
+		t.Errorf("默认应返回 {created, data} 结构")
+	}
+}
+
+func TestPerModelDefaultAndMaxN(t *testing.T) {
+	var forwarded map[string]interface{}
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, &forwarded)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Models = map[string]ModelConfig{
+			"flux": {DefaultN: 1, MaxN: 1},
+			"sdxl": {DefaultN: 4, MaxN: 4},
+		}
+	})
+
+	postGenerations(t, `{"model":"sdxl","prompt":"x"}`)
+	if forwarded["n"] != float64(4) {
+		t.Errorf("未传 n 时应注入模型默认值 4，转发 n=%v", forwarded["n"])
+	}
+	postGenerations(t, `{"model":"sdxl","prompt":"x","n":2}`)
+	if forwarded["n"] != float64(2) {
+		t.Errorf("客户端给出的 n 不应被覆盖，转发 n=%v", forwarded["n"])
+	}
+
+	forwarded = nil
+	w := postGenerations(t, `{"model":"flux","prompt":"x","n":2}`)
+	if w.Code != http.StatusBadRequest || forwarded != nil {
+		t.Fatalf("n 超过模型上限应返回 400 且不调用上游，status=%d", w.Code)
+	}
+	var e struct {
+		Error openAIError `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &e)
+	if e.Error.Param != "n" {
+		t.Errorf("错误应指向 n: %s", w.Body)
+	}
+	if w := postGenerations(t, `{"model":"sdxl","prompt":"x","n":4}`); w.Code != http.StatusOK {
+		t.Errorf("未超过上限的请求应成功，status=%d", w.Code)
+	}
+}
+
+func TestFailedIndices(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	missing := newUpstreamFunc(t, http.NotFound)
+	up := newUpstream(t, []string{missing.URL + "/0.png", img.URL + "/1.png", missing.URL + "/2.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.IncludeFailedIndices = true
+	})
+
+	w := postGenerations(t, `{"prompt":"x","n":3,"response_format":"b64_json"}`)
+	resp := decodeB64Response(t, w)
+	if fmt.Sprint(resp.FailedIndices) != "[0 2]" {
+		t.Errorf("failed_indices = %v, want [0 2]", resp.FailedIndices)
+	}
+	if w.Header().Get("X-Failed-Images") != "2" {
+		t.Errorf("X-Failed-Images = %q", w.Header().Get("X-Failed-Images"))
+	}
+
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	w = postGenerations(t, `{"prompt":"x","n":3,"response_format":"b64_json"}`)
+	if strings.Contains(w.Body.String(), "failed_indices") {
+		t.Errorf("未开启 include_failed_indices 时不应返回该字段")
+	}
+}
+
+func TestRequestTemplateMergedUnderClientFields(t *testing.T) {
+	var forwarded map[string]interface{}
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, &forwarded)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.RequestTemplate = map[string]interface{}{"account_id": "acct-1", "num_inference_steps": float64(20)}
+	})
+
+	postGenerations(t, `{"prompt":"x","num_inference_steps":30}`)
+	if forwarded["account_id"] != "acct-1" {
+		t.Errorf("缺少模板字段 account_id: %v", forwarded)
+	}
+	if forwarded["num_inference_steps"] != float64(30) {
+		t.Errorf("客户端字段应优先，num_inference_steps = %v", forwarded["num_inference_steps"])
+	}
+}
+
+func TestImageIndexWithFailures(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	missing := newUpstreamFunc(t, http.NotFound)
+	up := newUpstream(t, []string{img.URL + "/0.png", missing.URL + "/1.png", img.URL + "/2.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.IncludeImageIndex = true
+	})
+
+	resp := decodeB64Response(t, postGenerations(t, `{"prompt":"x","n":3,"response_format":"b64_json"}`))
+	if len(resp.Data) != 3 {
+		t.Fatalf("data 条目数 = %d, want 3", len(resp.Data))
+	}
+	for i, item := range resp.Data {
+		if item.Index == nil || *item.Index != i {
+			t.Errorf("data[%d].index = %v, want %d", i, item.Index, i)
+		}
+		if failed := item.Error != ""; failed != (i == 1) {
+			t.Errorf("data[%d] error = %q", i, item.Error)
+		}
+	}
+
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	if w := postGenerations(t, `{"prompt":"x","n":3,"response_format":"b64_json"}`); strings.Contains(w.Body.String(), `"index"`) {
+		t.Error("未开启 include_image_index 时不应返回 index 字段")
+	}
+}
+
+func TestChunkedUpstreamJSONDecoded(t *testing.T) {
+	body := `{"images":[{"url":"https://cdn.example/final.png"}],"seed":42}`
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		// 分多次刷新，每块都不是完整的 JSON
+		for i := 0; i < len(body); i += 7 {
+			w.Write([]byte(body[i:min(i+7, len(body))]))
+			w.(http.Flusher).Flush()
+			time.Sleep(time.Millisecond)
+		}
+	})
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+
+	w := postGenerations(t, `{"prompt":"x"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "final.png") {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+}
+
+func TestDecodeUpstreamJSONSnapshots(t *testing.T) {
+	var resp OriginResponse
+	snapshots := `{"images":[]}` + "\n" + `{"images":[{"url":"https://cdn.example/a.png"}]}` + "\n"
+	if err := decodeUpstreamJSON([]byte(snapshots), &resp); err != nil || len(resp.Images) != 1 {
+		t.Fatalf("应以最后一份完整的 JSON 为准: err = %v, images = %d", err, len(resp.Images))
+	}
+	if err := decodeUpstreamJSON([]byte(`{"images":[{"url":"https://cdn`), &resp); err == nil {
+		t.Error("被截断的响应应返回错误")
+	}
+	if err := decodeUpstreamJSON(nil, &resp); err == nil {
+		t.Error("空响应应返回错误")
+	}
+}
+
+func TestImageCountMismatchPolicies(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	up := newUpstream(t, []string{img.URL + "/a.png"}, nil)
+	body := `{"prompt":"x","n":3,"response_format":"b64_json"}`
+
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	logs := captureLog(t)
+	if resp := decodeB64Response(t, postGenerations(t, body)); len(resp.Data) != 1 {
+		t.Errorf("warn: data 条目数 = %d, want 1", len(resp.Data))
+	}
+	if !strings.Contains(logs.String(), "上游返回 1 张图片，请求 n=3") {
+		t.Errorf("warn: 缺少数量不符的日志:\n%s", logs)
+	}
+
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.ImageCountMismatch = "pad"
+	})
+	resp := decodeB64Response(t, postGenerations(t, body))
+	if len(resp.Data) != 3 || resp.Data[0].Error != "" {
+		t.Fatalf("pad: data 条目数 = %d", len(resp.Data))
+	}
+	for _, item := range resp.Data[1:] {
+		if item.Error != "upstream returned fewer images than requested" {
+			t.Errorf("pad: 补足的条目 error = %q", item.Error)
+		}
+	}
+
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.ImageCountMismatch = "fail"
+	})
+	if w := postGenerations(t, body); w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), "Upstream returned 1 images, expected 3") {
+		t.Errorf("fail: status = %d, body = %s, want 502", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+)
+
+// edits 表单（原图、蒙版与文本字段）的大小上限
+const maxEditFormBytes = 32 << 20
+
+// 处理 OpenAI 风格的 /v1/images/edits 表单：image 与可选的 mask 以文件上传，其余为文本字段。
+// 蒙版不合法时直接返回 400；通过后转为 JSON，原图与蒙版以 data URI 写入 image / mask 字段，交给生成流程转发
+func withEditForm(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "multipart/form-data" {
+			writeError(w, http.StatusUnsupportedMediaType, "edits requires multipart/form-data")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxEditFormBytes)
+		if err := r.ParseMultipartForm(maxEditFormBytes); err != nil {
+			log.Printf("[REJECT] edits 表单解析失败: %v", err)
+			writeError(w, http.StatusBadRequest, "invalid multipart form")
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		reqBody := make(map[string]interface{})
+		for name, values := range r.MultipartForm.Value {
+			if len(values) > 0 {
+				reqBody[name] = values[0]
+			}
+		}
+		src, err := readFormFile(r, "image")
+		if err != nil {
+			log.Printf("[REJECT] edits 缺少原图: %v", err)
+			writeError(w, http.StatusBadRequest, "image is required", "image")
+			return
+		}
+		reqBody["image"] = dataURI(src)
+
+		mask, err := readFormFile(r, "mask")
+		switch {
+		case errors.Is(err, http.ErrMissingFile):
+		case err != nil:
+			writeError(w, http.StatusBadRequest, "invalid mask upload", "mask")
+			return
+		default:
+			if err := validateEditMask(src, mask); err != nil {
+				log.Printf("[REJECT] 蒙版校验失败: %v", err)
+				writeError(w, http.StatusBadRequest, err.Error(), "mask")
+				return
+			}
+			reqBody["mask"] = dataURI(mask)
+		}
+
+		body, _ := json.Marshal(reqBody)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.MultipartForm = nil
+		next(w, r)
+	}
+}
+
+func readFormFile(r *http.Request, field string) ([]byte, error) {
+	f, _, err := r.FormFile(field)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func dataURI(data []byte) string {
+	_, contentType := formatInfo(data)
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// 蒙版必须是带 alpha 通道的 PNG，且尺寸与原图一致
+func validateEditMask(src, mask []byte) error {
+	maskCfg, maskFormat, err := image.DecodeConfig(bytes.NewReader(mask))
+	if err != nil {
+		return fmt.Errorf("mask is not a valid image: %v", err)
+	}
+	if maskFormat != "png" {
+		return fmt.Errorf("mask must be a PNG, got %s", maskFormat)
+	}
+	if !pngHasAlpha(mask) {
+		return errors.New("mask must be a PNG with an alpha channel")
+	}
+	srcCfg, _, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		return fmt.Errorf("image is not a valid image: %v", err)
+	}
+	if maskCfg.Width != srcCfg.Width || maskCfg.Height != srcCfg.Height {
+		return fmt.Errorf("mask dimensions %dx%d do not match image dimensions %dx%d",
+			maskCfg.Width, maskCfg.Height, srcCfg.Width, srcCfg.Height)
+	}
+	return nil
+}
+
+// 根据 IHDR 颜色类型或 tRNS 块判断 PNG 是否带透明度
+func pngHasAlpha(data []byte) bool {
+	if len(data) < 33 || !bytes.HasPrefix(data, pngHeader) {
+		return false
+	}
+	switch colorType := data[25]; colorType {
+	case 4, 6: // 灰度 + alpha / RGBA
+		return true
+	}
+	for p := len(pngHeader); p+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[p:]))
+		typ := string(data[p+4 : p+8])
+		switch typ {
+		case "tRNS":
+			return true
+		case "IDAT", "IEND":
+			return false
+		}
+		p += 12 + length
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 左半透明的 RGBA 蒙版
+func testMask(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			if x >= w/2 {
+				img.Set(x, y, color.NRGBA{A: 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func postEdit(t *testing.T, src, mask []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("prompt", "a red hat")
+	mw.WriteField("n", "1")
+	for name, data := range map[string][]byte{"image": src, "mask": mask} {
+		if data == nil {
+			continue
+		}
+		fw, _ := mw.CreateFormFile(name, name+".png")
+		fw.Write(data)
+	}
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/v1/images/edits", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	withEditForm(handleGenerations)(w, r)
+	return w
+}
+
+func TestEditMaskRejected(t *testing.T) {
+	var called bool
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) { called = true })
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+
+	cases := []struct {
+		name string
+		mask []byte
+		want string
+	}{
+		{"尺寸不一致", testMask(t, 8, 4), "mask dimensions 8x4 do not match image dimensions 4x4"},
+		{"缺少 alpha 通道", testPNG(t, 4, 4), "mask must be a PNG with an alpha channel"},
+		{"不是 PNG", testJPEG(t, 4, 4), "mask must be a PNG, got jpeg"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			called = false
+			w := postEdit(t, testPNG(t, 4, 4), c.mask)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), c.want) {
+				t.Fatalf("status = %d, body = %s, want 400 且包含 %q", w.Code, w.Body, c.want)
+			}
+			if !strings.Contains(w.Body.String(), `"param":"mask"`) {
+				t.Errorf("错误应指出 mask 参数: %s", w.Body)
+			}
+			if called {
+				t.Error("不合法的蒙版不应转发给上游")
+			}
+		})
+	}
+}
+
+func TestEditFormForwardedAsJSON(t *testing.T) {
+	var forwarded map[string]interface{}
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, &forwarded)
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+
+	w := postEdit(t, testPNG(t, 4, 4), testMask(t, 4, 4))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	if forwarded["prompt"] != "a red hat" || forwarded["n"] != float64(1) {
+		t.Errorf("文本字段未按 JSON 转发: %v", forwarded)
+	}
+	for _, field := range []string{"image", "mask"} {
+		if v, _ := forwarded[field].(string); !strings.HasPrefix(v, "data:image/png;base64,") {
+			t.Errorf("%s 应以 data URI 转发，实际 %.40q", field, v)
+		}
+	}
+
+	// 没有蒙版时只转发原图
+	forwarded = nil
+	if w := postEdit(t, testPNG(t, 4, 4), nil); w.Code != http.StatusOK {
+		t.Fatalf("无蒙版 status = %d, body = %s", w.Code, w.Body)
+	}
+	if _, ok := forwarded["mask"]; ok {
+		t.Error("未上传蒙版时不应转发 mask 字段")
+	}
+}
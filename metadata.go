@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	jpegSOI   = []byte{0xFF, 0xD8}
+	pngHeader = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// 移除图片中的 EXIF/XMP/文本等元数据，无法识别的格式原样返回
+func stripMetadata(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, jpegSOI):
+		return stripJPEGMetadata(data)
+	case bytes.HasPrefix(data, pngHeader):
+		return stripPNGMetadata(data)
+	}
+	return data, nil
+}
+
+// 删除 JPEG 中除 JFIF(APP0)、ICC(APP2)、Adobe(APP14) 外的 APPn 段及注释段
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, jpegSOI...)
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, errors.New("jpeg: invalid marker")
+		}
+		marker := data[pos+1]
+		// 扫描开始后为图像数据，直接保留剩余部分
+		if marker == 0xDA {
+			return append(out, data[pos:]...), nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errors.New("jpeg: truncated segment")
+		}
+		if !isJPEGMetadataSegment(marker, data[pos+4:end]) {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	return nil, errors.New("jpeg: missing start of scan")
+}
+
+func isJPEGMetadataSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xFE: // COM
+		return true
+	case marker == 0xE0, marker == 0xEE: // JFIF、Adobe 影响解码，保留
+		return false
+	case marker == 0xE2:
+		return !bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker >= 0xE1 && marker <= 0xEF:
+		return true
+	}
+	return false
+}
+
+// PNG 中携带元数据的辅助块
+var pngMetadataChunks = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+	"tIME": true,
+}
+
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, pngHeader...)
+	pos := len(pngHeader)
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, errors.New("png: truncated chunk header")
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errors.New("png: truncated chunk")
+		}
+		if !pngMetadataChunks[string(data[pos+4:pos+8])] {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	return out, nil
+}
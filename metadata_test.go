@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// 在 SOI 之后插入一个 JPEG 段
+func withJPEGSegment(data []byte, marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	out := append([]byte(nil), data[:2]...)
+	out = append(out, seg...)
+	out = append(out, payload...)
+	return append(out, data[2:]...)
+}
+
+// 在 IHDR 之后插入一个 PNG 块
+func withPNGChunk(data []byte, typ string, payload []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, payload...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	ihdrEnd := len(pngHeader) + 12 + 13
+	out := append([]byte(nil), data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+func testJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestStripMetadataJPEG(t *testing.T) {
+	exif := append([]byte("Exif\x00\x00"), []byte("GPS 31.2304N 121.4737E")...)
+	src := withJPEGSegment(testJPEG(t, 4, 4), 0xE1, exif)
+	src = withJPEGSegment(src, 0xFE, []byte("camera serial 1234"))
+
+	out, err := stripMetadata(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, marker := range []string{"Exif", "GPS", "camera serial"} {
+		if bytes.Contains(out, []byte(marker)) {
+			t.Errorf("输出仍包含 %q", marker)
+		}
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("清理后无法解码: %v", err)
+	}
+}
+
+func TestStripMetadataPNG(t *testing.T) {
+	src := withPNGChunk(testPNG(t, 4, 4), "eXIf", []byte("MM\x00*GPS 31.2304N"))
+	src = withPNGChunk(src, "tEXt", []byte("Comment\x00camera serial 1234"))
+
+	out, err := stripMetadata(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, marker := range []string{"eXIf", "tEXt", "GPS", "camera serial"} {
+		if bytes.Contains(out, []byte(marker)) {
+			t.Errorf("输出仍包含 %q", marker)
+		}
+	}
+	if _, _, err := image.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("清理后无法解码: %v", err)
+	}
+}
+
+func TestStripMetadataInPipeline(t *testing.T) {
+	src := withPNGChunk(testPNG(t, 4, 4), "eXIf", []byte("MM\x00*GPS 31.2304N"))
+	cfg := &Config{StripMetadata: true}
+	if out := processImage(cfg, src, 0); bytes.Contains(out, []byte("GPS")) {
+		t.Fatal("开启 strip_metadata 后返回的图片仍带有 EXIF")
+	}
+}
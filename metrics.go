@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// 生成请求数与耗时，按租户（tenant.header 的取值）与结果区分
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sc_proxy_requests_total",
+		Help: "Image generation requests, by tenant and outcome.",
+	}, []string{"tenant", "outcome"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sc_proxy_request_duration_seconds",
+		Help:    "Duration of image generation requests, by tenant.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"tenant"})
+	// 生成接口响应体大小，按返回形式（b64_json / url / raw / sse / multipart）区分
+	responseSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sc_proxy_response_size_bytes",
+		Help:    "Size of image generation response bodies, by response format.",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+	}, []string{"response_format"})
+
+	// 上游调用各阶段耗时：dns / connect / tls / ttfb / total
+	upstreamPhaseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sc_proxy_upstream_phase_duration_seconds",
+		Help:    "Duration of each phase of upstream generation calls.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+	}, []string{"phase"})
+
+	// 因优先级被卸载的请求
+	requestsShed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sc_proxy_requests_shed_total",
+		Help: "Requests rejected by priority-based load shedding, by priority.",
+	}, []string{"priority"})
+
+	// 最近一次上游调用生效的自适应超时
+	upstreamAdaptiveTimeout = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sc_proxy_upstream_adaptive_timeout_seconds",
+		Help: "Upstream timeout applied to the most recent generation call.",
+	})
+
+	// 上游响应体中途断开或超时导致的截断
+	upstreamTruncatedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sc_proxy_upstream_truncated_total",
+		Help: "Upstream responses truncated before the body was complete.",
+	})
+
+	// 对冲请求：fired 为发出对冲，won 为对冲请求先返回
+	upstreamHedges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sc_proxy_upstream_hedges_total",
+		Help: "Hedged upstream requests, by outcome.",
+	}, []string{"outcome"})
+
+	// 存储配额统计的汇总值；不按客户端打标签，避免 API Key 数量导致序列无限增长
+	storageQuotaClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sc_proxy_storage_quota_clients",
+		Help: "Clients with images currently counted against the storage quota.",
+	})
+	storageQuotaImages = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sc_proxy_storage_quota_images",
+		Help: "Images currently counted against the storage quota, across all clients.",
+	})
+	storageQuotaBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sc_proxy_storage_quota_bytes",
+		Help: "Bytes currently counted against the storage quota, across all clients.",
+	})
+
+	// 存储配额触发的处理：rejected / evicted
+	storageQuotaActions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sc_proxy_storage_quota_actions_total",
+		Help: "Storage quota enforcement actions, by action.",
+	}, []string{"action"})
+)
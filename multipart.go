@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const cidDomain = "sc-proxy"
+
+// multipart/related 根部件中的图片条目，url 为 cid: 引用
+type cidDataItem struct {
+	URL           string `json:"url,omitempty"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Accept 头中是否明确列出指定的媒体类型
+func acceptsMediaType(r *http.Request, want string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == want {
+			return true
+		}
+	}
+	return false
+}
+
+// 客户端通过 Accept: multipart/related 请求邮件友好的响应
+func wantsMultipartRelated(r *http.Request) bool {
+	return acceptsMediaType(r, "multipart/related")
+}
+
+// 客户端通过 Accept: multipart/mixed 请求逐张流式返回的原始图片
+func wantsMultipartMixed(r *http.Request) bool {
+	return acceptsMediaType(r, "multipart/mixed")
+}
+
+func imageContentID(index int) string {
+	return fmt.Sprintf("image-%d@%s", index, cidDomain)
+}
+
+// 以 multipart/related 返回：首个部件为引用 cid: 的 JSON，其后每张图片一个部件
+func writeMultipartRelated(w http.ResponseWriter, images []Image, slots [][]imageSlot) {
+	mw := multipart.NewWriter(w)
+	rootID := "root@" + cidDomain
+
+	w.Header().Set("Content-Type", mime.FormatMediaType("multipart/related", map[string]string{
+		"boundary": mw.Boundary(),
+		"type":     "application/json",
+		"start":    "<" + rootID + ">",
+	}))
+
+	root := struct {
+		Created int64         `json:"created"`
+		Data    []cidDataItem `json:"data"`
+	}{Created: time.Now().Unix(), Data: make([]cidDataItem, len(images))}
+	for i, img := range images {
+		root.Data[i] = cidDataItem{RevisedPrompt: img.RevisedPrompt, Error: slots[i][0].err}
+		if slots[i][0].err == "" {
+			root.Data[i].URL = "cid:" + imageContentID(i)
+		}
+	}
+
+	rootPart, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"application/json; charset=utf-8"},
+		"Content-Id":   {"<" + rootID + ">"},
+	})
+	if err != nil {
+		log.Printf("[ERROR] 写入 multipart 根部件失败: %v", err)
+		return
+	}
+	json.NewEncoder(rootPart).Encode(root)
+
+	for i := range images {
+		slot := slots[i][0]
+		if slot.err != "" {
+			continue
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {http.DetectContentType(slot.data)},
+			"Content-Id":                {"<" + imageContentID(i) + ">"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf(`inline; filename="image-%d"`, i)},
+		})
+		if err != nil {
+			log.Printf("[ERROR] 写入 multipart 图片部件失败: %v", err)
+			return
+		}
+		writeWrappedBase64(part, slot.data)
+	}
+
+	if err := mw.Close(); err != nil {
+		log.Printf("[ERROR] 关闭 multipart 响应失败: %v", err)
+	}
+	log.Printf("[SUCCESS] 以 multipart/related 返回 - 图片数量: %d", countDownloaded(slots))
+}
+
+// 按 RFC 2045 每行 76 字符输出 base64
+func writeWrappedBase64(w io.Writer, data []byte) {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 76 {
+		w.Write([]byte(enc[:76] + "\r\n"))
+		enc = enc[76:]
+	}
+	w.Write([]byte(enc + "\r\n"))
+}
+
+// 以 multipart/mixed 流式返回：每个变体下载完成即写出一个部件，
+// 部件为原始图片字节（失败时为 JSON 错误），无需 base64 也不必在内存中攒齐全部图片。
+// 超过 max_stream_duration 时以一个带 X-Stream-Aborted 的 JSON 错误部件结尾
+func streamMultipartMixed(parent context.Context, w http.ResponseWriter, cfg *Config, images []Image, start time.Time, seed string) int {
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// 总耗时等信息要等全部部件写完才知道，以 trailer 形式在响应末尾发送
+	w.Header().Set("Trailer", "X-Total-Duration-Ms, X-Seed, X-Images-Written")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	ctx, cancel := streamContext(parent, cfg)
+	defer cancel()
+	written := 0
+	fetchImagesStream(ctx, cfg, images, func(ref slotRef, slot imageSlot) {
+		if ctx.Err() != nil {
+			return
+		}
+		header := textproto.MIMEHeader{
+			"X-Image-Index": {strconv.Itoa(ref.index)},
+		}
+		if slot.typ != "" {
+			header.Set("X-Image-Variant", slot.typ)
+		}
+		var body []byte
+		if slot.err != "" {
+			header.Set("Content-Type", "application/json")
+			body, _ = json.Marshal(map[string]interface{}{"index": ref.index, "error": slot.err})
+		} else {
+			ext, contentType := formatInfo(slot.data)
+			header.Set("Content-Type", contentType)
+			header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="image-%d%s"`, ref.index, ext))
+			body = slot.data
+			if ref.variant == 0 {
+				written++
+			}
+		}
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			log.Printf("[ERROR] 写入 multipart 部件失败: %v", err)
+			return
+		}
+		part.Write(body)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+
+	if parent.Err() != nil {
+		log.Printf("[WARN] 客户端已断开，multipart 响应提前结束")
+		return written
+	}
+	if streamExpired(ctx) {
+		log.Printf("[WARN] multipart 响应超过最长时长 %v，已中止", time.Duration(cfg.MaxStreamDuration))
+		if part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":     {"application/json"},
+			"X-Stream-Aborted": {"true"},
+		}); err == nil {
+			json.NewEncoder(part).Encode(map[string]string{"error": errStreamDurationExceeded.Error()})
+		}
+	}
+	if err := mw.Close(); err != nil {
+		log.Printf("[ERROR] 关闭 multipart 响应失败: %v", err)
+	}
+	w.Header().Set("X-Total-Duration-Ms", strconv.FormatInt(time.Since(start).Milliseconds(), 10))
+	w.Header().Set("X-Seed", seed)
+	w.Header().Set("X-Images-Written", strconv.Itoa(written))
+	log.Printf("[SUCCESS] 以 multipart/mixed 返回 - 图片数量: %d", written)
+	return written
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMultipartRelatedCIDsResolve(t *testing.T) {
+	a, b := testPNG(t, 2, 2), testPNG(t, 3, 3)
+	srvA, srvB := newImageServer(t, a), newImageServer(t, b)
+	up := newUpstream(t, []string{srvA.URL + "/a.png", srvB.URL + "/b.png"}, nil)
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+
+	w := postGenerations(t, `{"prompt":"x","n":2}`, "Accept", "multipart/related")
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/related" {
+		t.Fatalf("Content-Type = %q", w.Header().Get("Content-Type"))
+	}
+
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	var root struct {
+		Data []cidDataItem `json:"data"`
+	}
+	parts := make(map[string][]byte)
+	for i := 0; ; i++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := strings.Trim(part.Header.Get("Content-Id"), "<>")
+		body, _ := io.ReadAll(part)
+		if i == 0 {
+			if "<"+id+">" != params["start"] {
+				t.Fatalf("首个部件 %q 不是 start 指定的根部件 %q", id, params["start"])
+			}
+			if err := json.Unmarshal(body, &root); err != nil {
+				t.Fatalf("根部件不是 JSON: %v", err)
+			}
+			continue
+		}
+		if part.Header.Get("Content-Type") != "image/png" {
+			t.Errorf("图片部件 Content-Type = %q", part.Header.Get("Content-Type"))
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(body), "\r\n", ""))
+		if err != nil {
+			t.Fatalf("图片部件 base64 无效: %v", err)
+		}
+		parts[id] = data
+	}
+
+	if len(root.Data) != 2 {
+		t.Fatalf("根部件 data 条目数 = %d", len(root.Data))
+	}
+	for i, want := range [][]byte{a, b} {
+		ref := root.Data[i].URL
+		if !strings.HasPrefix(ref, "cid:") {
+			t.Fatalf("data[%d].url = %q, 应为 cid: 引用", i, ref)
+		}
+		if got, ok := parts[strings.TrimPrefix(ref, "cid:")]; !ok || !bytes.Equal(got, want) {
+			t.Errorf("data[%d] 的 %s 未指向对应的图片部件", i, ref)
+		}
+	}
+}
+
+type mixedPart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// 读取 multipart/mixed 响应的全部部件
+func readMixedParts(t *testing.T, contentType string, body io.Reader) []mixedPart {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q", contentType)
+	}
+	var parts []mixedPart
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(part)
+		parts = append(parts, mixedPart{header: part.Header, body: data})
+	}
+}
+
+func TestMultipartMixedOnePartPerImage(t *testing.T) {
+	png, jpg := testPNG(t, 2, 2), testJPEG(t, 2, 2)
+	pngSrv, jpgSrv := newImageServer(t, png), newImageServer(t, jpg)
+	missing := newUpstreamFunc(t, http.NotFound)
+	up := newUpstream(t, []string{pngSrv.URL + "/a", jpgSrv.URL + "/b", missing.URL + "/c"}, nil)
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+
+	w := postGenerations(t, `{"prompt":"x","n":3}`, "Accept", "multipart/mixed")
+	parts := readMixedParts(t, w.Header().Get("Content-Type"), w.Body)
+	if len(parts) != 3 {
+		t.Fatalf("部件数 = %d, want 3", len(parts))
+	}
+	want := map[string]struct {
+		contentType string
+		data        []byte
+	}{
+		"0": {"image/png", png},
+		"1": {"image/jpeg", jpg},
+		"2": {"application/json", nil},
+	}
+	for _, p := range parts {
+		idx := p.header.Get("X-Image-Index")
+		exp, ok := want[idx]
+		if !ok {
+			t.Fatalf("意外的部件 X-Image-Index=%q", idx)
+		}
+		delete(want, idx)
+		if ct := p.header.Get("Content-Type"); ct != exp.contentType {
+			t.Errorf("部件 %s Content-Type = %q, want %q", idx, ct, exp.contentType)
+		}
+		if exp.data != nil && !bytes.Equal(p.body, exp.data) {
+			t.Errorf("部件 %s 不是原始图片字节", idx)
+		}
+		if exp.data == nil && !strings.Contains(string(p.body), `"error"`) {
+			t.Errorf("失败的图片应以 JSON 错误部件返回: %s", p.body)
+		}
+	}
+}
+
+func TestMultipartMixedTrailers(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	up := newUpstream(t, []string{img.URL + "/a", img.URL + "/b"}, nil)
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	// trailer 只能通过真实的 HTTP 连接读取
+	proxy := httptest.NewServer(http.HandlerFunc(handleGenerations))
+	defer proxy.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, proxy.URL, strings.NewReader(`{"prompt":"x","n":2}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "multipart/mixed")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if parts := readMixedParts(t, resp.Header.Get("Content-Type"), resp.Body); len(parts) != 2 {
+		t.Fatalf("部件数 = %d, want 2", len(parts))
+	}
+
+	if got := resp.Trailer.Get("X-Seed"); got != "42" {
+		t.Errorf("X-Seed trailer = %q, want 42", got)
+	}
+	if got := resp.Trailer.Get("X-Images-Written"); got != "2" {
+		t.Errorf("X-Images-Written trailer = %q, want 2", got)
+	}
+	if _, err := strconv.Atoi(resp.Trailer.Get("X-Total-Duration-Ms")); err != nil {
+		t.Errorf("X-Total-Duration-Ms trailer = %q", resp.Trailer.Get("X-Total-Duration-Ms"))
+	}
+	if resp.Header.Get("X-Seed") != "" {
+		t.Error("元数据不应出现在响应头中")
+	}
+}
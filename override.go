@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+)
+
+// 按 model_override.header 替换请求体中的 model；未开启或请求未带该头时不做修改
+func applyModelOverride(cfg *Config, header http.Header, reqBody map[string]interface{}) error {
+	mo := cfg.ModelOverride
+	if !mo.Enabled {
+		return nil
+	}
+	model := header.Get(mo.Header)
+	if model == "" {
+		return nil
+	}
+	allowed := slices.Contains(mo.Allowed, model)
+	if len(mo.Allowed) == 0 {
+		_, allowed = cfg.Models[model]
+	}
+	if !allowed {
+		return fmt.Errorf("model %q is not allowed in %s", model, mo.Header)
+	}
+	log.Printf("[OVERRIDE] 模型 %v -> %s", reqBody["model"], model)
+	reqBody["model"] = model
+	return nil
+}
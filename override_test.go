@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestModelOverrideHeader(t *testing.T) {
+	var forwarded map[string]interface{}
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, &forwarded)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.ModelOverride = ModelOverrideConfig{Enabled: true, Header: "X-Model-Override", Allowed: []string{"flux-dev"}}
+	})
+	body := `{"model":"flux-schnell","prompt":"x"}`
+
+	if w := postGenerations(t, body, "X-Model-Override", "flux-dev"); w.Code != http.StatusOK || forwarded["model"] != "flux-dev" {
+		t.Errorf("允许的覆盖: status = %d, 上游收到 model = %v", w.Code, forwarded["model"])
+	}
+
+	forwarded = nil
+	w := postGenerations(t, body, "X-Model-Override", "flux-pro")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("不允许的覆盖 status = %d, want 400", w.Code)
+	}
+	if e := decodeOpenAIError(t, w); e.Param != "model" {
+		t.Errorf("param = %q, want model", e.Param)
+	}
+	if forwarded != nil {
+		t.Error("不允许的覆盖不应调用上游")
+	}
+
+	if postGenerations(t, body); forwarded["model"] != "flux-schnell" {
+		t.Errorf("未带覆盖头时应原样转发，model = %v", forwarded["model"])
+	}
+
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	if postGenerations(t, body, "X-Model-Override", "flux-dev"); forwarded["model"] != "flux-schnell" {
+		t.Errorf("未开启 model_override 时应忽略覆盖头，model = %v", forwarded["model"])
+	}
+}
+
+func TestModelOverrideDefaultsToConfiguredModels(t *testing.T) {
+	cfg := &Config{
+		ModelOverride: ModelOverrideConfig{Enabled: true, Header: "X-Model-Override"},
+		Models:        map[string]ModelConfig{"flux-dev": {}},
+	}
+	header := http.Header{}
+	header.Set("X-Model-Override", "flux-dev")
+	body := map[string]interface{}{"model": "flux-schnell"}
+	if err := applyModelOverride(cfg, header, body); err != nil || body["model"] != "flux-dev" {
+		t.Errorf("models 中配置过的模型应允许，err = %v, model = %v", err, body["model"])
+	}
+	header.Set("X-Model-Override", "unknown")
+	if err := applyModelOverride(cfg, header, body); err == nil {
+		t.Error("未配置过的模型应拒绝")
+	}
+}
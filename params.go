@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 指向具体请求参数的校验错误，返回给客户端时带上 param 字段
+type paramError struct {
+	param   string
+	message string
+}
+
+func (e *paramError) Error() string { return e.message }
+
+var sizePattern = regexp.MustCompile(`^[1-9][0-9]*x[1-9][0-9]*$`)
+
+// 每组参数至多出现一个，同时出现时返回指向后一个参数的错误，信息中列出冲突的两个字段
+func checkExclusiveParams(groups [][]string, reqBody map[string]interface{}) error {
+	for _, group := range groups {
+		first := ""
+		for _, name := range group {
+			if _, ok := reqBody[name]; !ok {
+				continue
+			}
+			if first != "" {
+				return &paramError{param: name, message: fmt.Sprintf("%s and %s are mutually exclusive", first, name)}
+			}
+			first = name
+		}
+	}
+	return nil
+}
+
+// numeric_params 中以字符串给出的字段（如 "n": "2"）转换为数字后再校验与转发，不是数字时返回指向该字段的错误
+func coerceNumericParams(fields []string, reqBody map[string]interface{}) error {
+	for _, name := range fields {
+		s, ok := reqBody[name].(string)
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return &paramError{param: name, message: fmt.Sprintf("%s must be a number, got %q", name, s)}
+		}
+		reqBody[name] = f
+	}
+	return nil
+}
+
+// 校验 n、size、seed 的类型与取值，缺省的字段不检查
+func validateGenerationParams(reqBody map[string]interface{}) error {
+	if v, ok := reqBody["n"]; ok {
+		if f, ok := v.(float64); !ok || f != float64(int64(f)) || f < 1 {
+			return &paramError{param: "n", message: "n must be a positive integer"}
+		}
+	}
+	if v, ok := reqBody["size"]; ok {
+		if s, ok := v.(string); !ok || s != "auto" && !sizePattern.MatchString(s) {
+			return &paramError{param: "size", message: fmt.Sprintf("size must be \"auto\" or WIDTHxHEIGHT, got %v", v)}
+		}
+	}
+	if v, ok := reqBody["seed"]; ok {
+		if f, ok := v.(float64); !ok || f != float64(int64(f)) {
+			return &paramError{param: "seed", message: "seed must be an integer"}
+		}
+	}
+	return nil
+}
+
+// 按 content_type_check 校验请求体类型：off 不检查，lenient 只拒绝明确声明为非 JSON 的请求，
+// strict 要求 application/json（或 +json 后缀）
+func checkContentType(mode, contentType string) error {
+	if mode == "off" || contentType == "" && mode == "lenient" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) {
+		return nil
+	}
+	if contentType == "" {
+		contentType = "(none)"
+	}
+	return fmt.Errorf("unsupported Content-Type %s, expected application/json", contentType)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 解析 OpenAI 结构的错误响应
+func decodeOpenAIError(t *testing.T, w *httptest.ResponseRecorder) openAIError {
+	t.Helper()
+	var resp struct {
+		Error openAIError `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("响应不是 OpenAI 错误结构: %v\n%s", err, w.Body)
+	}
+	return resp.Error
+}
+
+func TestValidationErrorParam(t *testing.T) {
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Models = map[string]ModelConfig{"m": {MaxN: 2}}
+	})
+
+	cases := []struct {
+		body  string
+		param string
+	}{
+		{`{"prompt":"x","n":0}`, "n"},
+		{`{"prompt":"x","n":1.5}`, "n"},
+		{`{"prompt":"x","size":"1024*1024"}`, "size"},
+		{`{"prompt":"x","size":1024}`, "size"},
+		{`{"prompt":"x","seed":"abc"}`, "seed"},
+		{`{"model":"m","prompt":"x","n":3}`, "n"},
+	}
+	for _, c := range cases {
+		w := postGenerations(t, c.body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", c.body, w.Code)
+			continue
+		}
+		e := decodeOpenAIError(t, w)
+		if e.Param != c.param || e.Type != "invalid_request_error" || e.Message == "" {
+			t.Errorf("%s: error = %+v, want param %q", c.body, e, c.param)
+		}
+	}
+
+	if w := postGenerations(t, `{"prompt":"x","n":2,"size":"auto","seed":7}`); w.Code != http.StatusOK {
+		t.Errorf("合法参数 status = %d, want 200", w.Code)
+	}
+}
+
+func TestContentTypeCheck(t *testing.T) {
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, nil)
+	cases := []struct {
+		mode, contentType string
+		want              int
+	}{
+		{"off", "application/x-www-form-urlencoded", http.StatusOK},
+		{"lenient", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"lenient", "", http.StatusOK},
+		{"lenient", "application/json; charset=utf-8", http.StatusOK},
+		{"strict", "", http.StatusUnsupportedMediaType},
+		{"strict", "text/plain", http.StatusUnsupportedMediaType},
+		{"strict", "application/vnd.api+json", http.StatusOK},
+	}
+	for _, c := range cases {
+		useConfig(t, func(cfg *Config) {
+			cfg.UpstreamURL = up.URL
+			cfg.ContentTypeCheck = c.mode
+		})
+		w := postGenerations(t, `{"prompt":"x"}`, "Content-Type", c.contentType)
+		if w.Code != c.want {
+			t.Errorf("%s %q: status = %d, want %d, body = %s", c.mode, c.contentType, w.Code, c.want, w.Body)
+		}
+		if c.want == http.StatusUnsupportedMediaType && !strings.Contains(w.Body.String(), "expected application/json") {
+			t.Errorf("%s %q: 415 响应缺少说明: %s", c.mode, c.contentType, w.Body)
+		}
+	}
+}
+
+func TestExclusiveParams(t *testing.T) {
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, nil)
+	both := `{"prompt":"x","seed":1,"seeds":[2]}`
+
+	// 默认配置即检查 seed/seeds 与 size/image_size
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	cases := []struct {
+		body  string
+		param string
+		msg   string
+	}{
+		{both, "seeds", "seed and seeds are mutually exclusive"},
+		{`{"prompt":"x","size":"512x512","image_size":"1024x1024"}`, "image_size", "size and image_size are mutually exclusive"},
+		{`{"prompt":"x","seed":1}`, "", ""},
+		{`{"prompt":"x","seeds":[2]}`, "", ""},
+		{`{"prompt":"x","image_size":"1024x1024"}`, "", ""},
+	}
+	for _, c := range cases {
+		w := postGenerations(t, c.body)
+		if c.param == "" {
+			if w.Code != http.StatusOK {
+				t.Errorf("%s: status = %d, want 200, body = %s", c.body, w.Code, w.Body)
+			}
+			continue
+		}
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", c.body, w.Code)
+			continue
+		}
+		if e := decodeOpenAIError(t, w); e.Param != c.param || e.Message != c.msg {
+			t.Errorf("%s: error = %+v, want param %q, message %q", c.body, e, c.param, c.msg)
+		}
+	}
+
+	// 关闭 exclusive_params 后 size 与 image_size 可同时出现，但 seeds 拆分调用会覆盖 seed，两者仍不能同时出现
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.ExclusiveParams = nil
+	})
+	if w := postGenerations(t, `{"prompt":"x","size":"512x512","image_size":"1024x1024"}`); w.Code != http.StatusOK {
+		t.Errorf("关闭后 size 与 image_size status = %d, want 200", w.Code)
+	}
+	w := postGenerations(t, both)
+	if e := decodeOpenAIError(t, w); w.Code != http.StatusBadRequest || e.Param != "seeds" || e.Message != "seed and seeds are mutually exclusive" {
+		t.Errorf("关闭后 seed 与 seeds: status = %d, error = %+v, want 400", w.Code, e)
+	}
+}
+
+func TestNumericParamsCoerced(t *testing.T) {
+	var forwarded map[string]interface{}
+	up := newUpstream(t, []string{"https://cdn.example/a.png", "https://cdn.example/b.png"}, &forwarded)
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+
+	if w := postGenerations(t, `{"prompt":"x","n":" 2 ","guidance_scale":"7.5"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	if forwarded["n"] != float64(2) || forwarded["guidance_scale"] != 7.5 {
+		t.Errorf("转发的 n = %#v, guidance_scale = %#v, want 数字", forwarded["n"], forwarded["guidance_scale"])
+	}
+
+	for _, body := range []string{`{"prompt":"x","n":"two"}`, `{"prompt":"x","n":"NaN"}`} {
+		w := postGenerations(t, body)
+		if e := decodeOpenAIError(t, w); w.Code != http.StatusBadRequest || e.Param != "n" || !strings.Contains(e.Message, "must be a number") {
+			t.Errorf("%s: status = %d, error = %+v", body, w.Code, e)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+)
+
+// 下载完成后对图片字节做的后处理，失败时返回原图
+func processImage(cfg *Config, data []byte, index int) []byte {
+	if cfg.StillFrames != "" {
+		still, err := extractStillFrame(data, cfg.StillFrames)
+		switch {
+		case errors.Is(err, errNotAnimated):
+		case err != nil:
+			log.Printf("[WARN %d] 动图转静态图失败，保留原图: %v", index, err)
+		default:
+			log.Printf("[STILL %d] 动图已转为第一帧 %s: %d -> %d bytes", index, cfg.StillFrames, len(data), len(still))
+			data = still
+		}
+	}
+	// 缩小与增强会重新编码并丢弃色彩标记，已归一化为 sRGB 的图片需在之后补写
+	srgb, reencoded := false, false
+	if cfg.NormalizeColorProfile {
+		normalized, err := normalizeColorProfile(data)
+		switch {
+		case errors.Is(err, errNoProfile):
+		case err != nil:
+			log.Printf("[WARN %d] 色彩配置转换失败，保留原图: %v", index, err)
+		default:
+			data = normalized
+			srgb = true
+		}
+	}
+	if scaled, err := downscaleImage(data, cfg.MaxImageDimension); err == nil {
+		log.Printf("[DOWNSCALE %d] 图片超过 %dpx，已按比例缩小: %d -> %d bytes", index, cfg.MaxImageDimension, len(data), len(scaled))
+		data = scaled
+		reencoded = true
+	} else if !errors.Is(err, errDownscaleSkipped) {
+		log.Printf("[WARN %d] 图片缩小失败，保留原图: %v", index, err)
+	}
+	if enhanced, err := enhanceImage(cfg.Enhance, data); err == nil {
+		log.Printf("[ENHANCE %d] 锐化/对比度处理完成", index)
+		data = enhanced
+		reencoded = true
+	} else if !errors.Is(err, errEnhanceSkipped) {
+		log.Printf("[WARN %d] 图片增强失败，保留原图: %v", index, err)
+	}
+	if srgb && reencoded {
+		data = embedSRGBMarker(data)
+	}
+	if cfg.StripMetadata {
+		stripped, err := stripMetadata(data)
+		if err != nil {
+			log.Printf("[WARN %d] 元数据清理失败，保留原图: %v", index, err)
+		} else {
+			log.Printf("[STRIP %d] 元数据清理完成: %d -> %d bytes", index, len(data), len(stripped))
+			data = stripped
+		}
+	}
+	return data
+}
+
+// 全部图片都失败时以配置的占位图代替主变体，并通过 X-Fallback-Image 标明
+func applyFallbackImage(w http.ResponseWriter, cfg *Config, slots [][]imageSlot) bool {
+	if len(cfg.fallbackImage) == 0 || len(slots) == 0 || countDownloaded(slots) > 0 {
+		return false
+	}
+	log.Printf("[WARN] 全部 %d 张图片失败，返回占位图", len(slots))
+	for i := range slots {
+		if len(slots[i]) > 0 {
+			slots[i][0].data = cfg.fallbackImage
+			slots[i][0].err = ""
+		}
+	}
+	w.Header().Set("X-Fallback-Image", "true")
+	return true
+}
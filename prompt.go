@@ -0,0 +1,18 @@
+package main
+
+import "strings"
+
+// 提示词模板中代表客户端原始提示词的占位符
+const promptPlaceholder = "{prompt}"
+
+// 按模型套用提示词模板；模板不含占位符时追加到原提示词之后
+func applyPromptTemplate(templates map[string]string, model, prompt string) string {
+	tmpl, ok := templates[model]
+	if !ok || tmpl == "" {
+		return prompt
+	}
+	if strings.Contains(tmpl, promptPlaceholder) {
+		return strings.ReplaceAll(tmpl, promptPlaceholder, prompt)
+	}
+	return prompt + " " + tmpl
+}
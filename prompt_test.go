@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPromptTemplateAppliedPerModel(t *testing.T) {
+	var forwarded map[string]interface{}
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, &forwarded)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.PromptTemplates = map[string]string{
+			"flux": "{prompt}, no logos, family friendly",
+			"sdxl": "brand safe",
+		}
+	})
+
+	for model, want := range map[string]string{
+		"flux":  "a cat, no logos, family friendly",
+		"sdxl":  "a cat brand safe",
+		"other": "a cat",
+	} {
+		w := postGenerations(t, `{"model":"`+model+`","prompt":"a cat"}`)
+		if forwarded["prompt"] != want {
+			t.Errorf("%s: 转发的 prompt = %q, want %q", model, forwarded["prompt"], want)
+		}
+		// 模板对客户端不可见
+		if strings.Contains(w.Body.String(), "brand safe") || strings.Contains(w.Body.String(), "no logos") {
+			t.Errorf("%s: 响应中暴露了模板内容: %s", model, w.Body)
+		}
+	}
+}
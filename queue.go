@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var requestPriorities = []string{"high", "normal", "low"}
+
+var (
+	errShedByPriority    = errors.New("shed by priority under load")
+	errQueueFull         = errors.New("request queue is full")
+	errQueueWaitExceeded = errors.New("queue wait exceeded request budget")
+)
+
+// 请求排队：限制同时处理的请求数，超出的请求排队等待
+type requestQueue struct {
+	slots      chan struct{}
+	waiting    atomic.Int64
+	maxWaiting int64
+}
+
+var queue *requestQueue
+
+func newRequestQueue(cfg QueueConfig) *requestQueue {
+	if !cfg.Enabled || cfg.MaxConcurrent <= 0 {
+		return nil
+	}
+	return &requestQueue{
+		slots:      make(chan struct{}, cfg.MaxConcurrent),
+		maxWaiting: int64(cfg.MaxWaiting),
+	}
+}
+
+// 获取处理槽位，最多等待 maxWait；maxWait 为 0 时只受 ctx 约束
+func (q *requestQueue) Acquire(ctx context.Context, maxWait time.Duration) (release func(), err error) {
+	select {
+	case q.slots <- struct{}{}:
+		return q.release, nil
+	default:
+	}
+
+	if q.maxWaiting > 0 && q.waiting.Load() >= q.maxWaiting {
+		return nil, errQueueFull
+	}
+	q.waiting.Add(1)
+	defer q.waiting.Add(-1)
+
+	var timeout <-chan time.Time
+	if maxWait > 0 {
+		timer := time.NewTimer(maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case q.slots <- struct{}{}:
+		return q.release, nil
+	case <-timeout:
+		return nil, errQueueWaitExceeded
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (q *requestQueue) release() {
+	<-q.slots
+}
+
+// 处理中的请求占 max_concurrent 的比例
+func (q *requestQueue) load() float64 {
+	return float64(len(q.slots)) / float64(cap(q.slots))
+}
+
+func requestPriority(cfg PriorityConfig, r *http.Request) string {
+	p := strings.ToLower(strings.TrimSpace(r.Header.Get(cfg.Header)))
+	if slices.Contains(requestPriorities, p) {
+		return p
+	}
+	return cfg.Default
+}
+
+// 排队中间件：请求总预算覆盖排队与处理，排队耗时超过预算的一定比例时直接拒绝，
+// 避免把即将超时的请求发往上游
+func withQueue(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := queue
+		if q == nil {
+			next(w, r)
+			return
+		}
+		qc := currentConfig().Queue
+		start := time.Now()
+
+		ctx := r.Context()
+		budget := time.Duration(qc.RequestBudget)
+		var maxWait time.Duration
+		if budget > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, start.Add(budget))
+			defer cancel()
+			maxWait = time.Duration(float64(budget) * qc.MaxWaitFraction)
+		}
+
+		// 负载达到该优先级的阈值时直接卸载，把处理能力留给更高优先级的请求
+		priority := requestPriority(qc.Priority, r)
+		if threshold, ok := qc.Priority.ShedAt[priority]; ok && q.load() >= threshold {
+			log.Printf("[QUEUE] 拒绝请求: %v (priority=%s, load=%.2f)", errShedByPriority, priority, q.load())
+			requestsShed.WithLabelValues(priority).Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, `{"error":"Server busy, please retry later"}`, http.StatusServiceUnavailable)
+			return
+		}
+
+		release, err := q.Acquire(ctx, maxWait)
+		if err != nil {
+			log.Printf("[QUEUE] 拒绝请求: %v (已等待 %v)", err, time.Since(start))
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, `{"error":"Server busy, please retry later"}`, http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+
+		if wait := time.Since(start); wait > 0 {
+			log.Printf("[QUEUE] 排队耗时: %v", wait)
+		}
+		next(w, r.WithContext(ctx))
+	}
+}
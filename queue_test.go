@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestQueueRejectsBeforeBudgetExpires(t *testing.T) {
+	cfg := useConfig(t, func(c *Config) {
+		c.Queue.Enabled = true
+		c.Queue.MaxConcurrent = 1
+		c.Queue.RequestBudget = Duration(400 * time.Millisecond)
+		c.Queue.MaxWaitFraction = 0.25
+	})
+	q := newRequestQueue(cfg.Queue)
+	swapGlobal(t, &queue, q)
+
+	// 占满唯一的处理槽位
+	release, err := q.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	called := false
+	h := withQueue(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+	start := time.Now()
+	w := serveGenerations(t, h, `{}`)
+	elapsed := time.Since(start)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", w.Code)
+	}
+	if called {
+		t.Fatal("排队超时的请求不应进入处理")
+	}
+	if elapsed < 80*time.Millisecond || elapsed > 300*time.Millisecond {
+		t.Errorf("应在预算的 25%% 左右放弃排队，实际等待 %v", elapsed)
+	}
+
+	// 槽位在最长排队时间内空出时正常处理，且处理阶段受剩余预算约束
+	time.AfterFunc(20*time.Millisecond, release)
+	var deadline time.Time
+	h = withQueue(func(w http.ResponseWriter, r *http.Request) { deadline, _ = r.Context().Deadline() })
+	start = time.Now()
+	if w := serveGenerations(t, h, `{}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if d := deadline.Sub(start); d < 400*time.Millisecond || d > 450*time.Millisecond {
+		t.Errorf("处理阶段的截止时间应为排队开始后的总预算，实际 %v", d)
+	}
+}
+
+func TestQueueShedsLowPriorityFirst(t *testing.T) {
+	cfg := useConfig(t, func(c *Config) {
+		c.Queue.Enabled = true
+		c.Queue.MaxConcurrent = 4
+		c.Queue.RequestBudget = Duration(time.Second)
+		c.Queue.MaxWaitFraction = 0.5
+		c.Queue.Priority.ShedAt = map[string]float64{"low": 0.5, "normal": 1}
+	})
+	q := newRequestQueue(cfg.Queue)
+	swapGlobal(t, &queue, q)
+	h := withQueue(func(w http.ResponseWriter, r *http.Request) {})
+	status := func(priority string) int {
+		t.Helper()
+		return serveGenerations(t, h, `{}`, "X-Priority", priority).Code
+	}
+	occupy := func(n int) []func() {
+		t.Helper()
+		var releases []func()
+		for range n {
+			release, err := q.Acquire(context.Background(), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			releases = append(releases, release)
+		}
+		return releases
+	}
+	shedLow := testutil.ToFloat64(requestsShed.WithLabelValues("low"))
+
+	// 半载：卸载 low，normal 与 high 照常处理
+	held := occupy(2)
+	if got := status("low"); got != http.StatusServiceUnavailable {
+		t.Errorf("半载时 low status = %d, want 503", got)
+	}
+	for _, p := range []string{"normal", "high", ""} {
+		if got := status(p); got != http.StatusOK {
+			t.Errorf("半载时 priority=%q status = %d, want 200", p, got)
+		}
+	}
+
+	// 满载：normal 也被卸载，high 排队等到槽位空出
+	held = append(held, occupy(2)...)
+	if got := status("normal"); got != http.StatusServiceUnavailable {
+		t.Errorf("满载时 normal status = %d, want 503", got)
+	}
+	time.AfterFunc(20*time.Millisecond, held[0])
+	if got := status("high"); got != http.StatusOK {
+		t.Errorf("满载时 high 应排队后处理，status = %d", got)
+	}
+	for _, release := range held[1:] {
+		release()
+	}
+
+	if got := testutil.ToFloat64(requestsShed.WithLabelValues("low")) - shedLow; got != 1 {
+		t.Errorf("sc_proxy_requests_shed_total{priority=low} 增加 %v, want 1", got)
+	}
+}
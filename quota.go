@@ -0,0 +1,201 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+var errQuotaExceeded = errors.New("storage quota exceeded")
+
+// 按客户端（登记的 API Key 或客户端 IP）统计已存储的图片，超出配额时拒绝或淘汰最早的图片。
+// 统计仅保存在内存中，重启后从零开始
+type storageQuota struct {
+	mu        sync.Mutex
+	maxImages int
+	maxBytes  int64
+	evict     bool
+	clients   map[string]*clientUsage
+	// 全部客户端的合计，用于指标
+	images int
+	bytes  int64
+}
+
+type clientUsage struct {
+	objects []storedObject // 按存储时间先后排列
+	bytes   int64
+}
+
+type storedObject struct {
+	name string
+	size int64
+}
+
+var quota *storageQuota
+
+func newStorageQuota(cfg StorageConfig) *storageQuota {
+	if cfg.MaxImagesPerClient <= 0 && cfg.MaxBytesPerClient <= 0 {
+		return nil
+	}
+	return &storageQuota{
+		maxImages: cfg.MaxImagesPerClient,
+		maxBytes:  cfg.MaxBytesPerClient,
+		evict:     cfg.QuotaPolicy == "evict_oldest",
+		clients:   make(map[string]*clientUsage),
+	}
+}
+
+// 配额与限流使用相同的客户端标识：代理不校验 API Key，只有 rate_limit_key_hashes 中登记的 Key
+// 按 Key 统计，其余按客户端 IP，更换 Authorization 不会得到新的配额
+func quotaClient(r *http.Request, cfg *Config) string {
+	return rateLimitClient(r, cfg)
+}
+
+func (q *storageQuota) exceeded(u *clientUsage, size int64) bool {
+	if q.maxImages > 0 && len(u.objects)+1 > q.maxImages {
+		return true
+	}
+	return q.maxBytes > 0 && u.bytes+size > q.maxBytes
+}
+
+// 为即将写入的对象登记配额，返回需要删除的旧对象；超出且不允许淘汰时返回 errQuotaExceeded
+func (q *storageQuota) admit(client, name string, size int64) ([]string, error) {
+	if q == nil {
+		return nil, nil
+	}
+	if q.maxBytes > 0 && size > q.maxBytes {
+		storageQuotaActions.WithLabelValues("rejected").Inc()
+		return nil, errQuotaExceeded
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u := q.clients[client]
+	if u == nil {
+		u = &clientUsage{}
+		q.clients[client] = u
+	}
+	defer q.report(client, u)
+	var evicted []string
+	for q.exceeded(u, size) {
+		if !q.evict || len(u.objects) == 0 {
+			storageQuotaActions.WithLabelValues("rejected").Inc()
+			return nil, errQuotaExceeded
+		}
+		oldest := u.objects[0]
+		u.objects = u.objects[1:]
+		u.bytes -= oldest.size
+		q.images--
+		q.bytes -= oldest.size
+		evicted = append(evicted, oldest.name)
+		storageQuotaActions.WithLabelValues("evicted").Inc()
+	}
+	u.objects = append(u.objects, storedObject{name: name, size: size})
+	u.bytes += size
+	q.images++
+	q.bytes += size
+	return evicted, nil
+}
+
+// 写入失败时撤销登记
+func (q *storageQuota) release(client, name string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u := q.clients[client]
+	if u == nil {
+		return
+	}
+	for i, obj := range u.objects {
+		if obj.name == name {
+			u.objects = append(u.objects[:i], u.objects[i+1:]...)
+			u.bytes -= obj.size
+			q.images--
+			q.bytes -= obj.size
+			break
+		}
+	}
+	q.report(client, u)
+}
+
+// 更新汇总指标，并移除已无图片的客户端，避免 map 随 Key 数量增长
+func (q *storageQuota) report(client string, u *clientUsage) {
+	if len(u.objects) == 0 {
+		delete(q.clients, client)
+	}
+	storageQuotaClients.Set(float64(len(q.clients)))
+	storageQuotaImages.Set(float64(q.images))
+	storageQuotaBytes.Set(float64(q.bytes))
+}
+
+// 单个客户端的存储用量
+type ClientStorageUsage struct {
+	Client string `json:"client"`
+	Images int    `json:"images"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// 按字节数从大到小返回用量最多的 limit 个客户端
+func (q *storageQuota) top(limit int) []ClientStorageUsage {
+	usage := []ClientStorageUsage{}
+	if q == nil {
+		return usage
+	}
+	q.mu.Lock()
+	for client, u := range q.clients {
+		usage = append(usage, ClientStorageUsage{Client: client, Images: len(u.objects), Bytes: u.bytes})
+	}
+	q.mu.Unlock()
+	slices.SortFunc(usage, func(a, b ClientStorageUsage) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), cmp.Compare(a.Client, b.Client))
+	})
+	return usage[:min(limit, len(usage))]
+}
+
+// 默认返回的客户端数量，可用 ?limit= 调整
+const defaultStorageUsageLimit = 20
+
+// 查看各客户端的存储用量，指标不按客户端打标签，需要定位大户时使用
+func handleStorageUsage(w http.ResponseWriter, r *http.Request) {
+	limit := defaultStorageUsageLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer", "limit")
+			return
+		}
+		limit = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]ClientStorageUsage{"clients": quota.top(limit)})
+}
+
+// 在配额内写入存储，并删除被淘汰的旧图片
+func saveWithQuota(ctx context.Context, s Storage, client, name, contentType string, data []byte) (string, error) {
+	evicted, err := quota.admit(client, name, int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	for _, old := range evicted {
+		if err := s.Delete(ctx, old); err != nil && !errors.Is(err, errNotFound) {
+			log.Printf("[WARN] 删除超出配额的旧图片失败: %s: %v", old, err)
+			continue
+		}
+		log.Printf("[QUOTA] 客户端 %s 超出存储配额，已淘汰: %s", client, old)
+	}
+	url, err := s.Save(ctx, name, contentType, data)
+	if err != nil {
+		quota.release(client, name)
+		return "", fmt.Errorf("save %s: %w", name, err)
+	}
+	return url, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func useQuota(t *testing.T, policy string) *localStorage {
+	t.Helper()
+	img := newImageServer(t, testPNG(t, 2, 2))
+	up := newUpstream(t, []string{img.URL + "/a.png"}, nil)
+	cfg := useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Storage.Enabled = true
+		c.Storage.Dir = t.TempDir()
+		c.Storage.PublicBaseURL = "http://proxy.example"
+		c.Storage.MaxImagesPerClient = 2
+		c.Storage.QuotaPolicy = policy
+		c.RateLimitKeyHashes = []string{hashAPIKey("Bearer sk-a"), hashAPIKey("Bearer sk-b")}
+	})
+	swapGlobal(t, &quota, newStorageQuota(cfg.Storage))
+	return useStorage(t, cfg)
+}
+
+func storeAs(t *testing.T, key string) OpenAIURLItem {
+	t.Helper()
+	resp := decodeURLResponse(t, postGenerations(t, `{"prompt":"x"}`, "Authorization", "Bearer "+key))
+	if len(resp.Data) != 1 {
+		t.Fatalf("data 条目数 = %d", len(resp.Data))
+	}
+	return resp.Data[0]
+}
+
+func TestStorageQuotaRejectsClientOverLimit(t *testing.T) {
+	useQuota(t, "reject")
+
+	for i := range 2 {
+		if item := storeAs(t, "sk-a"); item.URL == "" {
+			t.Fatalf("第 %d 张图片应在配额内保存: %+v", i+1, item)
+		}
+	}
+	if item := storeAs(t, "sk-a"); item.URL != "" || item.Error != errQuotaExceeded.Error() {
+		t.Fatalf("超出配额的图片应被拒绝: %+v", item)
+	}
+	if item := storeAs(t, "sk-b"); item.URL == "" {
+		t.Errorf("其他客户端不受影响: %+v", item)
+	}
+
+	if got := testutil.ToFloat64(storageQuotaClients); got != 2 {
+		t.Errorf("sc_proxy_storage_quota_clients = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(storageQuotaImages); got != 3 {
+		t.Errorf("sc_proxy_storage_quota_images = %v, want 3", got)
+	}
+}
+
+func TestStorageQuotaEvictsOldest(t *testing.T) {
+	s := useQuota(t, "evict_oldest")
+
+	var names []string
+	for range 3 {
+		item := storeAs(t, "sk-a")
+		if item.URL == "" {
+			t.Fatalf("evict_oldest 模式不应拒绝保存: %+v", item)
+		}
+		names = append(names, strings.TrimPrefix(item.URL, "http://proxy.example/files/"))
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, names[0])); !os.IsNotExist(err) {
+		t.Errorf("最早的图片应被删除: %v", err)
+	}
+	for _, name := range names[1:] {
+		if _, err := os.Stat(filepath.Join(s.dir, name)); err != nil {
+			t.Errorf("配额内的图片 %s 不应被删除: %v", name, err)
+		}
+	}
+}
+
+func TestStorageQuotaDropsEmptyClients(t *testing.T) {
+	q := newStorageQuota(StorageConfig{MaxImagesPerClient: 1})
+	if _, err := q.admit("c", "a.png", 10); err != nil {
+		t.Fatal(err)
+	}
+	q.release("c", "a.png")
+	if len(q.clients) != 0 {
+		t.Errorf("无图片的客户端应从统计中移除，剩余 %d", len(q.clients))
+	}
+	if got := testutil.ToFloat64(storageQuotaBytes); got != 0 {
+		t.Errorf("sc_proxy_storage_quota_bytes = %v, want 0", got)
+	}
+}
+
+func TestStorageQuotaUnregisteredKeysShareIP(t *testing.T) {
+	useQuota(t, "reject")
+
+	// 未登记的 Key 按客户端 IP 统计，更换 Authorization 不能绕过配额
+	for i, key := range []string{"sk-x", "sk-y"} {
+		if item := storeAs(t, key); item.URL == "" {
+			t.Fatalf("第 %d 张图片应在配额内保存: %+v", i+1, item)
+		}
+	}
+	if item := storeAs(t, "sk-z"); item.Error != errQuotaExceeded.Error() {
+		t.Errorf("同一 IP 更换 Key 后仍应受配额限制: %+v", item)
+	}
+	if item := storeAs(t, "sk-a"); item.URL == "" {
+		t.Errorf("登记的 Key 单独统计: %+v", item)
+	}
+}
+
+func TestStorageUsageEndpoint(t *testing.T) {
+	useQuota(t, "reject")
+	storeAs(t, "sk-a")
+	storeAs(t, "sk-a")
+	storeAs(t, "sk-b")
+
+	usage := func(query string) (int, []ClientStorageUsage) {
+		w := httptest.NewRecorder()
+		handleStorageUsage(w, httptest.NewRequest(http.MethodGet, "/admin/storage-usage"+query, nil))
+		var resp struct {
+			Clients []ClientStorageUsage `json:"clients"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp.Clients
+	}
+
+	_, clients := usage("")
+	if len(clients) != 2 || clients[0].Client != "key:"+hashAPIKey("Bearer sk-a") || clients[0].Images != 2 || clients[0].Bytes <= clients[1].Bytes {
+		t.Fatalf("应按用量从大到小列出客户端: %+v", clients)
+	}
+	if _, clients := usage("?limit=1"); len(clients) != 1 || clients[0].Images != 2 {
+		t.Errorf("limit=1 应只返回用量最大的客户端: %+v", clients)
+	}
+	if code, _ := usage("?limit=0"); code != http.StatusBadRequest {
+		t.Errorf("limit=0 status = %d, want 400", code)
+	}
+}
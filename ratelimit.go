@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 按 接口 + 客户端 划分的令牌桶
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var endpointLimiter = &rateLimiter{buckets: make(map[string]*tokenBucket)}
+
+// 桶数量超过该值时清理已回满的桶
+const maxRateBuckets = 10000
+
+// 尝试消耗一个令牌，失败时返回需要等待的时间
+func (l *rateLimiter) allow(key string, limit RateLimitConfig, now time.Time) (bool, time.Duration) {
+	rate := float64(limit.RequestsPerMinute) / 60
+	burst := float64(max(limit.Burst, 1))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateBuckets {
+			l.sweep(now, rate, burst)
+		}
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+func (l *rateLimiter) sweep(now time.Time, rate, burst float64) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// 可配置频率限制的接口，与路由注册时传入 withRateLimit 的名称一致
+var rateLimitEndpoints = []string{"generations", "edits", "jobs", "files"}
+
+// 限流时识别客户端：代理不校验 API Key，任意更换 Authorization 即可得到新的桶，
+// 因此只有 rate_limit_key_hashes 中登记的 Key 按 Key 计数，其余一律按客户端 IP
+func rateLimitClient(r *http.Request, cfg *Config) string {
+	if h := hashAPIKey(r.Header.Get("Authorization")); h != "" && slices.Contains(cfg.RateLimitKeyHashes, h) {
+		return "key:" + h
+	}
+	return "ip:" + clientIP(r, cfg.trustedProxies)
+}
+
+// 按接口的客户端请求频率限制，未配置该接口时不限制；超出返回 429
+func withRateLimit(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := currentConfig()
+		limit, ok := cfg.RateLimits[endpoint]
+		if !ok || limit.RequestsPerMinute <= 0 {
+			next(w, r)
+			return
+		}
+		client := rateLimitClient(r, cfg)
+		allowed, wait := endpointLimiter.allow(endpoint+"|"+client, limit, time.Now())
+		if !allowed {
+			log.Printf("[LIMIT] 客户端 %s 请求 %s 超过频率上限 %d/min", client, endpoint, limit.RequestsPerMinute)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "Rate limit exceeded for "+endpoint)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func rateLimited(t *testing.T, endpoint string, header ...string) int {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	withRateLimit(endpoint, func(w http.ResponseWriter, r *http.Request) {})(w, r)
+	if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+		t.Error("429 响应缺少 Retry-After")
+	}
+	return w.Code
+}
+
+func useRateLimits(t *testing.T, mutate func(*Config)) {
+	t.Helper()
+	useConfig(t, func(c *Config) {
+		c.RateLimits = map[string]RateLimitConfig{
+			"generations": {RequestsPerMinute: 1, Burst: 1},
+			"edits":       {RequestsPerMinute: 1, Burst: 2},
+		}
+		if mutate != nil {
+			mutate(c)
+		}
+	})
+	swapGlobal(t, &endpointLimiter, &rateLimiter{buckets: make(map[string]*tokenBucket)})
+}
+
+func TestRateLimitsPerEndpoint(t *testing.T) {
+	useRateLimits(t, nil)
+
+	if got := rateLimited(t, "generations"); got != http.StatusOK {
+		t.Fatalf("generations 首个请求 status = %d", got)
+	}
+	if got := rateLimited(t, "generations"); got != http.StatusTooManyRequests {
+		t.Fatalf("generations 超出 burst 后 status = %d, want 429", got)
+	}
+	// edits 的额度不受 generations 影响
+	for i := range 2 {
+		if got := rateLimited(t, "edits"); got != http.StatusOK {
+			t.Fatalf("edits 第 %d 个请求 status = %d, want 200", i+1, got)
+		}
+	}
+	if got := rateLimited(t, "edits"); got != http.StatusTooManyRequests {
+		t.Errorf("edits 超出 burst 后 status = %d, want 429", got)
+	}
+	if got := rateLimited(t, "jobs"); got != http.StatusOK {
+		t.Errorf("未配置的接口 status = %d, want 200", got)
+	}
+}
+
+func TestRateLimitKeyedByKnownKeyOrIP(t *testing.T) {
+	useRateLimits(t, func(c *Config) {
+		c.RateLimitKeyHashes = []string{hashAPIKey("Bearer sk-known")}
+	})
+
+	if got := rateLimited(t, "generations", "Authorization", "Bearer sk-1"); got != http.StatusOK {
+		t.Fatalf("status = %d", got)
+	}
+	// 未登记的 Key 按 IP 计数，换 Key 不能绕过限流
+	if got := rateLimited(t, "generations", "Authorization", "Bearer sk-2"); got != http.StatusTooManyRequests {
+		t.Errorf("更换未登记的 Key 后 status = %d, want 429", got)
+	}
+	if got := rateLimited(t, "generations", "Authorization", "Bearer sk-known"); got != http.StatusOK {
+		t.Errorf("登记的 Key 有独立额度，status = %d, want 200", got)
+	}
+}
+
+func TestRateLimitUnknownEndpointRejected(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.RateLimits = map[string]RateLimitConfig{"variations": {RequestsPerMinute: 1}}
+	if err := cfg.prepare(); err == nil {
+		t.Error("不存在的接口应在加载配置时报错")
+	}
+}
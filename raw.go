@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// 原始图片模式：Accept 为 image/* 时直接返回图片字节。
+// 返回值 format 为需要转换的目标格式，空字符串表示保持原格式
+func rawImageFormat(cfg *Config, r *http.Request) (format string, ok bool) {
+	if !cfg.RawImageOutput {
+		return "", false
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || !strings.HasPrefix(mediaType, "image/") {
+			continue
+		}
+		if f, err := normalizeOutputFormat(strings.TrimPrefix(mediaType, "image/")); err == nil {
+			return f, true
+		}
+		return "", true
+	}
+	return "", false
+}
+
+// 校验 response_format 与存储、原始图片等输出模式是否互相矛盾
+func validateOutputModes(cfg *Config, r *http.Request, reqBody map[string]interface{}) error {
+	responseFormat, _ := reqBody["response_format"].(string)
+	if _, raw := rawImageFormat(cfg, r); raw {
+		if responseFormat != "" {
+			return fmt.Errorf("response_format %q cannot be combined with raw image output (Accept: %s)", responseFormat, r.Header.Get("Accept"))
+		}
+		if n := intParam(reqBody["n"], 1); n != 1 {
+			return fmt.Errorf("raw image output returns a single image, n must be 1 (got %d)", n)
+		}
+		if wantsMultipartRelated(r) || wantsMultipartMixed(r) {
+			return fmt.Errorf("raw image output cannot be combined with multipart responses")
+		}
+	}
+	if cfg.Storage.Enabled {
+		if _, ok := reqBody["output_format"]; ok && responseFormat == "b64_json" {
+			return fmt.Errorf("output_format applies to stored files and cannot be combined with response_format \"b64_json\"")
+		}
+	}
+	switch responseFormat {
+	case "", "url", "b64_json":
+	default:
+		if cfg.Storage.Enabled || cfg.RawImageOutput {
+			return fmt.Errorf("unsupported response_format %q, expected \"url\" or \"b64_json\"", responseFormat)
+		}
+	}
+	return nil
+}
+
+// 客户端是否被配置为始终使用 b64_json：API Key 哈希在 key_hashes 中，或 header 的取值在 values 中
+func forceB64(cfg ForceB64Config, r *http.Request, keyHash string) bool {
+	if keyHash != "" && slices.Contains(cfg.KeyHashes, keyHash) {
+		return true
+	}
+	if cfg.Header == "" {
+		return false
+	}
+	v := r.Header.Get(cfg.Header)
+	return v != "" && slices.Contains(cfg.Values, v)
+}
+
+// 以图片字节直接响应，仅返回第一张图片
+func writeRawImage(w http.ResponseWriter, r *http.Request, slots [][]imageSlot, format string) {
+	if len(slots) == 0 {
+		writeError(w, http.StatusBadGateway, "Upstream returned no images")
+		return
+	}
+	slot := slots[0][0]
+	if slot.err != "" {
+		writeError(w, http.StatusBadGateway, "Image download failed: "+slot.err)
+		return
+	}
+	var data []byte
+	var err error
+	withEncodeSlot(func() { data, err = convertImage(slot.data, format) })
+	if err != nil {
+		log.Printf("[ERROR] 格式转换失败: %v", err)
+		writeError(w, http.StatusInternalServerError, "Image conversion failed")
+		return
+	}
+	_, contentType := formatInfo(data)
+	if checkNotModified(w, r, contentETag(data)) {
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+	log.Printf("[SUCCESS] 以原始图片返回: %s, %d bytes", contentType, len(data))
+}
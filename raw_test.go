@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOutputModeConflictsRejected(t *testing.T) {
+	var called bool
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) { called = true })
+	cases := []struct {
+		name    string
+		storage bool
+		body    string
+		accept  string
+		want    string
+	}{
+		{"raw 与 b64_json", false, `{"response_format":"b64_json"}`, "image/png", "cannot be combined with raw image output"},
+		{"raw 与 url", false, `{"response_format":"url"}`, "image/png", "cannot be combined with raw image output"},
+		{"raw 与 n>1", false, `{"n":2}`, "image/png", "n must be 1"},
+		{"raw 与 multipart", false, `{}`, "image/png, multipart/mixed", "cannot be combined with multipart"},
+		{"存储与 b64_json 的 output_format", true, `{"response_format":"b64_json","output_format":"png"}`, "", "cannot be combined with response_format"},
+		{"存储与未知 response_format", true, `{"response_format":"binary"}`, "", "unsupported response_format"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := useConfig(t, func(cfg *Config) {
+				cfg.UpstreamURL = up.URL
+				cfg.RawImageOutput = true
+				cfg.Storage.Enabled = c.storage
+				cfg.Storage.Dir = t.TempDir()
+			})
+			if c.storage {
+				useStorage(t, cfg)
+			}
+			called = false
+			w := postGenerations(t, c.body, "Accept", c.accept)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), c.want) {
+				t.Fatalf("status = %d, body = %s, want 400 且包含 %q", w.Code, w.Body, c.want)
+			}
+			if called {
+				t.Error("矛盾的请求不应转发给上游")
+			}
+		})
+	}
+}
+
+func TestRawImageOutput(t *testing.T) {
+	png := testPNG(t, 2, 2)
+	img := newImageServer(t, png)
+	up := newUpstream(t, []string{img.URL + "/a.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.RawImageOutput = true
+	})
+	w := postGenerations(t, `{"prompt":"x"}`, "Accept", "image/png")
+	if w.Header().Get("Content-Type") != "image/png" || !bytes.Equal(w.Body.Bytes(), png) {
+		t.Fatalf("应直接返回图片字节，Content-Type = %q", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestForceB64ForFlaggedClients(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	var forwarded map[string]interface{}
+	up := newUpstream(t, []string{img.URL + "/a.png"}, &forwarded)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.ForceB64 = ForceB64Config{
+			KeyHashes: []string{hashAPIKey("Bearer sk-legacy")},
+			Header:    "X-Client-Id",
+			Values:    []string{"legacy-app"},
+		}
+	})
+
+	flagged := [][]string{
+		{"Authorization", "Bearer sk-legacy"},
+		{"X-Client-Id", "legacy-app"},
+	}
+	for _, hdr := range flagged {
+		for _, body := range []string{`{"prompt":"x"}`, `{"prompt":"x","response_format":"url"}`} {
+			resp := decodeB64Response(t, postGenerations(t, body, hdr...))
+			if len(resp.Data) != 1 || resp.Data[0].B64JSON == "" {
+				t.Errorf("%s %s: 应强制返回 b64_json，data = %+v", hdr[0], body, resp.Data)
+			}
+		}
+	}
+
+	// 其他客户端仍为 URL 模式
+	w := postGenerations(t, `{"prompt":"x"}`, "Authorization", "Bearer sk-other", "X-Client-Id", "new-app")
+	if !strings.Contains(w.Body.String(), img.URL+"/a.png") || strings.Contains(w.Body.String(), "b64_json") {
+		t.Errorf("未标记的客户端应得到 URL 模式响应: %s", w.Body)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var errUpstreamRedirect = errors.New("upstream redirected the generation request")
+
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// 生成请求使用的客户端：不自动跟随重定向，由 followUpstreamRedirects 处理。
+// 默认客户端遇到 301/302/303 会把 POST 改为不带请求体的 GET
+func noRedirectClient(client *http.Client) *http.Client {
+	c := *client
+	c.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	return &c
+}
+
+// 按 upstream_redirects 处理上游对生成请求的 3xx 响应：follow 以原方法与请求体重新提交到 Location，
+// 最多 max_redirects 次；error 直接视为上游错误。跨主机时不转发 Authorization
+func followUpstreamRedirects(cfg UpstreamRedirectConfig, client *http.Client, req *http.Request, body []byte, resp *http.Response, record func(*http.Response, error, time.Duration)) (*http.Response, error) {
+	for hops := 0; isRedirect(resp.StatusCode); hops++ {
+		loc := resp.Header.Get("Location")
+		resp.Body.Close()
+		if cfg.Policy == "error" || loc == "" {
+			return nil, fmt.Errorf("%w: HTTP %d to %q", errUpstreamRedirect, resp.StatusCode, sanitizeURL(loc, nil))
+		}
+		if hops >= cfg.MaxRedirects {
+			return nil, fmt.Errorf("%w: stopped after %d redirects", errUpstreamRedirect, hops)
+		}
+		target, err := req.URL.Parse(loc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid Location %q", errUpstreamRedirect, sanitizeURL(loc, nil))
+		}
+		next, err := http.NewRequestWithContext(req.Context(), req.Method, target.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		next.Header = req.Header.Clone()
+		if target.Host != req.URL.Host {
+			next.Header.Del("Authorization")
+		}
+		log.Printf("[REDIRECT] 上游返回 %d，重新提交请求到 %s", resp.StatusCode, sanitizeURL(target.String(), nil))
+		req = next
+		start := time.Now()
+		resp, err = client.Do(req)
+		record(resp, err, time.Since(start))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUpstreamRedirectResubmitsBody(t *testing.T) {
+	var mu sync.Mutex
+	var method, body string
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusTemporaryRedirect)
+		case "/moved":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusTemporaryRedirect)
+		default:
+			b, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			method, body = r.Method, string(b)
+			mu.Unlock()
+			w.Write([]byte(`{"images":[{"url":"https://cdn.example/a.png"}]}`))
+		}
+	})
+
+	for _, path := range []string{"/old", "/moved"} {
+		useConfig(t, func(c *Config) { c.UpstreamURL = up.URL + path })
+		mu.Lock()
+		method, body = "", ""
+		mu.Unlock()
+		if w := postGenerations(t, `{"prompt":"a red fox"}`); w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", path, w.Code, w.Body)
+		}
+		mu.Lock()
+		if method != http.MethodPost || !strings.Contains(body, `"prompt":"a red fox"`) {
+			t.Errorf("%s: 跳转目标收到 %s %q，want 带原请求体的 POST", path, method, body)
+		}
+		mu.Unlock()
+	}
+
+	// 超过 max_redirects 或 policy=error 时返回 502
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL + "/loop" })
+	if w := postGenerations(t, `{"prompt":"x"}`); w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), "Upstream redirected the request") {
+		t.Errorf("循环跳转 status = %d, body = %s", w.Code, w.Body)
+	}
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL + "/old"
+		c.UpstreamRedirects.Policy = "error"
+	})
+	if w := postGenerations(t, `{"prompt":"x"}`); w.Code != http.StatusBadGateway {
+		t.Errorf("policy=error 时 status = %d, want 502", w.Code)
+	}
+}
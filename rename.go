@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// 按 output_field_renames 改写响应 JSON 中各层对象的字段名，兼容期望 b64、image_url 等旧字段名的客户端；
+// 未配置时原样返回
+func renameOutputFields(renames map[string]string, data []byte) []byte {
+	if len(renames) == 0 {
+		return data
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return data
+	}
+	out, err := json.Marshal(renameKeys(renames, v))
+	if err != nil {
+		return data
+	}
+	if bytes.HasSuffix(data, []byte("\n")) {
+		out = append(out, '\n')
+	}
+	return out
+}
+
+// 字段改名后的名称，未配置时为原名
+func outputFieldName(renames map[string]string, name string) string {
+	if to, ok := renames[name]; ok {
+		return to
+	}
+	return name
+}
+
+func renameKeys(renames map[string]string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[outputFieldName(renames, k)] = renameKeys(renames, val)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = renameKeys(renames, v[i])
+		}
+	}
+	return v
+}
+
+// 序列化成功响应并套用字段改名，末尾带换行
+func encodeResponse(renames map[string]string, v interface{}) []byte {
+	out, _ := json.Marshal(v)
+	return renameOutputFields(renames, append(out, '\n'))
+}
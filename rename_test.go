@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestOutputFieldRenames(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	up := newUpstream(t, []string{img.URL + "/a.png"}, nil)
+	renames := map[string]string{"b64_json": "b64", "url": "image_url"}
+
+	for _, c := range []struct {
+		body      string
+		container string
+		field     string
+	}{
+		{`{"prompt":"x","response_format":"b64_json"}`, "data", "b64"},
+		{`{"prompt":"x"}`, "images", "image_url"},
+	} {
+		useConfig(t, func(cfg *Config) {
+			cfg.UpstreamURL = up.URL
+			cfg.OutputFieldRenames = renames
+		})
+		w := postGenerations(t, c.body)
+		var resp map[string]json.RawMessage
+		var items []map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("响应不是有效 JSON: %s", w.Body)
+		}
+		json.Unmarshal(resp[c.container], &items)
+		if len(items) != 1 || items[0][c.field] == nil || items[0][c.field] == "" {
+			t.Errorf("%s: 应带改名后的字段 %s: %s", c.body, c.field, w.Body)
+		}
+		if strings.Contains(w.Body.String(), `"b64_json"`) || strings.Contains(w.Body.String(), `"url"`) {
+			t.Errorf("%s: 不应再出现原字段名: %s", c.body, w.Body)
+		}
+	}
+
+	// 默认不改写，错误响应不受影响
+	useConfig(t, func(cfg *Config) { cfg.UpstreamURL = up.URL })
+	if resp := decodeB64Response(t, postGenerations(t, `{"prompt":"x","response_format":"b64_json"}`)); len(resp.Data) != 1 || resp.Data[0].B64JSON == "" {
+		t.Errorf("未配置时应保持标准字段名: %+v", resp.Data)
+	}
+	useConfig(t, func(cfg *Config) {
+		cfg.UpstreamURL = up.URL
+		cfg.OutputFieldRenames = map[string]string{"error": "err"}
+	})
+	if w := postGenerations(t, `{"prompt":"x","n":0}`); !strings.Contains(w.Body.String(), `"error"`) {
+		t.Errorf("错误响应不应改名: %s", w.Body)
+	}
+}
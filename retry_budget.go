@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+)
+
+// 单个请求全部重试机制（下载重试、Key 轮换、对冲请求）共用的重试次数预算
+type retryBudget struct {
+	remaining atomic.Int64
+}
+
+type retryBudgetKey struct{}
+
+// 为请求设置 retry_budget，limit 不大于 0 时不限制
+func withRetryBudget(ctx context.Context, limit int) context.Context {
+	if limit <= 0 {
+		return ctx
+	}
+	b := &retryBudget{}
+	b.remaining.Store(int64(limit))
+	return context.WithValue(ctx, retryBudgetKey{}, b)
+}
+
+// 在重试前调用：预算有余时扣减一次并返回 true；未设置预算时总是允许
+func takeRetry(ctx context.Context, kind string) bool {
+	b, ok := ctx.Value(retryBudgetKey{}).(*retryBudget)
+	if !ok {
+		return true
+	}
+	if b.remaining.Add(-1) < 0 {
+		log.Printf("[RETRY] 请求重试预算已用尽，跳过 %s 重试", kind)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryBudgetSharedAcrossMechanisms(t *testing.T) {
+	var downloads, upstreamCalls atomic.Int32
+	img := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		if r.Header.Get("Authorization") != "Bearer key-3" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprintf(w, `{"images":[{"url":"%[1]s/a.png"},{"url":"%[1]s/b.png"}]}`, img.URL)
+	})
+	prev := secrets.Load()
+	t.Cleanup(func() { secrets.Store(prev) })
+
+	for _, budget := range []int{0, 3} {
+		useConfig(t, func(c *Config) {
+			c.UpstreamURL = up.URL
+			c.KeyRotation.Enabled = true
+			c.DownloadRetries = 2
+			c.RetryBudget = budget
+		})
+		swapGlobal(t, &cooldowns, &keyCooldowns{until: make(map[string]time.Time)})
+		secrets.Store(&upstreamSecrets{APIKey: "key-1", APIKeys: []string{"key-2", "key-3"}})
+		downloads.Store(0)
+		upstreamCalls.Store(0)
+
+		postGenerations(t, `{"prompt":"x","n":2,"response_format":"b64_json"}`)
+
+		// 首次上游调用与每张图片的首次下载不计入预算
+		retries := int(upstreamCalls.Load()-1) + int(downloads.Load()-2)
+		switch budget {
+		case 0:
+			// 不限制时：换 Key 重试 2 次，两张图片各重试 2 次
+			if retries != 6 {
+				t.Errorf("未设置预算时重试 %d 次, want 6", retries)
+			}
+		default:
+			// 预算用尽后各机制都不再重试，合计恰好为预算
+			if retries != budget {
+				t.Errorf("重试合计 %d 次（上游 %d 次、下载 %d 次），want 预算 %d", retries, upstreamCalls.Load(), downloads.Load(), budget)
+			}
+			if upstreamCalls.Load() != 3 {
+				t.Errorf("换 Key 重试应先用掉预算，上游调用 %d 次, want 3", upstreamCalls.Load())
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// 写入测试用的 JSON Schema 文件并返回路径
+func writeSchema(t *testing.T, schema string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(schema), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testRequestSchema = `{
+	"type": "object",
+	"required": ["prompt"],
+	"properties": {
+		"prompt": {"type": "string", "minLength": 1},
+		"n": {"type": "integer", "maximum": 4}
+	}
+}`
+
+func TestRequestSchemaViolations(t *testing.T) {
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.RequestSchema = writeSchema(t, testRequestSchema)
+	})
+
+	w := postGenerations(t, `{"prompt":"","n":8}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+	var resp struct {
+		Error      string   `json:"error"`
+		Violations []string `json:"violations"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"/n: must be <= 4 but found 8",
+		"/prompt: length must be >= 1, but got 0",
+	}
+	if !slices.Equal(resp.Violations, want) {
+		t.Errorf("violations = %q, want %q", resp.Violations, want)
+	}
+
+	if w := postGenerations(t, `{"prompt":"x","n":2}`); w.Code != http.StatusOK {
+		t.Errorf("符合 Schema 的请求 status = %d, want 200", w.Code)
+	}
+}
+
+func TestRequestSchemaInvalidFile(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.RequestSchema = writeSchema(t, `{"type": 1}`)
+	if err := cfg.prepare(); err == nil {
+		t.Error("无效的 Schema 应在加载配置时报错")
+	}
+}
+
+func TestRequestSchemaAfterNumericCoercion(t *testing.T) {
+	var forwarded map[string]interface{}
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, &forwarded)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.RequestSchema = writeSchema(t, `{
+			"type": "object",
+			"properties": {
+				"n": {"type": "integer", "maximum": 4},
+				"seed": {"type": "integer"}
+			}
+		}`)
+		c.NumericParams = []string{"n"}
+	})
+
+	// 转换后的数字通过 schema 校验
+	if w := postGenerations(t, `{"prompt":"x","n":"2"}`); w.Code != http.StatusOK {
+		t.Fatalf(`"n":"2" status = %d, body = %s`, w.Code, w.Body)
+	}
+	if forwarded["n"] != float64(2) {
+		t.Errorf("转发的 n = %#v, want 数字 2", forwarded["n"])
+	}
+	if w := postGenerations(t, `{"prompt":"x","n":"8"}`); w.Code != http.StatusBadRequest {
+		t.Errorf(`"n":"8" 转换后仍应按 maximum 校验，status = %d`, w.Code)
+	}
+
+	// 无法转换的字符串在 schema 之前即被拒绝
+	w := postGenerations(t, `{"prompt":"x","n":"two"}`)
+	if e := decodeOpenAIError(t, w); w.Code != http.StatusBadRequest || e.Param != "n" {
+		t.Errorf(`"n":"two" status = %d, error = %+v, want 400 param n`, w.Code, e)
+	}
+	// 不在 numeric_params 中的字段不转换，字符串仍被 schema 拒绝
+	w = postGenerations(t, `{"prompt":"x","seed":"abc"}`)
+	var resp struct {
+		Violations []string `json:"violations"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusBadRequest || len(resp.Violations) != 1 || resp.Violations[0] != "/seed: expected integer, but got string" {
+		t.Errorf(`"seed":"abc" status = %d, violations = %q`, w.Code, resp.Violations)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// 上游凭据，来自可热更新的密钥文件
+type upstreamSecrets struct {
+	APIKey      string `json:"api_key"`
+	UpstreamURL string `json:"upstream_url"`
+	// 额外的上游 Key，开启 key_rotation 时与 api_key 组成 Key 池轮换
+	APIKeys []string `json:"api_keys"`
+	// 租户 ID（tenant.header 的取值）→ 该租户自己的上游 Key
+	TenantKeys map[string]string `json:"tenant_keys"`
+	// 租户 ID → 允许以该租户身份请求的客户端 API Key 哈希（与审计日志的 key_hash 相同）
+	TenantClients map[string][]string `json:"tenant_clients"`
+}
+
+var secrets atomic.Pointer[upstreamSecrets]
+
+// 密钥文件可以是 JSON（api_key / upstream_url），也可以只写一行 Key
+func loadSecretsFile(path string) (*upstreamSecrets, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("secrets file is empty")
+	}
+	if data[0] != '{' {
+		return &upstreamSecrets{APIKey: string(data)}, nil
+	}
+	var s upstreamSecrets
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse secrets file: %w", err)
+	}
+	if s.APIKey == "" && len(s.APIKeys) == 0 && len(s.TenantKeys) == 0 {
+		return nil, errors.New("secrets file has no api_key, api_keys or tenant_keys")
+	}
+	return &s, nil
+}
+
+// 加载密钥文件并监听变化；读取失败时保留上一次的有效值
+func watchSecretsFile(path string) error {
+	s, err := loadSecretsFile(path)
+	if err != nil {
+		return err
+	}
+	secrets.Store(s)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// 监听所在目录，兼容编辑器与 Kubernetes Secret 的原子替换
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+					continue
+				}
+				reloadSecrets(path)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("[SECRETS] 监听密钥文件出错: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+func reloadSecrets(path string) {
+	s, err := loadSecretsFile(path)
+	if err != nil {
+		log.Printf("[SECRETS] 重新读取密钥文件失败，继续使用原值: %v", err)
+		return
+	}
+	if old := secrets.Load(); old != nil && old.APIKey == s.APIKey && old.UpstreamURL == s.UpstreamURL && slices.Equal(old.APIKeys, s.APIKeys) &&
+		maps.Equal(old.TenantKeys, s.TenantKeys) && maps.EqualFunc(old.TenantClients, s.TenantClients, slices.Equal) {
+		return
+	}
+	secrets.Store(s)
+	log.Printf("[SECRETS] 密钥已更新")
+}
+
+var errTenantForbidden = errors.New("API key is not allowed to act as this tenant")
+
+// 租户请求头由客户端控制：声明的租户在 tenant_keys 中时，客户端的 API Key 须登记在该租户的
+// tenant_clients 中，否则任意客户端改写请求头即可使用其他租户的上游 Key
+func checkTenantClient(cfg *Config, clientHeader http.Header) error {
+	s := secrets.Load()
+	if s == nil {
+		return nil
+	}
+	if _, ok := tenantUpstreamKey(s, cfg, clientHeader); ok {
+		return nil
+	}
+	if _, ok := s.TenantKeys[clientHeader.Get(cfg.Tenant.Header)]; ok {
+		return errTenantForbidden
+	}
+	return nil
+}
+
+// 请求所属租户的上游 Key，只有客户端 API Key 与租户绑定时才返回
+func tenantUpstreamKey(s *upstreamSecrets, cfg *Config, clientHeader http.Header) (string, bool) {
+	tenant := clientHeader.Get(cfg.Tenant.Header)
+	key, ok := s.TenantKeys[tenant]
+	if !ok || key == "" {
+		return "", false
+	}
+	h := hashAPIKey(clientHeader.Get("Authorization"))
+	return key, h != "" && slices.Contains(s.TenantClients[tenant], h)
+}
+
+// 转发上游时使用的 Authorization：配置了密钥文件时注入其中的 Key（客户端与 tenant_keys 中的租户绑定时用该租户的 Key），
+// 否则沿用客户端的
+func upstreamAuthorization(cfg *Config, clientHeader http.Header) string {
+	s := secrets.Load()
+	if s == nil {
+		return clientHeader.Get("Authorization")
+	}
+	if key, ok := tenantUpstreamKey(s, cfg, clientHeader); ok {
+		return "Bearer " + key
+	}
+	if pool := s.keyPool(); len(pool) > 0 {
+		return "Bearer " + pool[0]
+	}
+	return clientHeader.Get("Authorization")
+}
+
+// 上游地址：密钥文件中的 upstream_url 优先
+func upstreamURL(cfg *Config) string {
+	if s := secrets.Load(); s != nil && s.UpstreamURL != "" {
+		return s.UpstreamURL
+	}
+	return cfg.UpstreamURL
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSecretsFileHotReload(t *testing.T) {
+	var mu sync.Mutex
+	var lastAuth string
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		lastAuth = r.Header.Get("Authorization")
+		mu.Unlock()
+		w.Write([]byte(`{"images":[{"url":"https://cdn.example/a.png"}]}`))
+	})
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	prev := secrets.Load()
+	t.Cleanup(func() { secrets.Store(prev) })
+
+	path := filepath.Join(t.TempDir(), "upstream.key")
+	if err := os.WriteFile(path, []byte("key-1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := watchSecretsFile(path); err != nil {
+		t.Fatal(err)
+	}
+	usedKey := func() string {
+		postGenerations(t, `{"prompt":"x"}`, "Authorization", "Bearer client-key")
+		mu.Lock()
+		defer mu.Unlock()
+		return lastAuth
+	}
+	if got := usedKey(); got != "Bearer key-1" {
+		t.Fatalf("Authorization = %q, want 密钥文件中的 key-1", got)
+	}
+
+	// 以原子替换的方式轮换密钥
+	tmp := path + ".tmp"
+	os.WriteFile(tmp, []byte(`{"api_key":"key-2"}`), 0o600)
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	waitSecret(t, "key-2")
+	if got := usedKey(); got != "Bearer key-2" {
+		t.Fatalf("轮换后 Authorization = %q, want key-2", got)
+	}
+
+	// 读取失败（空文件）时保留上一次的有效值
+	os.WriteFile(path, nil, 0o600)
+	time.Sleep(100 * time.Millisecond)
+	if got := usedKey(); got != "Bearer key-2" {
+		t.Fatalf("无效密钥文件不应替换原值，Authorization = %q", got)
+	}
+}
+
+func waitSecret(t *testing.T, key string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s := secrets.Load(); s == nil || s.APIKey != key; s = secrets.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待密钥更新为 %s 超时", key)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTenantUpstreamKeys(t *testing.T) {
+	var mu sync.Mutex
+	auths := map[string]string{}
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auths[r.Header.Get("X-Tenant-Id")] = r.Header.Get("Authorization")
+		mu.Unlock()
+		w.Write([]byte(`{"images":[{"url":"https://cdn.example/a.png"}]}`))
+	})
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	prev := secrets.Load()
+	t.Cleanup(func() { secrets.Store(prev) })
+
+	path := filepath.Join(t.TempDir(), "upstream.json")
+	data := fmt.Sprintf(`{"api_key":"shared","tenant_keys":{"tenant-a":"key-a","tenant-b":"key-b"},"tenant_clients":{"tenant-a":[%q],"tenant-b":[%q]}}`,
+		hashAPIKey("Bearer client-a"), hashAPIKey("Bearer client-b"))
+	os.WriteFile(path, []byte(data), 0o600)
+	s, err := loadSecretsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets.Store(s)
+
+	want := map[string]string{
+		"tenant-a": "Bearer key-a",
+		"tenant-b": "Bearer key-b",
+		"tenant-c": "Bearer shared",
+	}
+	clients := map[string]string{"tenant-a": "client-a", "tenant-b": "client-b", "tenant-c": "client-c"}
+	for tenant := range want {
+		if w := postGenerations(t, `{"prompt":"x"}`, "X-Tenant-Id", tenant, "Authorization", "Bearer "+clients[tenant]); w.Code != http.StatusOK {
+			t.Errorf("租户 %s status = %d", tenant, w.Code)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for tenant, auth := range want {
+		if auths[tenant] != auth {
+			t.Errorf("租户 %s 的 Authorization = %q, want %q", tenant, auths[tenant], auth)
+		}
+	}
+}
+
+func TestSpoofedTenantHeaderRejected(t *testing.T) {
+	var calls atomic.Int32
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"images":[{"url":"https://cdn.example/a.png"}]}`))
+	})
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	prev := secrets.Load()
+	t.Cleanup(func() { secrets.Store(prev) })
+	secrets.Store(&upstreamSecrets{
+		APIKey:        "shared",
+		TenantKeys:    map[string]string{"tenant-a": "key-a"},
+		TenantClients: map[string][]string{"tenant-a": {hashAPIKey("Bearer client-a")}},
+	})
+
+	// 未与 tenant-a 绑定的 Key 改写租户请求头，不能使用 tenant-a 的上游 Key
+	for _, hdr := range [][]string{
+		{"X-Tenant-Id", "tenant-a", "Authorization", "Bearer client-b"},
+		{"X-Tenant-Id", "tenant-a"},
+	} {
+		w := postGenerations(t, `{"prompt":"x"}`, hdr...)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%v: status = %d, want 403", hdr, w.Code)
+		}
+	}
+	if calls.Load() != 0 {
+		t.Errorf("冒用租户的请求不应转发上游，实际 %d 次", calls.Load())
+	}
+	if got := upstreamAuthorization(currentConfig(), http.Header{"X-Tenant-Id": {"tenant-a"}, "Authorization": {"Bearer client-b"}}); got != "Bearer shared" {
+		t.Errorf("未绑定的 Key 不应拿到租户 Key，Authorization = %q", got)
+	}
+}
+
+func TestKeyRotationOn429(t *testing.T) {
+	var mu sync.Mutex
+	var auths []string
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auths = append(auths, r.Header.Get("Authorization"))
+		mu.Unlock()
+		if r.Header.Get("Authorization") == "Bearer key-1" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"message":"quota exceeded"}`))
+			return
+		}
+		w.Write([]byte(`{"images":[{"url":"https://cdn.example/a.png"}]}`))
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.KeyRotation.Enabled = true
+	})
+	swapGlobal(t, &cooldowns, &keyCooldowns{until: make(map[string]time.Time)})
+	prev := secrets.Load()
+	t.Cleanup(func() { secrets.Store(prev) })
+	secrets.Store(&upstreamSecrets{APIKey: "key-1", APIKeys: []string{"key-2"}})
+
+	if w := postGenerations(t, `{"prompt":"x"}`); w.Code != http.StatusOK {
+		t.Fatalf("换用第二个 Key 后应成功，status = %d, body = %s", w.Code, w.Body)
+	}
+	// 冷却中的 key-1 排到最后，下一个请求直接使用 key-2
+	if w := postGenerations(t, `{"prompt":"x"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"Bearer key-1", "Bearer key-2", "Bearer key-2"}
+	if !slices.Equal(auths, want) {
+		t.Errorf("上游收到的 Authorization = %q, want %q", auths, want)
+	}
+}
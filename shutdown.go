@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// 启动 HTTP 服务，收到 SIGINT/SIGTERM 后优雅关闭：不再接受新连接与异步任务，
+// 在 shutdown_grace_period 内等待处理中（含排队）的请求与后台任务完成，超时仍未完成的任务记录到日志
+func serve(addr string) error {
+	srv := &http.Server{Addr: addr}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.ListenAndServe() }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	grace := time.Duration(currentConfig().ShutdownGracePeriod)
+	log.Printf("[SERVER] 收到退出信号，等待处理中的请求与任务完成（最长 %v）", grace)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	// 先关闭任务入口，使关闭期间到达的异步请求也被拒绝
+	drained := make(chan []string, 1)
+	go func() { drained <- jobs.drain(shutdownCtx) }()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("[WARN] 仍有请求未在宽限期内完成: %v", err)
+	}
+	if abandoned := <-drained; len(abandoned) > 0 {
+		log.Printf("[WARN] 宽限期已过，放弃 %d 个未完成的异步任务: %v", len(abandoned), abandoned)
+	} else {
+		log.Printf("[SERVER] 已优雅关闭")
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
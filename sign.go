@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 支持的签名算法
+var signingAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha1":   sha1.New,
+	"hmac-sha256": sha256.New,
+	"hmac-sha512": sha512.New,
+}
+
+// 计算 HMAC(secret, timestamp + "." + body) 的十六进制结果
+func signBody(algorithm, secret, timestamp string, body []byte) string {
+	mac := hmac.New(signingAlgorithms[algorithm], []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 为转发给上游的请求添加 X-Timestamp 与 X-Signature
+func signUpstreamRequest(cfg SigningConfig, req *http.Request, body []byte) {
+	if !cfg.Enabled {
+		return
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Timestamp", timestamp)
+	req.Header.Set("X-Signature", signBody(cfg.Algorithm, cfg.Secret, timestamp, body))
+}
+
+var (
+	errSignatureMissing = errors.New("missing X-Timestamp or X-Signature")
+	errSignatureSkew    = errors.New("request timestamp outside allowed clock skew")
+	errSignatureInvalid = errors.New("invalid request signature")
+	errNonceMissing     = errors.New("missing X-Nonce")
+	errNonceReplayed    = errors.New("nonce has already been used")
+)
+
+// 已使用的 nonce 及其过期时间，用于拒绝重放的签名请求
+type nonceCache struct {
+	mu         sync.Mutex
+	seen       map[string]time.Time
+	lastPruned time.Time
+}
+
+var nonces = &nonceCache{seen: make(map[string]time.Time)}
+
+// 记录 nonce；窗口内已出现过时返回 false
+func (c *nonceCache) use(nonce string, ttl time.Duration, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastPruned) > time.Minute {
+		for n, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, n)
+			}
+		}
+		c.lastPruned = now
+	}
+	if exp, ok := c.seen[nonce]; ok && now.Before(exp) {
+		return false
+	}
+	c.seen[nonce] = now.Add(ttl)
+	return true
+}
+
+// 校验客户端请求的 X-Timestamp 与 X-Signature，签名方式与上游签名相同；
+// 时间戳与服务器时间相差不超过 clock_skew_tolerance（前后均可）
+func verifyRequestSignature(cfg ClientSigningConfig, header http.Header, body []byte, now time.Time) error {
+	timestamp, signature := header.Get("X-Timestamp"), header.Get("X-Signature")
+	if timestamp == "" || signature == "" {
+		return errSignatureMissing
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errSignatureInvalid
+	}
+	skew := now.Sub(time.Unix(ts, 0)).Abs()
+	if skew > time.Duration(cfg.ClockSkewTolerance) {
+		return fmt.Errorf("%w: off by %v", errSignatureSkew, skew.Truncate(time.Second))
+	}
+	// 开启重放保护时 nonce 参与签名：HMAC(secret, timestamp + "." + nonce + "." + body)
+	signed, nonce := timestamp, header.Get("X-Nonce")
+	if cfg.RequireNonce {
+		if nonce == "" {
+			return errNonceMissing
+		}
+		signed += "." + nonce
+	}
+	expected := signBody(cfg.Algorithm, cfg.Secret, signed, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return errSignatureInvalid
+	}
+	if cfg.RequireNonce && !nonces.use(nonce, time.Duration(cfg.NonceTTL), now) {
+		return errNonceReplayed
+	}
+	return nil
+}
+
+// 开启 client_signing 时要求请求带有效签名，校验失败返回 401
+func withSignatureCheck(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := currentConfig().ClientSigning
+		if !cfg.Enabled {
+			next(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+		if err := verifyRequestSignature(cfg, r.Header, body, time.Now()); err != nil {
+			log.Printf("[REJECT] 请求签名校验失败: %v", err)
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
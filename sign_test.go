@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignBodyKnownVector(t *testing.T) {
+	body := []byte(`{"prompt":"x"}`)
+	cases := map[string]string{
+		"hmac-sha256": "ea603520ad1b45eb0a73a1d647a31f717b2fedff9b4a50997cc5ad1f434ee34f",
+		"hmac-sha512": "e9c422a3ae6b6de61788522196d36a0980debcadf8cf161d1c565cc737cb03efee27e06440d90da1f588640c434bb343616fa4d01e1ae0f86bfdc0c76c34c278",
+	}
+	for algorithm, want := range cases {
+		if got := signBody(algorithm, "secret", "1700000000", body); got != want {
+			t.Errorf("%s = %s, want %s", algorithm, got, want)
+		}
+	}
+}
+
+func TestUpstreamRequestSigned(t *testing.T) {
+	var header http.Header
+	var body []byte
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{"images":[{"url":"https://cdn.example/a.png"}]}`))
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Signing.Enabled = true
+		c.Signing.Secret = "secret"
+	})
+
+	postGenerations(t, `{"prompt":"x"}`)
+	ts := header.Get("X-Timestamp")
+	if ts == "" {
+		t.Fatal("缺少 X-Timestamp")
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	if got, want := header.Get("X-Signature"), hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("X-Signature = %s, want %s（按实际转发的请求体计算）", got, want)
+	}
+}
+
+func TestSigningConfigValidated(t *testing.T) {
+	for name, sc := range map[string]SigningConfig{
+		"未知算法": {Enabled: true, Secret: "s", Algorithm: "md5"},
+		"缺少密钥": {Enabled: true, Algorithm: "hmac-sha256"},
+	} {
+		cfg := defaultConfig()
+		cfg.Signing = sc
+		if err := cfg.prepare(); err == nil {
+			t.Errorf("%s: prepare 应返回错误", name)
+		}
+	}
+}
+
+// 按客户端签名规则给请求头加上时间戳与签名
+func signedHeader(cfg ClientSigningConfig, ts time.Time, body []byte) http.Header {
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	h := http.Header{}
+	h.Set("X-Timestamp", timestamp)
+	h.Set("X-Signature", signBody(cfg.Algorithm, cfg.Secret, timestamp, body))
+	return h
+}
+
+func TestClientSignatureClockSkew(t *testing.T) {
+	cfg := ClientSigningConfig{Enabled: true, Secret: "secret", Algorithm: "hmac-sha256", ClockSkewTolerance: Duration(5 * time.Minute)}
+	body := []byte(`{"prompt":"x"}`)
+	now := time.Unix(1700000000, 0)
+
+	for _, offset := range []time.Duration{0, -4 * time.Minute, 4 * time.Minute, 5 * time.Minute} {
+		if err := verifyRequestSignature(cfg, signedHeader(cfg, now.Add(offset), body), body, now); err != nil {
+			t.Errorf("偏差 %v 在容忍范围内，应通过: %v", offset, err)
+		}
+	}
+	for _, offset := range []time.Duration{-6 * time.Minute, 6 * time.Minute} {
+		if err := verifyRequestSignature(cfg, signedHeader(cfg, now.Add(offset), body), body, now); !errors.Is(err, errSignatureSkew) {
+			t.Errorf("偏差 %v 超出容忍范围，err = %v, want errSignatureSkew", offset, err)
+		}
+	}
+
+	h := signedHeader(cfg, now, body)
+	if err := verifyRequestSignature(cfg, h, []byte(`{"prompt":"y"}`), now); !errors.Is(err, errSignatureInvalid) {
+		t.Errorf("请求体被篡改时 err = %v, want errSignatureInvalid", err)
+	}
+}
+
+func TestClientSignatureCheckedBeforeHandler(t *testing.T) {
+	useConfig(t, func(c *Config) {
+		c.ClientSigning = ClientSigningConfig{Enabled: true, Secret: "secret", Algorithm: "hmac-sha256", ClockSkewTolerance: Duration(time.Minute)}
+	})
+	cfg := currentConfig().ClientSigning
+	body := `{"prompt":"x"}`
+	var got string
+	h := withSignatureCheck(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+	})
+
+	hdr := signedHeader(cfg, time.Now(), []byte(body))
+	if w := serveGenerations(t, h, body, "X-Timestamp", hdr.Get("X-Timestamp"), "X-Signature", hdr.Get("X-Signature")); w.Code != http.StatusOK || got != body {
+		t.Errorf("有效签名 status = %d, 处理器读到的请求体 = %q", w.Code, got)
+	}
+	hdr = signedHeader(cfg, time.Now().Add(-2*time.Minute), []byte(body))
+	if w := serveGenerations(t, h, body, "X-Timestamp", hdr.Get("X-Timestamp"), "X-Signature", hdr.Get("X-Signature")); w.Code != http.StatusUnauthorized {
+		t.Errorf("时间戳超出容忍范围 status = %d, want 401", w.Code)
+	}
+	if w := serveGenerations(t, h, body); w.Code != http.StatusUnauthorized {
+		t.Errorf("缺少签名 status = %d, want 401", w.Code)
+	}
+}
+
+func TestNonceReplayRejected(t *testing.T) {
+	swapGlobal(t, &nonces, &nonceCache{seen: make(map[string]time.Time)})
+	cfg := ClientSigningConfig{Enabled: true, Secret: "secret", Algorithm: "hmac-sha256",
+		ClockSkewTolerance: Duration(time.Minute), RequireNonce: true, NonceTTL: Duration(2 * time.Minute)}
+	body := []byte(`{"prompt":"x"}`)
+	now := time.Unix(1700000000, 0)
+	signed := func(nonce string) http.Header {
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		h := http.Header{}
+		h.Set("X-Timestamp", timestamp)
+		h.Set("X-Nonce", nonce)
+		h.Set("X-Signature", signBody(cfg.Algorithm, cfg.Secret, timestamp+"."+nonce, body))
+		return h
+	}
+
+	if err := verifyRequestSignature(cfg, signed("n-1"), body, now); err != nil {
+		t.Fatalf("首次使用的 nonce 应通过: %v", err)
+	}
+	if err := verifyRequestSignature(cfg, signed("n-1"), body, now.Add(30*time.Second)); !errors.Is(err, errNonceReplayed) {
+		t.Errorf("窗口内重放 err = %v, want errNonceReplayed", err)
+	}
+	if err := verifyRequestSignature(cfg, signed("n-2"), body, now.Add(30*time.Second)); err != nil {
+		t.Errorf("新的 nonce 应通过: %v", err)
+	}
+	h := signed("n-3")
+	h.Del("X-Nonce")
+	if err := verifyRequestSignature(cfg, h, body, now); !errors.Is(err, errNonceMissing) {
+		t.Errorf("缺少 nonce 时 err = %v, want errNonceMissing", err)
+	}
+	// nonce 参与签名，替换 nonce 会使签名失效
+	h = signed("n-4")
+	h.Set("X-Nonce", "n-5")
+	if err := verifyRequestSignature(cfg, h, body, now); !errors.Is(err, errSignatureInvalid) {
+		t.Errorf("替换 nonce 后 err = %v, want errSignatureInvalid", err)
+	}
+}
+
+func TestNonceTTLCoversClockSkew(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.ClientSigning = ClientSigningConfig{Enabled: true, Secret: "s", Algorithm: "hmac-sha256",
+		ClockSkewTolerance: Duration(5 * time.Minute), RequireNonce: true, NonceTTL: Duration(9 * time.Minute)}
+	if err := cfg.prepare(); err == nil {
+		t.Error("nonce_ttl 小于两倍 clock_skew_tolerance 时应报错")
+	}
+	cfg.ClientSigning.NonceTTL = Duration(10 * time.Minute)
+	if err := cfg.prepare(); err != nil {
+		t.Errorf("nonce_ttl 等于两倍 clock_skew_tolerance 时应通过: %v", err)
+	}
+	cfg.ClientSigning.RequireNonce, cfg.ClientSigning.NonceTTL = false, 0
+	if err := cfg.prepare(); err != nil {
+		t.Errorf("未开启 require_nonce 时不校验 nonce_ttl: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+// 正在进行的下载，供其他请求中相同 URL 的下载等待复用
+type downloadCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+type downloadGroup struct {
+	mu    sync.Mutex
+	calls map[string]*downloadCall
+}
+
+var sharedDownloads = &downloadGroup{calls: make(map[string]*downloadCall)}
+
+// 同一 URL 同时只下载一次，跨请求共享结果；发起方请求被取消而等待方仍有效时，等待方自行重新下载
+func (g *downloadGroup) download(ctx context.Context, cfg *Config, url string, index int) ([]byte, error) {
+	g.mu.Lock()
+	if call, ok := g.calls[url]; ok {
+		g.mu.Unlock()
+		log.Printf("[DEDUP %d] 等待其他请求中相同 URL 的下载: %s", index, sanitizeURL(url, cfg.LogURLQueryAllowlist))
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, &downloadError{class: "canceled", err: ctx.Err()}
+		}
+		var dlErr *downloadError
+		if errors.As(call.err, &dlErr) && dlErr.class == "canceled" && ctx.Err() == nil {
+			return downloadImage(ctx, cfg, url, index)
+		}
+		return call.data, call.err
+	}
+	call := &downloadCall{done: make(chan struct{})}
+	g.calls[url] = call
+	g.mu.Unlock()
+
+	call.data, call.err = downloadImage(ctx, cfg, url, index)
+
+	g.mu.Lock()
+	delete(g.calls, url)
+	g.mu.Unlock()
+	close(call.done)
+	return call.data, call.err
+}
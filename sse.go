@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// SSE 中单个变体的事件
+type sseImageEvent struct {
+	Type          string `json:"type"` // image / error
+	Index         int    `json:"index"`
+	Variant       string `json:"variant,omitempty"`
+	B64JSON       string `json:"b64_json,omitempty"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// 流结束前的汇总事件
+type sseDoneEvent struct {
+	Type          string `json:"type"` // done
+	Created       int64  `json:"created"`
+	Images        int    `json:"images"`
+	Seed          string `json:"seed,omitempty"`
+	TotalDuration int64  `json:"total_duration_ms"`
+}
+
+// 超过 max_stream_duration 时代替 done 发送的终止事件
+type sseAbortEvent struct {
+	Type   string `json:"type"` // aborted
+	Error  string `json:"error"`
+	Images int    `json:"images"`
+}
+
+// 客户端请求 stream: true 时以 SSE 返回：每个变体下载完成即发送一个事件，
+// 最后发送 done 汇总事件与 [DONE]；超过最长时长时改为发送 aborted 事件
+func streamSSE(parent context.Context, w http.ResponseWriter, cfg *Config, images []Image, start time.Time, seed string) int {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	send := func(payload interface{}) {
+		data, _ := json.Marshal(payload)
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			log.Printf("[ERROR] 写入 SSE 事件失败: %v", err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	ctx, cancel := streamContext(parent, cfg)
+	defer cancel()
+	written := 0
+	fetchImagesStream(ctx, cfg, images, func(ref slotRef, slot imageSlot) {
+		if ctx.Err() != nil {
+			return
+		}
+		ev := sseImageEvent{Type: "image", Index: ref.index, Variant: slot.typ}
+		if ref.variant == 0 {
+			ev.RevisedPrompt = images[ref.index].RevisedPrompt
+		}
+		if slot.err != "" {
+			ev.Type, ev.Error = "error", slot.err
+		} else {
+			ev.B64JSON = encodeBase64(slot.data)
+			if ref.variant == 0 {
+				written++
+			}
+		}
+		send(ev)
+	})
+
+	switch {
+	case streamExpired(ctx):
+		log.Printf("[WARN] SSE 流超过最长时长 %v，已中止", time.Duration(cfg.MaxStreamDuration))
+		send(sseAbortEvent{Type: "aborted", Error: errStreamDurationExceeded.Error(), Images: written})
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return written
+	case parent.Err() != nil:
+		log.Printf("[WARN] 客户端已断开，SSE 流提前结束")
+		return written
+	}
+
+	send(sseDoneEvent{
+		Type:          "done",
+		Created:       time.Now().Unix(),
+		Images:        written,
+		Seed:          seed,
+		TotalDuration: time.Since(start).Milliseconds(),
+	})
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+	log.Printf("[SUCCESS] 以 SSE 返回 - 图片数量: %d", written)
+	return written
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 按 SSE 格式拆出每个 data 事件
+func sseEvents(t *testing.T, body string) []string {
+	t.Helper()
+	var events []string
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		data, ok := strings.CutPrefix(block, "data: ")
+		if !ok {
+			t.Fatalf("不是 SSE data 事件: %q", block)
+		}
+		events = append(events, data)
+	}
+	return events
+}
+
+func TestStreamTrueServedAsSSE(t *testing.T) {
+	png := testPNG(t, 2, 2)
+	img := newImageServer(t, png)
+	var forwarded map[string]interface{}
+	up := newUpstream(t, []string{img.URL + "/a.png", img.URL + "/b.png"}, &forwarded)
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+
+	w := postGenerations(t, `{"prompt":"x","n":2,"stream":true}`)
+	if _, ok := forwarded["stream"]; ok {
+		t.Error("stream 不应转发给上游")
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	events := sseEvents(t, w.Body.String())
+	if len(events) != 4 || events[3] != "[DONE]" {
+		t.Fatalf("应为 2 个图片事件、done 与 [DONE]，实际 %q", events)
+	}
+	seen := map[int]bool{}
+	for _, data := range events[:2] {
+		var ev sseImageEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != "image" || ev.B64JSON != base64.StdEncoding.EncodeToString(png) {
+			t.Errorf("图片事件不正确: type=%q", ev.Type)
+		}
+		seen[ev.Index] = true
+	}
+	if !seen[0] || !seen[1] {
+		t.Errorf("缺少图片事件: %v", seen)
+	}
+	var done sseDoneEvent
+	if err := json.Unmarshal([]byte(events[2]), &done); err != nil || done.Type != "done" || done.Images != 2 || done.Seed != "42" {
+		t.Errorf("done 事件不正确: %s", events[2])
+	}
+}
+
+func TestStreamModeForward(t *testing.T) {
+	var forwarded map[string]interface{}
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, &forwarded)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.StreamMode = "forward"
+	})
+	w := postGenerations(t, `{"prompt":"x","stream":true}`)
+	if forwarded["stream"] != true {
+		t.Error("stream_mode=forward 时应原样转发 stream")
+	}
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		t.Error("stream_mode=forward 时不应由代理返回 SSE")
+	}
+}
+
+func TestStreamAbortedAfterMaxDuration(t *testing.T) {
+	png := testPNG(t, 2, 2)
+	img := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.png" {
+			select {
+			case <-time.After(2 * time.Second):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Write(png)
+	})
+	up := newUpstream(t, []string{img.URL + "/a.png", img.URL + "/slow.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.MaxStreamDuration = Duration(100 * time.Millisecond)
+	})
+
+	start := time.Now()
+	w := postGenerations(t, `{"prompt":"x","n":2,"stream":true}`)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("超过最长时长后应立即结束，实际耗时 %v", elapsed)
+	}
+	events := sseEvents(t, w.Body.String())
+	if len(events) != 3 || events[2] != "[DONE]" {
+		t.Fatalf("应为 1 个图片事件、aborted 与 [DONE]，实际 %q", events)
+	}
+	var ev sseImageEvent
+	if err := json.Unmarshal([]byte(events[0]), &ev); err != nil || ev.Type != "image" || ev.Index != 0 {
+		t.Errorf("第一个事件应为 index 0 的图片: %s", events[0])
+	}
+	var aborted sseAbortEvent
+	if err := json.Unmarshal([]byte(events[1]), &aborted); err != nil ||
+		aborted.Type != "aborted" || aborted.Error != "stream duration exceeded" || aborted.Images != 1 {
+		t.Errorf("终止事件不正确: %s", events[1])
+	}
+}
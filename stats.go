@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// 进程内的生成请求计数，供 /admin/stats 在没有监控系统时快速查看
+type requestStats struct {
+	started    time.Time
+	requests   atomic.Int64
+	successes  atomic.Int64
+	failures   atomic.Int64
+	images     atomic.Int64
+	durationMs atomic.Int64
+}
+
+var stats = &requestStats{started: time.Now()}
+
+// /admin/stats 的响应
+type StatsSnapshot struct {
+	UptimeSeconds int64   `json:"uptime_seconds"`
+	Requests      int64   `json:"requests"`
+	Successes     int64   `json:"successes"`
+	Failures      int64   `json:"failures"`
+	Images        int64   `json:"images"`
+	AvgLatencyMs  float64 `json:"avg_latency_ms"`
+}
+
+func (s *requestStats) record(status int, duration time.Duration, images int) {
+	s.requests.Add(1)
+	if auditOutcome(status) == "success" {
+		s.successes.Add(1)
+	} else {
+		s.failures.Add(1)
+	}
+	s.images.Add(int64(images))
+	s.durationMs.Add(duration.Milliseconds())
+}
+
+func (s *requestStats) snapshot() StatsSnapshot {
+	snap := StatsSnapshot{
+		UptimeSeconds: int64(time.Since(s.started).Seconds()),
+		Requests:      s.requests.Load(),
+		Successes:     s.successes.Load(),
+		Failures:      s.failures.Load(),
+		Images:        s.images.Load(),
+	}
+	if snap.Requests > 0 {
+		snap.AvgLatencyMs = float64(s.durationMs.Load()) / float64(snap.Requests)
+	}
+	return snap
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats.snapshot())
+}
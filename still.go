@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+)
+
+var (
+	errNotAnimated  = errors.New("image is not animated")
+	errAnimatedWebP = errors.New("animated WebP frames cannot be decoded")
+)
+
+// 动图取第一帧并按 format（png / jpeg）编码为静态图；非动图返回 errNotAnimated。
+// 动画 WebP 没有可用的解码器，识别后返回错误，由调用方保留原图
+func extractStillFrame(data []byte, format string) ([]byte, error) {
+	switch detectFormat(data) {
+	case "gif":
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("decode gif: %w", err)
+		}
+		if len(g.Image) < 2 {
+			return nil, errNotAnimated
+		}
+		// 第一帧可能只覆盖画布的一部分，绘制到完整画布上
+		canvas := image.NewNRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+		frame := g.Image[0]
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		return encodeImage(canvas, format)
+	case "webp":
+		if animatedWebP(data) {
+			return nil, errAnimatedWebP
+		}
+	}
+	return nil, errNotAnimated
+}
+
+// VP8X 扩展头的动画标志位
+func animatedWebP(data []byte) bool {
+	return len(data) > 20 && string(data[12:16]) == "VP8X" && data[20]&0x02 != 0
+}
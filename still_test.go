@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+// 每种颜色一帧的 GIF，第 i 帧整体填充 colors[i]
+func testGIF(t *testing.T, colors ...color.RGBA) []byte {
+	t.Helper()
+	g := &gif.GIF{Config: image.Config{Width: 4, Height: 3}}
+	for _, c := range colors {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 3), color.Palette{c})
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+	g.Config.ColorModel = g.Image[0].Palette
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAnimatedGIFConvertedToStillFrame(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+	animated := testGIF(t, red, blue)
+	img := newImageServer(t, animated)
+	up := newUpstream(t, []string{img.URL + "/a.gif"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.StillFrames = "png"
+	})
+
+	resp := decodeB64Response(t, postGenerations(t, `{"prompt":"x","response_format":"b64_json"}`))
+	if len(resp.Data) != 1 {
+		t.Fatalf("data = %+v", resp.Data)
+	}
+	data, _ := base64.StdEncoding.DecodeString(resp.Data[0].B64JSON)
+	still, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("动图应转为 PNG: %v", err)
+	}
+	if b := still.Bounds(); b.Dx() != 4 || b.Dy() != 3 {
+		t.Errorf("尺寸 = %v, want 4x3", b)
+	}
+	if r, g, b, _ := still.At(1, 1).RGBA(); r>>8 != 0xff || g != 0 || b != 0 {
+		t.Errorf("应取第一帧（红色），像素为 %v", still.At(1, 1))
+	}
+
+	// 单帧 GIF 与其他静态图片原样返回
+	single := testGIF(t, blue)
+	if got, err := extractStillFrame(single, "png"); err != errNotAnimated {
+		t.Errorf("单帧 GIF: err = %v, len = %d, want errNotAnimated", err, len(got))
+	}
+	if _, err := extractStillFrame(testPNG(t, 2, 2), "png"); err != errNotAnimated {
+		t.Errorf("PNG: err = %v, want errNotAnimated", err)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 图片存储后端，Save 返回可供客户端访问的 URL
+type Storage interface {
+	Save(ctx context.Context, name, contentType string, data []byte) (string, error)
+	Get(ctx context.Context, name string) ([]byte, string, error)
+	Delete(ctx context.Context, name string) error
+}
+
+var (
+	store          Storage
+	errNotFound    = errors.New("object not found")
+	errInvalidName = errors.New("invalid object name")
+)
+
+func newStorage(cfg StorageConfig) (Storage, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建存储目录失败: %w", err)
+	}
+	return &localStorage{dir: cfg.Dir, baseURL: strings.TrimRight(cfg.PublicBaseURL, "/")}, nil
+}
+
+// 本地磁盘存储，文件通过 /files/ 路由对外提供
+type localStorage struct {
+	dir     string
+	baseURL string
+}
+
+func (s *localStorage) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", errInvalidName
+	}
+	return filepath.Join(s.dir, name), nil
+}
+
+func (s *localStorage) Save(ctx context.Context, name, contentType string, data []byte) (string, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(p, data, 0o644); err != nil {
+		return "", err
+	}
+	if meta, ok := objectMetadataFrom(ctx); ok {
+		if err := s.saveMetadata(name, meta); err != nil {
+			log.Printf("[WARN] 写入图片元数据失败: %s: %v", name, err)
+		}
+	}
+	return s.baseURL + "/files/" + name, nil
+}
+
+func (s *localStorage) Get(ctx context.Context, name string) ([]byte, string, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", errNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return data, contentType, nil
+}
+
+func (s *localStorage) Delete(ctx context.Context, name string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return errNotFound
+	}
+	os.Remove(s.metadataPath(name))
+	return err
+}
+
+func randomName(ext string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b) + ext
+}
+
+// 存储模式下 URL 响应的条目
+type OpenAIURLItem struct {
+	URL           string `json:"url,omitempty"`
+	OriginalURL   string `json:"original_url,omitempty"` // 开启 include_original 且图片经过转换时为原图的地址
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+type OpenAIURLResponse struct {
+	Created int64           `json:"created"`
+	Data    []OpenAIURLItem `json:"data"`
+}
+
+// 将下载结果按 format 转换后写入存储，返回 URL 条目；client 用于按客户端计算存储配额。
+// includeOriginal 为 true 且图片经过后处理或格式转换时，同时保存下载得到的原图
+func storeImages(ctx context.Context, s Storage, client string, images []Image, slots [][]imageSlot, format string, includeOriginal bool) []OpenAIURLItem {
+	items := make([]OpenAIURLItem, len(images))
+	for i, img := range images {
+		items[i].RevisedPrompt = img.RevisedPrompt
+		slot := slots[i][0]
+		if slot.err != "" {
+			items[i].Error = slot.err
+			continue
+		}
+		var data []byte
+		var err error
+		withEncodeSlot(func() { data, err = convertImage(slot.data, format) })
+		if err != nil {
+			log.Printf("[ERROR %d] 格式转换失败: %v", i, err)
+			items[i].Error = err.Error()
+			continue
+		}
+		ext, contentType := formatInfo(data)
+		saveCtx := ctx
+		if meta, ok := objectMetadataFrom(ctx); ok {
+			meta.Index = i
+			saveCtx = withObjectMetadata(ctx, meta)
+		}
+		url, err := saveWithQuota(saveCtx, s, client, randomName(ext), contentType, data)
+		if errors.Is(err, errQuotaExceeded) {
+			log.Printf("[WARN %d] 客户端 %s 超出存储配额，拒绝保存", i, client)
+			items[i].Error = err.Error()
+			continue
+		}
+		if errors.Is(err, errStorageTimeout) {
+			log.Printf("[ERROR %d] 存储超时: %v", i, err)
+			items[i].Error = "storage timed out"
+			continue
+		}
+		if err != nil {
+			log.Printf("[ERROR %d] 存储失败: %v", i, err)
+			items[i].Error = "storage failed"
+			continue
+		}
+		log.Printf("[STORE %d] 已保存: %s (%s, %d bytes)", i, url, contentType, len(data))
+		items[i].URL = url
+
+		original := slot.original
+		if original == nil {
+			original = slot.data
+		}
+		if !includeOriginal || bytes.Equal(original, data) {
+			continue
+		}
+		ext, contentType = formatInfo(original)
+		if url, err = saveWithQuota(saveCtx, s, client, randomName(ext), contentType, original); err != nil {
+			log.Printf("[WARN %d] 原图保存失败: %v", i, err)
+			continue
+		}
+		log.Printf("[STORE %d] 已保存原图: %s (%s, %d bytes)", i, url, contentType, len(original))
+		items[i].OriginalURL = url
+	}
+	return items
+}
+
+func writeStoredResponse(w http.ResponseWriter, cfg *Config, items []OpenAIURLItem) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(encodeResponse(cfg.OutputFieldRenames, OpenAIURLResponse{Created: time.Now().Unix(), Data: items}))
+}
+
+// 读取已存储的图片
+func handleFiles(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		http.NotFound(w, r)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/files/")
+	data, contentType, err := store.Get(r.Context(), name)
+	switch {
+	case errors.Is(err, errNotFound), errors.Is(err, errInvalidName):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, errStorageTimeout):
+		log.Printf("[ERROR] 读取存储超时: %s", name)
+		http.Error(w, `{"error":"Storage timed out"}`, http.StatusGatewayTimeout)
+		return
+	case err != nil:
+		log.Printf("[ERROR] 读取存储失败: %v", err)
+		http.Error(w, `{"error":"Storage unavailable"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Write(data)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 随图片一起保存的请求信息，使存储中的图片可以自描述
+type ObjectMetadata struct {
+	Model      string `json:"model,omitempty"`
+	PromptHash string `json:"prompt_hash,omitempty"`
+	Seed       string `json:"seed,omitempty"`
+	Tenant     string `json:"tenant,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
+	Index      int    `json:"index"`
+	Created    string `json:"created"`
+}
+
+type objectMetadataKey struct{}
+
+// 将元数据附加到 ctx，由存储后端在 Save 时写入
+func withObjectMetadata(ctx context.Context, meta ObjectMetadata) context.Context {
+	return context.WithValue(ctx, objectMetadataKey{}, meta)
+}
+
+func objectMetadataFrom(ctx context.Context) (ObjectMetadata, bool) {
+	meta, ok := ctx.Value(objectMetadataKey{}).(ObjectMetadata)
+	return meta, ok
+}
+
+// 本地存储的元数据保存在 .meta/<name>.json；以点开头的目录不会经 /files/ 对外提供
+func (s *localStorage) metadataPath(name string) string {
+	return filepath.Join(s.dir, ".meta", name+".json")
+}
+
+func (s *localStorage) saveMetadata(name string, meta ObjectMetadata) error {
+	if meta.Created == "" {
+		meta.Created = time.Now().UTC().Format(time.RFC3339)
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	p := s.metadataPath(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0o644)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStoredImageMetadata(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	up := newUpstream(t, []string{img.URL + "/a.png", img.URL + "/b.png"}, nil)
+	cfg := useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Storage.Enabled = true
+		c.Storage.Dir = t.TempDir()
+		c.Storage.PublicBaseURL = "http://proxy.example"
+		c.Storage.Metadata = true
+	})
+	s := useStorage(t, cfg)
+
+	w := postGenerations(t, `{"model":"flux","prompt":"a red fox","n":2}`, "X-Tenant-Id", "tenant-a")
+	resp := decodeURLResponse(t, w)
+	if len(resp.Data) != 2 {
+		t.Fatalf("data = %s", w.Body)
+	}
+	for i, d := range resp.Data {
+		name := strings.TrimPrefix(d.URL, "http://proxy.example/files/")
+		data, err := os.ReadFile(s.metadataPath(name))
+		if err != nil {
+			t.Fatalf("第 %d 张图片缺少元数据: %v", i, err)
+		}
+		var meta ObjectMetadata
+		if err := json.Unmarshal(data, &meta); err != nil {
+			t.Fatal(err)
+		}
+		if meta.Model != "flux" || meta.Seed != "42" || meta.Tenant != "tenant-a" || meta.Index != i || meta.Created == "" {
+			t.Errorf("第 %d 张图片的元数据 = %+v", i, meta)
+		}
+		if meta.PromptHash == "" || strings.Contains(string(data), "a red fox") {
+			t.Errorf("元数据应只包含提示词哈希: %s", data)
+		}
+
+		// 元数据不经 /files/ 对外提供
+		fw := httptest.NewRecorder()
+		handleFiles(fw, httptest.NewRequest(http.MethodGet, "/files/.meta/"+name+".json", nil))
+		if fw.Code == http.StatusOK {
+			t.Errorf("元数据文件不应可通过 /files/ 访问")
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 开启存储模式并使用临时目录作为本地存储
+func useStorage(t *testing.T, cfg *Config) *localStorage {
+	t.Helper()
+	s, err := newStorage(cfg.Storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swapGlobal(t, &store, s)
+	return s.(*localStorage)
+}
+
+func decodeURLResponse(t *testing.T, w *httptest.ResponseRecorder) OpenAIURLResponse {
+	t.Helper()
+	var resp OpenAIURLResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("响应不是有效 JSON: %v\n%s", err, w.Body)
+	}
+	return resp
+}
+
+func TestStoredOutputFormatJPEG(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 4, 4))
+	var forwarded map[string]interface{}
+	up := newUpstream(t, []string{img.URL + "/a.png"}, &forwarded)
+	cfg := useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Storage.Enabled = true
+		c.Storage.Dir = t.TempDir()
+		c.Storage.PublicBaseURL = "http://proxy.example"
+	})
+	s := useStorage(t, cfg)
+
+	w := postGenerations(t, `{"prompt":"x","output_format":"jpeg"}`)
+	if _, ok := forwarded["output_format"]; ok {
+		t.Error("output_format 不应转发给上游")
+	}
+	resp := decodeURLResponse(t, w)
+	if len(resp.Data) != 1 || !strings.HasSuffix(resp.Data[0].URL, ".jpg") {
+		t.Fatalf("URL 扩展名应为 .jpg: %s", w.Body)
+	}
+	name := strings.TrimPrefix(resp.Data[0].URL, "http://proxy.example/files/")
+
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		t.Fatalf("存储中找不到 %s: %v", name, err)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("存储的文件不是 JPEG: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/files/"+name, nil)
+	fw := httptest.NewRecorder()
+	handleFiles(fw, r)
+	if ct := fw.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("Content-Type = %q, want image/jpeg", ct)
+	}
+}
+
+func TestStoredOutputFormatRejectsUnknown(t *testing.T) {
+	cfg := useConfig(t, func(c *Config) {
+		c.UpstreamURL = "http://127.0.0.1:1"
+		c.Storage.Enabled = true
+		c.Storage.Dir = t.TempDir()
+	})
+	useStorage(t, cfg)
+	if w := postGenerations(t, `{"prompt":"x","output_format":"bmp"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+}
+
+// 首次 Save 与每次 Get 都卡住且不响应 ctx 取消的存储后端
+type stuckStorage struct {
+	Storage
+	saves atomic.Int32
+	stuck chan struct{}
+}
+
+func (s *stuckStorage) Save(ctx context.Context, name, contentType string, data []byte) (string, error) {
+	if s.saves.Add(1) == 1 {
+		<-s.stuck
+	}
+	return s.Storage.Save(ctx, name, contentType, data)
+}
+
+func (s *stuckStorage) Get(ctx context.Context, name string) ([]byte, string, error) {
+	<-s.stuck
+	return s.Storage.Get(ctx, name)
+}
+
+func TestStorageTimeouts(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	up := newUpstream(t, []string{img.URL + "/a.png", img.URL + "/b.png"}, nil)
+	cfg := useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.DedupDownloads = false
+		c.Storage.Enabled = true
+		c.Storage.Dir = t.TempDir()
+		c.Storage.PublicBaseURL = "http://proxy.example"
+		c.Storage.SaveTimeout = Duration(50 * time.Millisecond)
+		c.Storage.GetTimeout = Duration(50 * time.Millisecond)
+	})
+	slow := &stuckStorage{Storage: useStorage(t, cfg), stuck: make(chan struct{})}
+	t.Cleanup(func() { close(slow.stuck) })
+	swapGlobal(t, &store, withStorageTimeouts(slow, cfg.Storage))
+
+	start := time.Now()
+	resp := decodeURLResponse(t, postGenerations(t, `{"prompt":"x","n":2}`))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("存储卡住时请求耗时 %v，未按 save_timeout 返回", elapsed)
+	}
+	var stored, timedOut int
+	for _, item := range resp.Data {
+		switch {
+		case item.Error == "storage timed out":
+			timedOut++
+		case strings.HasPrefix(item.URL, "http://proxy.example/files/"):
+			stored++
+		}
+	}
+	if stored != 1 || timedOut != 1 {
+		t.Errorf("应有一张存储成功、一张存储超时: %+v", resp.Data)
+	}
+
+	w := httptest.NewRecorder()
+	handleFiles(w, httptest.NewRequest(http.MethodGet, "/files/anything.png", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("读取超时 status = %d, want 504", w.Code)
+	}
+}
+
+func TestB64StorageThresholdFallsBackToURLs(t *testing.T) {
+	png := testPNG(t, 16, 16)
+	img := newImageServer(t, png)
+	up := newUpstream(t, []string{img.URL + "/a.png", img.URL + "/b.png"}, nil)
+	payload := int64(2 * base64.StdEncoding.EncodedLen(len(png)))
+	body := `{"prompt":"x","n":2,"response_format":"b64_json"}`
+
+	for _, c := range []struct {
+		threshold int64
+		fallback  bool
+	}{
+		{payload - 1, true},
+		{payload, false},
+	} {
+		cfg := useConfig(t, func(cfg *Config) {
+			cfg.UpstreamURL = up.URL
+			cfg.Storage.Enabled = true
+			cfg.Storage.Dir = t.TempDir()
+			cfg.Storage.PublicBaseURL = "http://proxy.example"
+			cfg.B64StorageThreshold = c.threshold
+		})
+		useStorage(t, cfg)
+
+		w := postGenerations(t, body)
+		if got := w.Header().Get("X-Storage-Fallback") == "true"; got != c.fallback {
+			t.Errorf("threshold=%d: X-Storage-Fallback = %q", c.threshold, w.Header().Get("X-Storage-Fallback"))
+		}
+		if !c.fallback {
+			if resp := decodeB64Response(t, w); len(resp.Data) != 2 || resp.Data[0].B64JSON == "" {
+				t.Errorf("threshold=%d: 未超过阈值时应返回 base64: %s", c.threshold, w.Body)
+			}
+			continue
+		}
+		if strings.Contains(w.Body.String(), "b64_json") {
+			t.Errorf("threshold=%d: 超过阈值时不应返回 base64", c.threshold)
+		}
+		resp := decodeURLResponse(t, w)
+		if len(resp.Data) != 2 {
+			t.Fatalf("threshold=%d: data = %+v", c.threshold, resp.Data)
+		}
+		for _, d := range resp.Data {
+			if !strings.HasPrefix(d.URL, "http://proxy.example/files/") {
+				t.Errorf("threshold=%d: URL = %q, want 存储 URL", c.threshold, d.URL)
+			}
+		}
+	}
+
+	cfg := defaultConfig()
+	cfg.B64StorageThreshold = 1
+	if err := cfg.prepare(); err == nil {
+		t.Error("未开启 storage 时配置 b64_storage_threshold 应报错")
+	}
+}
+
+func TestStoredOriginalAlongsideConverted(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 4, 4))
+	up := newUpstream(t, []string{img.URL + "/a.png"}, nil)
+	cfg := useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Storage.Enabled = true
+		c.Storage.Dir = t.TempDir()
+		c.Storage.PublicBaseURL = "http://proxy.example"
+		c.IncludeOriginal = true
+	})
+	s := useStorage(t, cfg)
+
+	w := postGenerations(t, `{"prompt":"x","output_format":"jpeg"}`)
+	var resp OpenAIURLResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Data) != 1 {
+		t.Fatalf("响应不正确: %s", w.Body)
+	}
+	item := resp.Data[0]
+	if !strings.HasSuffix(item.URL, ".jpg") || !strings.HasSuffix(item.OriginalURL, ".png") {
+		t.Fatalf("url = %q, original_url = %q, want .jpg 与 .png", item.URL, item.OriginalURL)
+	}
+	for url, want := range map[string]string{item.URL: "jpeg", item.OriginalURL: "png"} {
+		data, err := os.ReadFile(filepath.Join(s.dir, strings.TrimPrefix(url, "http://proxy.example/files/")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := detectFormat(data); got != want {
+			t.Errorf("%s 的格式为 %q, want %s", url, got, want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var errStorageTimeout = errors.New("storage operation timed out")
+
+// 为存储后端的 Save/Get 加上超时。后端不响应 ctx 取消（如卡住的磁盘）时也会按时返回 errStorageTimeout，
+// 单张图片的存储失败，不会拖住整个请求
+type timeoutStorage struct {
+	Storage
+	save time.Duration
+	get  time.Duration
+}
+
+func withStorageTimeouts(s Storage, cfg StorageConfig) Storage {
+	if s == nil || cfg.SaveTimeout <= 0 && cfg.GetTimeout <= 0 {
+		return s
+	}
+	return &timeoutStorage{Storage: s, save: time.Duration(cfg.SaveTimeout), get: time.Duration(cfg.GetTimeout)}
+}
+
+func (t *timeoutStorage) Save(ctx context.Context, name, contentType string, data []byte) (string, error) {
+	return runWithTimeout(ctx, t.save, func(ctx context.Context) (string, error) {
+		return t.Storage.Save(ctx, name, contentType, data)
+	})
+}
+
+type storageObject struct {
+	data        []byte
+	contentType string
+}
+
+func (t *timeoutStorage) Get(ctx context.Context, name string) ([]byte, string, error) {
+	obj, err := runWithTimeout(ctx, t.get, func(ctx context.Context) (storageObject, error) {
+		data, contentType, err := t.Storage.Get(ctx, name)
+		return storageObject{data, contentType}, err
+	})
+	return obj.data, obj.contentType, err
+}
+
+// 在超时内执行 op；超时后不再等待 op 返回，其结果被丢弃
+func runWithTimeout[T any](ctx context.Context, timeout time.Duration, op func(ctx context.Context) (T, error)) (T, error) {
+	if timeout <= 0 {
+		return op(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := op(ctx)
+		done <- result{v, err}
+	}()
+	select {
+	case res := <-done:
+		return res.v, res.err
+	case <-ctx.Done():
+		var zero T
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return zero, errStorageTimeout
+		}
+		return zero, ctx.Err()
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var errStreamDurationExceeded = errors.New("stream duration exceeded")
+
+// 按 max_stream_duration 限制流式响应的最长时长；客户端断开时 parent 取消，流同样随之结束
+func streamContext(parent context.Context, cfg *Config) (context.Context, context.CancelFunc) {
+	if cfg.MaxStreamDuration <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeoutCause(parent, time.Duration(cfg.MaxStreamDuration), errStreamDurationExceeded)
+}
+
+// 流因超过最长时长而中止，此时应写出终止事件；客户端已断开时不必再写
+func streamExpired(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errStreamDurationExceeded)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"sync"
+)
+
+// 租户标签的取值：无租户头的请求为 none，不合法或超出上限的为 other
+const (
+	tenantNone  = "none"
+	tenantOther = "other"
+)
+
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// 把租户头的取值收敛为有限的指标标签，避免标签数量随客户端输入无限增长
+type tenantLabeler struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+var tenants = &tenantLabeler{seen: make(map[string]struct{})}
+
+// 配置了 allowed 时只接受其中的租户；否则按出现顺序接受前 max_tenants 个
+func (t *tenantLabeler) label(cfg TenantConfig, value string) string {
+	if value == "" {
+		return tenantNone
+	}
+	if !tenantPattern.MatchString(value) {
+		return tenantOther
+	}
+	if len(cfg.Allowed) > 0 {
+		if slices.Contains(cfg.Allowed, value) {
+			return value
+		}
+		return tenantOther
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.seen[value]; ok {
+		return value
+	}
+	if len(t.seen) >= cfg.MaxTenants {
+		return tenantOther
+	}
+	t.seen[value] = struct{}{}
+	return value
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTenantLabelOnRequestMetric(t *testing.T) {
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, nil)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Tenant.MaxTenants = 1
+	})
+	swapGlobal(t, &tenants, &tenantLabeler{seen: make(map[string]struct{})})
+	logs := captureLog(t)
+
+	count := func(tenant string) float64 {
+		return testutil.ToFloat64(requestsTotal.WithLabelValues(tenant, "success"))
+	}
+	acme, other, none := count("acme"), count(tenantOther), count(tenantNone)
+
+	postGenerations(t, `{"prompt":"x"}`, "X-Tenant-Id", "acme")
+	postGenerations(t, `{"prompt":"x"}`, "X-Tenant-Id", "beta")    // 超出 max_tenants
+	postGenerations(t, `{"prompt":"x"}`, "X-Tenant-Id", "bad val") // 不合法
+	postGenerations(t, `{"prompt":"x"}`)
+
+	if got := count("acme") - acme; got != 1 {
+		t.Errorf("tenant=acme 计数增加 %v, want 1", got)
+	}
+	if got := count(tenantOther) - other; got != 2 {
+		t.Errorf("tenant=other 计数增加 %v, want 2", got)
+	}
+	if got := count(tenantNone) - none; got != 1 {
+		t.Errorf("tenant=none 计数增加 %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(requestsTotal.WithLabelValues("beta", "success")); got != 0 {
+		t.Errorf("超出上限的租户不应产生独立标签，tenant=beta 计数 %v", got)
+	}
+	if !strings.Contains(logs.String(), "tenant=acme") {
+		t.Error("日志中缺少 tenant=acme")
+	}
+}
+
+func TestTenantAllowedList(t *testing.T) {
+	l := &tenantLabeler{seen: make(map[string]struct{})}
+	cfg := TenantConfig{MaxTenants: 50, Allowed: []string{"acme"}}
+	if got := l.label(cfg, "acme"); got != "acme" {
+		t.Errorf("label(acme) = %q", got)
+	}
+	if got := l.label(cfg, "beta"); got != tenantOther {
+		t.Errorf("不在 allowed 中的租户 label = %q, want other", got)
+	}
+}
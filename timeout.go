@@ -0,0 +1,33 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 正在进行的上游生成调用数
+var upstreamInFlight atomic.Int64
+
+// 按当前在途调用数选择上游超时：取 in_flight 不超过当前值的最高一档（steps 已按 in_flight 升序排列），
+// 没有命中任何一档时使用 upstream_timeout
+func adaptiveTimeout(base time.Duration, cfg AdaptiveTimeoutConfig, inFlight int64) time.Duration {
+	timeout := base
+	if !cfg.Enabled {
+		return timeout
+	}
+	for _, step := range cfg.Steps {
+		if inFlight < int64(step.InFlight) {
+			break
+		}
+		timeout = time.Duration(step.Timeout)
+	}
+	return timeout
+}
+
+// 登记一次上游调用并返回本次生效的超时，调用结束后需执行返回的 done
+func beginUpstreamCall(cfg *Config) (time.Duration, func()) {
+	n := upstreamInFlight.Add(1)
+	timeout := adaptiveTimeout(time.Duration(cfg.UpstreamTimeout), cfg.AdaptiveTimeout, n)
+	upstreamAdaptiveTimeout.Set(timeout.Seconds())
+	return timeout, func() { upstreamInFlight.Add(-1) }
+}
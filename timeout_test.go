@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAdaptiveTimeoutShortensUnderLoad(t *testing.T) {
+	cfg := useConfig(t, func(c *Config) {
+		c.UpstreamTimeout = Duration(15 * time.Second)
+		c.AdaptiveTimeout = AdaptiveTimeoutConfig{Enabled: true, Steps: []TimeoutStep{
+			{InFlight: 4, Timeout: Duration(2 * time.Second)},
+			{InFlight: 2, Timeout: Duration(5 * time.Second)},
+		}}
+	})
+
+	want := []time.Duration{15 * time.Second, 5 * time.Second, 5 * time.Second, 2 * time.Second, 2 * time.Second}
+	var done []func()
+	for i, w := range want {
+		timeout, finish := beginUpstreamCall(cfg)
+		done = append(done, finish)
+		if timeout != w {
+			t.Errorf("第 %d 个在途调用的超时 = %v, want %v", i+1, timeout, w)
+		}
+		if got := testutil.ToFloat64(upstreamAdaptiveTimeout); got != w.Seconds() {
+			t.Errorf("sc_proxy_upstream_adaptive_timeout_seconds = %v, want %v", got, w.Seconds())
+		}
+	}
+	for _, finish := range done {
+		finish()
+	}
+	if timeout, finish := beginUpstreamCall(cfg); timeout != 15*time.Second {
+		t.Errorf("调用结束后超时应恢复为 upstream_timeout，实际 %v", timeout)
+	} else {
+		finish()
+	}
+}
+
+func TestAdaptiveTimeoutAppliedToUpstreamCall(t *testing.T) {
+	release := make(chan struct{})
+	var once sync.Once
+	arrived := make(chan struct{}, 2)
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte(`{"images":[{"url":"https://cdn.example/a.png"}]}`))
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.AdaptiveTimeout = AdaptiveTimeoutConfig{Enabled: true, Steps: []TimeoutStep{{InFlight: 2, Timeout: Duration(50 * time.Millisecond)}}}
+	})
+	t.Cleanup(func() { once.Do(func() { close(release) }) })
+
+	first := make(chan int, 1)
+	go func() { first <- postGenerations(t, `{"prompt":"x"}`).Code }()
+	<-arrived
+
+	// 第二个在途调用命中 in_flight=2 一档，50ms 后超时
+	start := time.Now()
+	if w := postGenerations(t, `{"prompt":"x"}`); w.Code != http.StatusBadGateway {
+		t.Errorf("高并发下的调用 status = %d, want 502", w.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("高并发下的调用耗时 %v，超时未收紧", elapsed)
+	}
+
+	once.Do(func() { close(release) })
+	if code := <-first; code != http.StatusOK {
+		t.Errorf("首个调用使用 upstream_timeout，status = %d, want 200", code)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 响应中的耗时明细（毫秒）
+type ResponseTimings struct {
+	UpstreamMs int64 `json:"upstream_ms"` // 上游调用（含异步任务轮询）
+	// 上游报告的推理耗时，原样透传（单位由上游决定）
+	UpstreamInference json.Number `json:"upstream_inference,omitempty"`
+	DownloadMs        int64       `json:"download_ms"` // 全部图片并发下载的总耗时
+	// 每张图片（主变体）的下载与后处理耗时，未完成的为 -1
+	ImagesMs []int64 `json:"images_ms"`
+	TotalMs  int64   `json:"total_ms"`
+}
+
+func buildResponseTimings(origin OriginResponse, slots [][]imageSlot, upstream, download, total time.Duration) *ResponseTimings {
+	t := &ResponseTimings{
+		UpstreamMs:        upstream.Milliseconds(),
+		UpstreamInference: origin.Timings.Inference,
+		DownloadMs:        download.Milliseconds(),
+		ImagesMs:          make([]int64, len(slots)),
+		TotalMs:           total.Milliseconds(),
+	}
+	for i, s := range slots {
+		t.ImagesMs[i] = -1
+		if len(s) > 0 && s[0].elapsed > 0 {
+			t.ImagesMs[i] = s[0].elapsed.Milliseconds()
+		}
+	}
+	return t
+}
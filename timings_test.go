@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseTimingsPlausible(t *testing.T) {
+	png := testPNG(t, 2, 2)
+	img := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write(png)
+	})
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		fmt.Fprintf(w, `{"images":[{"url":"%[1]s/a.png"},{"url":"%[1]s/b.png"}],"timings":{"inference":1.25}}`, img.URL)
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.IncludeTimings = true
+	})
+
+	resp := decodeB64Response(t, postGenerations(t, `{"prompt":"x","n":2,"response_format":"b64_json"}`))
+	tm := resp.Timings
+	if tm == nil {
+		t.Fatal("缺少 timings")
+	}
+	if tm.UpstreamMs < 30 {
+		t.Errorf("upstream_ms = %d, 应不少于上游处理的 30ms", tm.UpstreamMs)
+	}
+	if tm.UpstreamInference.String() != "1.25" {
+		t.Errorf("upstream_inference = %q, want 1.25", tm.UpstreamInference)
+	}
+	if len(tm.ImagesMs) != 2 {
+		t.Fatalf("images_ms = %v", tm.ImagesMs)
+	}
+	for i, ms := range tm.ImagesMs {
+		if ms < 20 || ms > tm.DownloadMs {
+			t.Errorf("images_ms[%d] = %d，应在 20ms 与 download_ms(%d) 之间", i, ms, tm.DownloadMs)
+		}
+	}
+	if tm.TotalMs < tm.UpstreamMs+tm.DownloadMs {
+		t.Errorf("total_ms = %d，应不少于 upstream_ms + download_ms = %d", tm.TotalMs, tm.UpstreamMs+tm.DownloadMs)
+	}
+
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	if w := postGenerations(t, `{"prompt":"x","n":2,"response_format":"b64_json"}`); strings.Contains(w.Body.String(), "upstream_ms") {
+		t.Error("未开启 include_timings 时不应返回 timings")
+	}
+}
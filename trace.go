@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// 上游调用的分阶段耗时，用于区分网络慢还是上游计算慢
+type upstreamTiming struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	wroteRequest time.Time
+
+	DNS        time.Duration
+	Connect    time.Duration
+	TLS        time.Duration
+	TTFB       time.Duration // 请求写完到收到首字节
+	Total      time.Duration
+	ReusedConn bool
+}
+
+// 为上游请求挂载 httptrace 钩子
+func withUpstreamTrace(ctx context.Context) (context.Context, *upstreamTiming) {
+	t := &upstreamTiming{start: time.Now()}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { t.mark(&t.dnsStart) },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.since(&t.DNS, t.dnsStart)
+		},
+		ConnectStart: func(string, string) { t.mark(&t.connectStart) },
+		ConnectDone: func(string, string, error) {
+			t.since(&t.Connect, t.connectStart)
+		},
+		TLSHandshakeStart: func() { t.mark(&t.tlsStart) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.since(&t.TLS, t.tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.ReusedConn = info.Reused
+			t.mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) { t.mark(&t.wroteRequest) },
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			from := t.wroteRequest
+			if from.IsZero() {
+				from = t.start
+			}
+			t.TTFB = time.Since(from)
+		},
+	}
+	return httptrace.WithClientTrace(ctx, trace), t
+}
+
+func (t *upstreamTiming) mark(at *time.Time) {
+	t.mu.Lock()
+	*at = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *upstreamTiming) since(d *time.Duration, from time.Time) {
+	t.mu.Lock()
+	*d = time.Since(from)
+	t.mu.Unlock()
+}
+
+// 记录日志与指标；连接复用时 DNS/连接/TLS 阶段不会发生，不计入指标
+func (t *upstreamTiming) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Total = time.Since(t.start)
+
+	log.Printf("[TRACE] 上游耗时 dns=%v connect=%v tls=%v ttfb=%v total=%v reused=%v",
+		t.DNS, t.Connect, t.TLS, t.TTFB, t.Total, t.ReusedConn)
+
+	if !t.dnsStart.IsZero() {
+		upstreamPhaseDuration.WithLabelValues("dns").Observe(t.DNS.Seconds())
+	}
+	if !t.connectStart.IsZero() {
+		upstreamPhaseDuration.WithLabelValues("connect").Observe(t.Connect.Seconds())
+	}
+	if !t.tlsStart.IsZero() {
+		upstreamPhaseDuration.WithLabelValues("tls").Observe(t.TLS.Seconds())
+	}
+	if t.TTFB > 0 {
+		upstreamPhaseDuration.WithLabelValues("ttfb").Observe(t.TTFB.Seconds())
+	}
+	upstreamPhaseDuration.WithLabelValues("total").Observe(t.Total.Seconds())
+}
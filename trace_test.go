@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// 直方图某个标签的样本数
+func histogramCount(t *testing.T, vec *prometheus.HistogramVec, labels ...string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := vec.WithLabelValues(labels...).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestUpstreamTraceRecordsPhases(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	// 使用主机名以触发 DNS 解析阶段
+	url := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}}
+
+	phases := []string{"dns", "connect", "tls", "ttfb", "total"}
+	before := make(map[string]uint64)
+	for _, p := range phases {
+		before[p] = histogramCount(t, upstreamPhaseDuration, p)
+	}
+
+	ctx, timing := withUpstreamTrace(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	timing.finish()
+
+	if timing.dnsStart.IsZero() || timing.Connect <= 0 || timing.TLS <= 0 {
+		t.Errorf("DNS/连接/TLS 钩子未触发: dns=%v connect=%v tls=%v", timing.DNS, timing.Connect, timing.TLS)
+	}
+	if timing.TTFB < 20*time.Millisecond || timing.Total < timing.TTFB {
+		t.Errorf("ttfb=%v total=%v，应包含上游处理耗时", timing.TTFB, timing.Total)
+	}
+	if timing.ReusedConn {
+		t.Error("新建连接不应标记为复用")
+	}
+	for _, p := range phases {
+		if got := histogramCount(t, upstreamPhaseDuration, p); got != before[p]+1 {
+			t.Errorf("阶段 %s 的指标样本数 = %d, want %d", p, got, before[p]+1)
+		}
+	}
+}
+
+func TestResponseSizeRecorded(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 8, 8))
+	up := newUpstream(t, []string{img.URL + "/a.png"}, nil)
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+
+	for _, format := range []string{"b64_json", "url"} {
+		var before dto.Metric
+		responseSize.WithLabelValues(format).(prometheus.Metric).Write(&before)
+
+		logs := captureLog(t)
+		w := postGenerations(t, `{"prompt":"x","response_format":"`+format+`"}`)
+		var after dto.Metric
+		responseSize.WithLabelValues(format).(prometheus.Metric).Write(&after)
+
+		if got := after.GetHistogram().GetSampleCount() - before.GetHistogram().GetSampleCount(); got != 1 {
+			t.Errorf("%s: 样本数增加了 %d, want 1", format, got)
+		}
+		if got, want := after.GetHistogram().GetSampleSum()-before.GetHistogram().GetSampleSum(), float64(w.Body.Len()); got != want {
+			t.Errorf("%s: 记录的响应大小 = %v, want 实际写出的 %v 字节", format, got, want)
+		}
+		if want := fmt.Sprintf("format=%s bytes=%d", format, w.Body.Len()); !strings.Contains(logs.String(), want) {
+			t.Errorf("%s: [COMPLETE] 日志缺少 %q:\n%s", format, want, logs)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+	"unicode"
+)
+
+// 提示词是否需要翻译：含非 ASCII 字母即视为非英文
+func needsTranslation(prompt string) bool {
+	for _, r := range prompt {
+		if r > unicode.MaxASCII && unicode.IsLetter(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// 调用 LibreTranslate 兼容接口翻译提示词，超时或失败时返回原提示词
+func translatePrompt(ctx context.Context, cfg TranslationConfig, model, prompt string) string {
+	if !cfg.Enabled || !needsTranslation(prompt) {
+		return prompt
+	}
+	if len(cfg.Models) > 0 && !slices.Contains(cfg.Models, model) {
+		return prompt
+	}
+
+	start := time.Now()
+	translated, err := callTranslation(ctx, cfg, prompt)
+	if err != nil {
+		log.Printf("[TRANSLATE] 翻译失败，使用原提示词: %v", err)
+		return prompt
+	}
+	log.Printf("[TRANSLATE] 原提示词: %q -> %q, 耗时: %v", prompt, translated, time.Since(start))
+	return translated
+}
+
+func callTranslation(ctx context.Context, cfg TranslationConfig, prompt string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout))
+	defer cancel()
+
+	payload, _ := json.Marshal(map[string]string{
+		"q":       prompt,
+		"source":  "auto",
+		"target":  cfg.TargetLanguage,
+		"format":  "text",
+		"api_key": cfg.APIKey,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("translation service returned %s", resp.Status)
+	}
+
+	var out struct {
+		TranslatedText string `json:"translatedText"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("decode translation: %w", err)
+	}
+	if out.TranslatedText == "" {
+		return "", fmt.Errorf("empty translation")
+	}
+	return out.TranslatedText, nil
+}
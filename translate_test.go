@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTranslatedPromptForwarded(t *testing.T) {
+	var slow bool
+	translator := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		var in map[string]string
+		json.NewDecoder(r.Body).Decode(&in)
+		if slow {
+			time.Sleep(200 * time.Millisecond)
+		}
+		if in["q"] != "一只猫" || in["target"] != "en" {
+			http.Error(w, "unexpected", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"translatedText":"a cat"}`))
+	})
+	var forwarded map[string]interface{}
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, &forwarded)
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Translation.Enabled = true
+		c.Translation.URL = translator.URL
+		c.Translation.Timeout = Duration(50 * time.Millisecond)
+	})
+	logs := captureLog(t)
+
+	postGenerations(t, `{"prompt":"一只猫"}`)
+	if forwarded["prompt"] != "a cat" {
+		t.Errorf("转发的 prompt = %q, want 译文", forwarded["prompt"])
+	}
+	if !strings.Contains(logs.String(), `"一只猫"`) {
+		t.Errorf("日志中应保留原提示词:\n%s", logs)
+	}
+
+	postGenerations(t, `{"prompt":"a dog"}`)
+	if forwarded["prompt"] != "a dog" {
+		t.Errorf("英文提示词不应翻译，转发 %q", forwarded["prompt"])
+	}
+
+	slow = true
+	start := time.Now()
+	postGenerations(t, `{"prompt":"一只猫"}`)
+	if forwarded["prompt"] != "一只猫" {
+		t.Errorf("翻译超时应使用原提示词，转发 %q", forwarded["prompt"])
+	}
+	if time.Since(start) > 180*time.Millisecond {
+		t.Errorf("翻译超时未生效")
+	}
+}
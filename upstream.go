@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 一次上游调用的结果；失败时 stage 标明失败环节：request / read / job
+type upstreamResult struct {
+	status int
+	header http.Header
+	body   []byte
+	stage  string
+	err    error
+}
+
+// 向上游发送一次生成请求：附加鉴权与签名，读取完整响应体，开启异步模式时轮询任务直至完成
+func callUpstream(ctx context.Context, cfg *Config, client *http.Client, targetURL string, clientHeader http.Header, body []byte, record func(*http.Response, error, time.Duration)) upstreamResult {
+	// 自适应超时只作用于本次请求与读取响应，不影响之后的异步任务轮询
+	timeout, done := beginUpstreamCall(cfg)
+	defer done()
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	log.Printf("[FORWARD] 请求体: %s", string(body))
+
+	// Key 被上游以 401/429 拒绝时换用 Key 池中的下一个
+	auths := upstreamAuthorizations(cfg, clientHeader)
+	var proxyReq *http.Request
+	var resp *http.Response
+	var timing *upstreamTiming
+	for i, auth := range auths {
+		var traceCtx context.Context
+		traceCtx, timing = withUpstreamTrace(callCtx)
+		proxyReq, _ = http.NewRequestWithContext(traceCtx, http.MethodPost, targetURL, bytes.NewReader(body))
+
+		// 复制标头
+		for k, v := range clientHeader {
+			proxyReq.Header[k] = v
+		}
+		if auth != "" {
+			proxyReq.Header.Set("Authorization", auth)
+		}
+		signUpstreamRequest(cfg.Signing, proxyReq, body)
+
+		postClient := noRedirectClient(client)
+		var err error
+		resp, err = doUpstream(postClient, cfg.Hedge, proxyReq, body, record)
+		if err == nil {
+			resp, err = followUpstreamRedirects(cfg.UpstreamRedirects, postClient, proxyReq, body, resp, record)
+		}
+		if err != nil {
+			timing.finish()
+			return upstreamResult{stage: "request", err: sanitizeURLError(err, cfg.LogURLQueryAllowlist)}
+		}
+		if i == len(auths)-1 || !rotateUpstreamKey(cfg.KeyRotation, auth, resp) || !takeRetry(ctx, "key_rotation") {
+			break
+		}
+		resp.Body.Close()
+		timing.finish()
+	}
+	defer resp.Body.Close()
+
+	respBody, err := readUpstreamBody(resp.Body, cfg.MaxUpstreamResponseBytes)
+	timing.finish()
+	if err != nil {
+		return upstreamResult{status: resp.StatusCode, header: resp.Header, stage: "read", err: err}
+	}
+	res := upstreamResult{status: resp.StatusCode, header: resp.Header, body: sanitizeUpstreamBody(respBody)}
+
+	// 上游返回异步任务时轮询直至完成
+	if cfg.UpstreamAsync.Enabled {
+		if res.body, err = awaitUpstreamJob(ctx, cfg.UpstreamAsync, client, proxyReq.Header, res.body, cfg.MaxUpstreamResponseBytes); err != nil {
+			res.stage, res.err = "job", err
+		}
+	}
+	return res
+}
+
+// 解析 seeds 参数：必须是整数数组，且与 n（如有）数量一致。
+// 拆分调用时每个 seed 会覆盖请求中的 seed，即使 exclusive_params 关闭也不接受两者同时出现
+func parseSeeds(reqBody map[string]interface{}) ([]int64, error) {
+	raw, ok := reqBody["seeds"]
+	if !ok {
+		return nil, nil
+	}
+	if _, ok := reqBody["seed"]; ok {
+		return nil, fmt.Errorf("seed and seeds are mutually exclusive")
+	}
+	list, ok := raw.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("seeds must be a non-empty array of integers")
+	}
+	seeds := make([]int64, len(list))
+	for i, v := range list {
+		f, ok := v.(float64)
+		if !ok || f != float64(int64(f)) {
+			return nil, fmt.Errorf("seeds[%d] must be an integer", i)
+		}
+		seeds[i] = int64(f)
+	}
+	if _, ok := reqBody["n"]; ok {
+		if n := intParam(reqBody["n"], 1); n != len(seeds) {
+			return nil, fmt.Errorf("seeds has %d entries but n is %d", len(seeds), n)
+		}
+	}
+	return seeds, nil
+}
+
+// 按 seeds 拆分为每个 seed 一次的上游调用（并发数受 seeds_concurrency 限制），结果按 seeds 顺序返回
+func callUpstreamSeeds(ctx context.Context, cfg *Config, client *http.Client, targetURL string, clientHeader http.Header, reqBody map[string]interface{}, seeds []int64, record func(*http.Response, error, time.Duration)) []upstreamResult {
+	results := make([]upstreamResult, len(seeds))
+	sem := make(chan struct{}, max(cfg.SeedsConcurrency, 1))
+	var wg sync.WaitGroup
+	for i, seed := range seeds {
+		body := make(map[string]interface{}, len(reqBody))
+		for k, v := range reqBody {
+			body[k] = v
+		}
+		delete(body, "seeds")
+		body["seed"] = seed
+		body["n"] = 1
+		bodyBytes, _ := json.Marshal(body)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = callUpstream(ctx, cfg, client, targetURL, clientHeader, bodyBytes, record)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// 合并按 seed 拆分的结果：每个 seed 对应一张图片，失败的 seed 以错误条目占位；费用为各次之和
+func mergeSeedResults(cfg *Config, seeds []int64, results []upstreamResult) (OriginResponse, string) {
+	merged := OriginResponse{Seed: json.Number(strconv.FormatInt(seeds[0], 10))}
+	var cost float64
+	var costSeen bool
+	for i, res := range results {
+		if res.err == nil && res.status < 400 {
+			origin, err := parseUpstreamResponse(res.body, cfg.UpstreamImagesPath)
+			if err == nil && len(origin.Images) > 0 {
+				merged.Images = append(merged.Images, origin.Images[0])
+				if c, err := strconv.ParseFloat(upstreamCost(cfg.Cost, res.header, res.body), 64); err == nil {
+					cost += c
+					costSeen = true
+				}
+				continue
+			}
+			res.err = fmt.Errorf("no image in upstream response")
+		}
+		if res.err == nil {
+			res.err = fmt.Errorf("upstream returned %d", res.status)
+		}
+		log.Printf("[ERROR] seed %d 的上游调用失败: %v", seeds[i], res.err)
+		merged.Images = append(merged.Images, Image{failure: fmt.Sprintf("upstream call for seed %d failed", seeds[i])})
+	}
+	if !costSeen {
+		return merged, ""
+	}
+	return merged, strconv.FormatFloat(cost, 'f', -1, 64)
+}
+
+// 上游实际返回的图片数量（不含失败占位）
+func countUpstreamImages(images []Image) int {
+	n := 0
+	for _, img := range images {
+		if img.failure == "" {
+			n++
+		}
+	}
+	return n
+}
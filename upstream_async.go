@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 上游异步任务的状态响应，兼容常见的字段命名
+type upstreamJob struct {
+	ID           string          `json:"id"`
+	RequestID    string          `json:"request_id"`
+	RequestIDAlt string          `json:"requestId"`
+	Status       string          `json:"status"`
+	Progress     json.Number     `json:"progress"`
+	Reason       string          `json:"reason"`
+	Results      json.RawMessage `json:"results"`
+}
+
+func (j upstreamJob) jobID() string {
+	for _, id := range []string{j.RequestID, j.RequestIDAlt, j.ID} {
+		if id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+func normalizeStatus(s string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "", " ", "").Replace(s))
+}
+
+func isPendingStatus(s string) bool {
+	switch normalizeStatus(s) {
+	case "queued", "pending", "inqueue", "submitted", "running", "inprogress", "processing":
+		return true
+	}
+	return false
+}
+
+func isFailedStatus(s string) bool {
+	switch normalizeStatus(s) {
+	case "failed", "error", "cancelled", "canceled":
+		return true
+	}
+	return false
+}
+
+// 上游进度统一为 0-100，兼容 0-1 的小数写法
+func (j upstreamJob) progressPercent() (float64, bool) {
+	p, err := j.Progress.Float64()
+	if err != nil {
+		return 0, false
+	}
+	if p > 0 && p <= 1 {
+		p *= 100
+	}
+	return min(max(p, 0), 100), true
+}
+
+// 若上游返回的是未完成的异步任务，则轮询状态接口直到完成，返回包含图片的响应体；
+// 同步响应原样返回
+func awaitUpstreamJob(ctx context.Context, cfg UpstreamAsyncConfig, client *http.Client, header http.Header, body []byte, limit int64) ([]byte, error) {
+	var job upstreamJob
+	if err := json.Unmarshal(body, &job); err != nil || job.jobID() == "" || !isPendingStatus(job.Status) {
+		return body, nil
+	}
+	id := job.jobID()
+	log.Printf("[ASYNC] 上游任务已提交: %s (%s)", id, job.Status)
+
+	if cfg.PollTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.PollTimeout))
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(time.Duration(cfg.PollInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("poll upstream job %s: %w", id, ctx.Err())
+		case <-ticker.C:
+		}
+
+		statusBody, err := fetchUpstreamJob(ctx, cfg, client, header, id, limit)
+		if err != nil {
+			log.Printf("[ASYNC] 查询上游任务失败: %v", err)
+			continue
+		}
+		job = upstreamJob{}
+		if err := json.Unmarshal(statusBody, &job); err != nil {
+			return nil, fmt.Errorf("decode upstream job status: %w", err)
+		}
+		if p, ok := job.progressPercent(); ok {
+			reportProgress(ctx, p)
+		}
+		switch {
+		case isPendingStatus(job.Status):
+			continue
+		case isFailedStatus(job.Status):
+			return nil, fmt.Errorf("upstream job %s %s: %s", id, job.Status, job.Reason)
+		}
+
+		log.Printf("[ASYNC] 上游任务完成: %s (%s)", id, job.Status)
+		reportProgress(ctx, 100)
+		if len(job.Results) > 0 && job.Results[0] == '{' {
+			return job.Results, nil
+		}
+		return statusBody, nil
+	}
+}
+
+func fetchUpstreamJob(ctx context.Context, cfg UpstreamAsyncConfig, client *http.Client, header http.Header, id string, limit int64) ([]byte, error) {
+	url := strings.ReplaceAll(cfg.StatusURL, "{id}", id)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", header.Get("Authorization"))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readUpstreamBody(resp.Body, limit)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	return sanitizeUpstreamBody(body), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 按 socket 路径与空闲连接上限复用的 Transport，保持连接池在请求之间共享
+var upstreamTransports sync.Map
+
+type transportKey struct {
+	socket  string
+	maxIdle int
+}
+
+// 上游 HTTP 客户端；配置了 upstream_socket 时经 Unix 域套接字连接，URL 中的主机名仅用于 Host 头。
+// 开启 prewarm_connections 时每个主机保留的空闲连接数不低于预热数量，预热的连接不会被连接池回收
+func upstreamClient(cfg *Config) *http.Client {
+	client := &http.Client{Timeout: time.Duration(cfg.UpstreamTimeout)}
+	if cfg.UpstreamSocket == "" && cfg.PrewarmConnections <= http.DefaultMaxIdleConnsPerHost {
+		return client
+	}
+	key := transportKey{socket: cfg.UpstreamSocket, maxIdle: max(cfg.PrewarmConnections, http.DefaultMaxIdleConnsPerHost)}
+	if t, ok := upstreamTransports.Load(key); ok {
+		client.Transport = t.(*http.Transport)
+		return client
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = key.maxIdle
+	t.MaxIdleConns = max(t.MaxIdleConns, key.maxIdle)
+	if key.socket != "" {
+		path := key.socket
+		t.Proxy = nil
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+	}
+	actual, _ := upstreamTransports.LoadOrStore(key, t)
+	client.Transport = actual.(*http.Transport)
+	return client
+}
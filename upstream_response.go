@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+var errUpstreamTooLarge = errors.New("upstream response too large")
+
+// 上游在响应体发送到一半时断开或超时
+var errUpstreamTruncated = errors.New("upstream response truncated")
+
+// 读取上游响应体，超过 limit 字节时返回错误而不是继续读取；limit 为 0 表示不限制
+func readUpstreamBody(r io.Reader, limit int64) ([]byte, error) {
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		var netErr net.Error
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, fmt.Errorf("%w after %d bytes: %v", errUpstreamTruncated, len(body), err)
+		}
+		return nil, err
+	}
+	if limit <= 0 {
+		return body, nil
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("%w: exceeds max_upstream_response_bytes (%d bytes)", errUpstreamTooLarge, limit)
+	}
+	return body, nil
+}
+
+// 响应体被截断：读取中途断开，或连接关闭使 JSON 在结尾处不完整
+func upstreamTruncated(err error) bool {
+	return errors.Is(err, errUpstreamTruncated) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func writeUpstreamTruncated(w http.ResponseWriter, status int) {
+	upstreamTruncatedTotal.Inc()
+	writeError(w, status, "Upstream response truncated: connection closed or timed out before the body was complete")
+}
+
+// 去掉上游响应体开头的 UTF-8 BOM 与首尾空白，部分上游会带上它们导致 JSON 解析失败
+func sanitizeUpstreamBody(body []byte) []byte {
+	body = bytes.TrimSpace(body)
+	for bytes.HasPrefix(body, utf8BOM) {
+		body = bytes.TrimSpace(body[len(utf8BOM):])
+	}
+	return body
+}
+
+// 解析完整读取的上游响应体。流式上游可能依次输出多份 JSON（逐步更新的快照或 NDJSON），
+// 此时以最后一份完整的 JSON 为准；末尾不完整的片段视为响应被截断
+func decodeUpstreamJSON(body []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	var last json.RawMessage
+	for {
+		var msg json.RawMessage
+		err := dec.Decode(&msg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		last = msg
+	}
+	if last == nil {
+		return io.ErrUnexpectedEOF
+	}
+	return json.Unmarshal(last, v)
+}
+
+// 解析上游响应；imagesPath 非空时按点分路径（如 output.images）定位图片数组
+func parseUpstreamResponse(body []byte, imagesPath string) (OriginResponse, error) {
+	var originResp OriginResponse
+	var raw json.RawMessage
+	if err := decodeUpstreamJSON(body, &raw); err != nil {
+		return originResp, err
+	}
+	if err := json.Unmarshal(raw, &originResp); err != nil {
+		return originResp, err
+	}
+	if imagesPath != "" {
+		node, err := lookupJSONPath(raw, imagesPath)
+		if err != nil {
+			return originResp, err
+		}
+		originResp.Images = nil
+		if err := json.Unmarshal(node, &originResp.Images); err != nil {
+			return originResp, fmt.Errorf("%s: %w", imagesPath, err)
+		}
+	}
+	if len(originResp.Images) == 0 && len(originResp.Data) > 0 {
+		originResp.Images, originResp.Data = originResp.Data, nil
+	}
+	originResp.created = upstreamCreated(raw)
+	return originResp, nil
+}
+
+// 上游响应顶层的 created，兼容字符串、小数与毫秒时间戳；缺失或无效时返回 0
+func upstreamCreated(raw json.RawMessage) int64 {
+	var meta struct {
+		Created json.Number `json:"created"`
+	}
+	if json.Unmarshal(raw, &meta) != nil {
+		return 0
+	}
+	v, err := meta.Created.Float64()
+	if err != nil || v <= 0 {
+		return 0
+	}
+	if v > 1e12 {
+		v /= 1000
+	}
+	return int64(v)
+}
+
+// 统一 revised_prompt 的位置：各响应模式都只在图片条目上给出（取自条目本身或首个变体），
+// 变体上的同名字段去掉；omit 为 true 时全部去掉
+func normalizeRevisedPrompts(images []Image, omit bool) {
+	for i := range images {
+		if omit {
+			images[i].RevisedPrompt = ""
+		}
+		for v := range images[i].Variants {
+			images[i].Variants[v].RevisedPrompt = ""
+		}
+	}
+}
+
+// 按点分路径逐层取对象字段，路径段为数字时取数组下标
+func lookupJSONPath(raw json.RawMessage, path string) (json.RawMessage, error) {
+	node := raw
+	for _, key := range strings.Split(path, ".") {
+		if bytes.HasPrefix(bytes.TrimSpace(node), []byte("[")) {
+			var arr []json.RawMessage
+			var i int
+			if _, err := fmt.Sscanf(key, "%d", &i); err != nil {
+				return nil, fmt.Errorf("%s: %q is not an array index", path, key)
+			}
+			if err := json.Unmarshal(node, &arr); err != nil || i < 0 || i >= len(arr) {
+				return nil, fmt.Errorf("%s: index %d out of range", path, i)
+			}
+			node = arr[i]
+			continue
+		}
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(node, &obj); err != nil {
+			return nil, fmt.Errorf("%s: %q is not an object", path, key)
+		}
+		next, ok := obj[key]
+		if !ok {
+			return nil, fmt.Errorf("%s: field %q not found", path, key)
+		}
+		node = next
+	}
+	return node, nil
+}
+
+// 从上游响应头或响应体中取出本次调用的费用，未配置或不存在时返回空字符串
+func upstreamCost(cfg CostConfig, header http.Header, body []byte) string {
+	if cfg.Header != "" {
+		if v := strings.TrimSpace(header.Get(cfg.Header)); v != "" {
+			return v
+		}
+	}
+	if cfg.Field == "" {
+		return ""
+	}
+	var raw json.RawMessage
+	if err := decodeUpstreamJSON(body, &raw); err != nil {
+		return ""
+	}
+	node, err := lookupJSONPath(raw, cfg.Field)
+	if err != nil {
+		return ""
+	}
+	var v interface{}
+	if err := json.Unmarshal(node, &v); err != nil {
+		return ""
+	}
+	switch v := v.(type) {
+	case float64:
+		return strings.TrimSpace(string(node))
+	case string:
+		return v
+	}
+	return ""
+}
+
+// OpenAI 风格的错误体
+type openAIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Param   string `json:"param,omitempty"`
+}
+
+// 上游状态码对应的 OpenAI 错误 type 与 code
+func openAIErrorKind(status int) (typ, code string) {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error", "invalid_api_key"
+	case status == http.StatusForbidden:
+		return "permission_error", "permission_denied"
+	case status == http.StatusNotFound:
+		return "invalid_request_error", "not_found"
+	case status == http.StatusRequestEntityTooLarge:
+		return "invalid_request_error", "request_too_large"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error", "rate_limit_exceeded"
+	case status >= 500:
+		return "server_error", "upstream_error"
+	default:
+		return "invalid_request_error", "invalid_request"
+	}
+}
+
+var (
+	htmlTagPattern    = regexp.MustCompile(`(?s)<(script|style)\b.*?</(script|style)>|<[^>]*>`)
+	whitespacePattern = regexp.MustCompile(`\s+`)
+)
+
+// 从 HTML 错误页中提取一段纯文本，便于放入错误信息
+func htmlSnippet(body []byte, limit int) string {
+	text := htmlTagPattern.ReplaceAllString(string(body), " ")
+	text = strings.TrimSpace(whitespacePattern.ReplaceAllString(html.UnescapeString(text), " "))
+	if r := []rune(text); len(r) > limit {
+		text = string(r[:limit]) + "..."
+	}
+	return text
+}
+
+// 上游返回非 JSON 的错误页（如网关的 HTML 页面）时，按状态码转换为 OpenAI 风格错误；
+// 4xx 原样透传状态码，5xx 统一返回 502
+func writeUpstreamHTMLError(w http.ResponseWriter, status int, body []byte) {
+	typ, code := openAIErrorKind(status)
+	message := fmt.Sprintf("Upstream returned HTTP %d", status)
+	if snippet := htmlSnippet(body, 200); snippet != "" {
+		message += ": " + snippet
+	}
+	if status >= 500 {
+		status = http.StatusBadGateway
+	}
+	writeOpenAIError(w, status, openAIError{Message: message, Type: typ, Code: code})
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, e openAIError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(map[string]openAIError{"error": e})
+}
+
+// OpenAI 错误 type 对应的客户端状态码
+var openAIErrorStatus = map[string]int{
+	"invalid_request_error": http.StatusBadRequest,
+	"authentication_error":  http.StatusUnauthorized,
+	"permission_error":      http.StatusForbidden,
+	"rate_limit_error":      http.StatusTooManyRequests,
+}
+
+// 从上游响应体中取出 error 字段，兼容字符串与 {"message","type","code"} 对象两种写法
+func upstreamBodyError(body []byte) (openAIError, bool) {
+	var probe struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if decodeUpstreamJSON(body, &probe) != nil || len(probe.Error) == 0 || string(probe.Error) == "null" {
+		return openAIError{}, false
+	}
+	var e openAIError
+	var msg string
+	if json.Unmarshal(probe.Error, &msg) == nil {
+		e.Message = msg
+	} else {
+		var obj struct {
+			Message string      `json:"message"`
+			Type    string      `json:"type"`
+			Code    interface{} `json:"code"` // 字符串或数字
+		}
+		if json.Unmarshal(probe.Error, &obj) != nil {
+			return openAIError{}, false
+		}
+		e = openAIError{Message: obj.Message, Type: obj.Type}
+		if obj.Code != nil {
+			e.Code = fmt.Sprint(obj.Code)
+		}
+	}
+	return e, true
+}
+
+// 上游在响应体中报告错误（包括状态码为 200 的情况）时转换为客户端错误：
+// 上游状态码为 4xx/5xx 时按状态码映射，为 2xx 时按错误 type 决定，无法判断时使用 fallback
+func writeUpstreamBodyError(w http.ResponseWriter, upstreamStatus, fallback int, e openAIError) {
+	status := fallback
+	switch {
+	case upstreamStatus >= 500:
+		status = http.StatusBadGateway
+	case upstreamStatus >= 400:
+		status = upstreamStatus
+	default:
+		if s, ok := openAIErrorStatus[e.Type]; ok {
+			status = s
+		} else if e.Code == "rate_limit_exceeded" {
+			status = http.StatusTooManyRequests
+		}
+	}
+	if e.Type == "" || e.Code == "" {
+		typ, code := openAIErrorKind(status)
+		e.Type, e.Code = cmp.Or(e.Type, typ), cmp.Or(e.Code, code)
+	}
+	e.Message = cmp.Or(e.Message, "Upstream reported an error")
+	writeOpenAIError(w, status, e)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUpstreamImagesPath(t *testing.T) {
+	cases := []struct {
+		path string
+		body string
+	}{
+		{"output.images", `{"output":{"images":[{"url":"https://cdn.example/a.png"},{"url":"https://cdn.example/b.png"}]}}`},
+		{"result.0.images", `{"result":[{"images":[{"url":"https://cdn.example/a.png"},{"url":"https://cdn.example/b.png"}]}]}`},
+	}
+	for _, c := range cases {
+		resp, err := parseUpstreamResponse([]byte(c.body), c.path)
+		if err != nil {
+			t.Fatalf("%s: %v", c.path, err)
+		}
+		if len(resp.Images) != 2 || resp.Images[1].URL != "https://cdn.example/b.png" {
+			t.Errorf("%s: images = %+v", c.path, resp.Images)
+		}
+	}
+	if _, err := parseUpstreamResponse([]byte(`{"output":{}}`), "output.images"); err == nil {
+		t.Error("路径不存在时应返回错误")
+	}
+}
+
+func TestUpstreamImagesPathEndToEnd(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"output":{"images":[{"url":"` + img.URL + `/a.png"}]}}`))
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.UpstreamImagesPath = "output.images"
+	})
+
+	resp := decodeB64Response(t, postGenerations(t, `{"prompt":"x","response_format":"b64_json"}`))
+	if len(resp.Data) != 1 || resp.Data[0].B64JSON == "" {
+		t.Fatalf("未按 upstream_images_path 提取图片: %+v", resp.Data)
+	}
+	if w := postGenerations(t, `{"prompt":"x"}`); !strings.Contains(w.Body.String(), img.URL+"/a.png") {
+		t.Errorf("URL 模式未按路径提取图片: %s", w.Body)
+	}
+}
+
+// 响应中每个图片条目的 revised_prompt，以及变体上是否出现该字段
+func revisedPrompts(t *testing.T, body []byte, field string) ([]string, bool) {
+	t.Helper()
+	var resp map[string]json.RawMessage
+	var items []map[string]interface{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("响应不是有效 JSON: %v\n%s", err, body)
+	}
+	if err := json.Unmarshal(resp[field], &items); err != nil {
+		t.Fatalf("%s 不是数组: %v", field, err)
+	}
+	var prompts []string
+	onVariant := false
+	for _, item := range items {
+		p, _ := item["revised_prompt"].(string)
+		prompts = append(prompts, p)
+		variants, _ := item["variants"].([]interface{})
+		for _, v := range variants {
+			if _, ok := v.(map[string]interface{})["revised_prompt"]; ok {
+				onVariant = true
+			}
+		}
+	}
+	return prompts, onVariant
+}
+
+func TestRevisedPromptConsistentAcrossModes(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"images":[{"url":"%[1]s/a.png","revised_prompt":"item prompt"},`+
+			`[{"url":"%[1]s/b.png","type":"original","revised_prompt":"variant prompt"},{"url":"%[1]s/c.png","type":"upscaled","revised_prompt":"variant prompt"}],`+
+			`{"url":"%[1]s/d.png"}]}`, img.URL)
+	})
+	body := `{"prompt":"x","n":3}`
+
+	for _, omit := range []bool{false, true} {
+		useConfig(t, func(c *Config) {
+			c.UpstreamURL = up.URL
+			c.OmitRevisedPrompt = omit
+		})
+		urlPrompts, urlOnVariant := revisedPrompts(t, postGenerations(t, body).Body.Bytes(), "images")
+		b64Prompts, b64OnVariant := revisedPrompts(t, postGenerations(t, strings.Replace(body, `}`, `,"response_format":"b64_json"}`, 1)).Body.Bytes(), "data")
+
+		want := []string{"item prompt", "variant prompt", ""}
+		if omit {
+			want = []string{"", "", ""}
+		}
+		if fmt.Sprint(urlPrompts) != fmt.Sprint(want) || fmt.Sprint(b64Prompts) != fmt.Sprint(want) {
+			t.Errorf("omit=%v: URL 模式 %q，b64 模式 %q，want %q", omit, urlPrompts, b64Prompts, want)
+		}
+		if urlOnVariant || b64OnVariant {
+			t.Errorf("omit=%v: 变体上不应出现 revised_prompt", omit)
+		}
+	}
+}
+
+func TestUpstreamCostRelayed(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("header") != "" {
+			w.Header().Set("X-Credits-Used", "0.5")
+		}
+		fmt.Fprintf(w, `{"images":[{"url":"%s/a.png"}],"usage":{"cost":0.021}}`, img.URL)
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Cost = CostConfig{Header: "X-Credits-Used", Field: "usage.cost"}
+	})
+
+	w := postGenerations(t, `{"prompt":"x","response_format":"b64_json"}`)
+	if got := w.Header().Get("X-Cost"); got != "0.021" {
+		t.Errorf("X-Cost = %q, want 0.021", got)
+	}
+	if resp := decodeB64Response(t, w); resp.Usage == nil || resp.Usage.Cost != "0.021" {
+		t.Errorf("usage.cost = %+v, want 0.021", resp.Usage)
+	}
+
+	// 响应头优先于响应体
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL + "?header=1"
+		c.Cost = CostConfig{Header: "X-Credits-Used", Field: "usage.cost"}
+	})
+	if got := postGenerations(t, `{"prompt":"x","response_format":"b64_json"}`).Header().Get("X-Cost"); got != "0.5" {
+		t.Errorf("X-Cost = %q, want 响应头中的 0.5", got)
+	}
+}
+
+func TestUpstreamBOMStripped(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "\xef\xbb\xbf \r\n{\"images\":[{\"url\":%q}]}\n\n", img.URL+"/a.png")
+	})
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+
+	w := postGenerations(t, `{"prompt":"x","response_format":"b64_json"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("带 BOM 的上游响应 status = %d, body = %s", w.Code, w.Body)
+	}
+	if resp := decodeB64Response(t, w); len(resp.Data) != 1 || resp.Data[0].B64JSON == "" {
+		t.Errorf("data = %+v", resp.Data)
+	}
+	if got := sanitizeUpstreamBody([]byte("\xef\xbb\xbf\xef\xbb\xbf {} ")); string(got) != "{}" {
+		t.Errorf("sanitizeUpstreamBody = %q, want {}", got)
+	}
+}
+
+func TestUpstreamHTMLErrorMapped(t *testing.T) {
+	cases := []struct {
+		status     int
+		wantStatus int
+		typ, code  string
+	}{
+		{http.StatusUnauthorized, http.StatusUnauthorized, "authentication_error", "invalid_api_key"},
+		{http.StatusTooManyRequests, http.StatusTooManyRequests, "rate_limit_error", "rate_limit_exceeded"},
+		{http.StatusServiceUnavailable, http.StatusBadGateway, "server_error", "upstream_error"},
+	}
+	for _, c := range cases {
+		up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(c.status)
+			fmt.Fprintf(w, "<html><head><style>body{}</style></head><body><h1>%d %s</h1><hr>nginx</body></html>", c.status, http.StatusText(c.status))
+		})
+		useConfig(t, func(cfg *Config) { cfg.UpstreamURL = up.URL })
+
+		w := postGenerations(t, `{"prompt":"x"}`)
+		if w.Code != c.wantStatus {
+			t.Errorf("上游 %d: status = %d, want %d", c.status, w.Code, c.wantStatus)
+		}
+		var resp struct {
+			Error openAIError `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("上游 %d: 响应不是 JSON: %s", c.status, w.Body)
+		}
+		if resp.Error.Type != c.typ || resp.Error.Code != c.code {
+			t.Errorf("上游 %d: type/code = %s/%s, want %s/%s", c.status, resp.Error.Type, resp.Error.Code, c.typ, c.code)
+		}
+		want := fmt.Sprintf("Upstream returned HTTP %d: %d %s nginx", c.status, c.status, http.StatusText(c.status))
+		if resp.Error.Message != want {
+			t.Errorf("上游 %d: message = %q, want %q", c.status, resp.Error.Message, want)
+		}
+	}
+}
+
+func TestUpstream200ErrorBody(t *testing.T) {
+	cases := []struct {
+		body       string
+		wantStatus int
+		want       openAIError
+	}{
+		{`{"error":{"message":"Invalid prompt","type":"invalid_request_error","code":20015}}`,
+			http.StatusBadRequest, openAIError{Message: "Invalid prompt", Type: "invalid_request_error", Code: "20015"}},
+		{`{"error":"quota exhausted"}`,
+			http.StatusBadGateway, openAIError{Message: "quota exhausted", Type: "server_error", Code: "upstream_error"}},
+	}
+	for _, c := range cases {
+		up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(c.body))
+		})
+		useConfig(t, func(cfg *Config) { cfg.UpstreamURL = up.URL })
+
+		w := postGenerations(t, `{"prompt":"x","response_format":"b64_json"}`)
+		if w.Code != c.wantStatus {
+			t.Errorf("%s: status = %d, want %d", c.body, w.Code, c.wantStatus)
+		}
+		var resp struct {
+			Error openAIError `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: 响应不是 JSON: %s", c.body, w.Body)
+		}
+		if resp.Error != c.want {
+			t.Errorf("%s: error = %+v, want %+v", c.body, resp.Error, c.want)
+		}
+	}
+}
+
+func TestUpstreamTruncatedResponse(t *testing.T) {
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		// 声明的长度大于实际发送的字节数，发送一半后直接断开连接
+		body := `{"images":[{"url":"https://cdn.example/a.png"}]}`
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(body[:len(body)/2]))
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	})
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+
+	before := testutil.ToFloat64(upstreamTruncatedTotal)
+	w := postGenerations(t, `{"prompt":"x"}`)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("截断的上游响应 status = %d, want 504, body = %s", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), "Upstream response truncated") {
+		t.Errorf("错误信息 = %s", w.Body)
+	}
+	if got := testutil.ToFloat64(upstreamTruncatedTotal) - before; got != 1 {
+		t.Errorf("sc_proxy_upstream_truncated_total 增加了 %v, want 1", got)
+	}
+
+	// 完整但无法解析的响应仍按 500 处理，不计入截断
+	bad := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`not json`))
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = bad.URL
+		c.UpstreamTruncatedStatus = http.StatusServiceUnavailable
+	})
+	if w := postGenerations(t, `{"prompt":"x"}`); w.Code == http.StatusServiceUnavailable {
+		t.Errorf("无法解析的响应不应按截断处理: %d %s", w.Code, w.Body)
+	}
+	if got := testutil.ToFloat64(upstreamTruncatedTotal) - before; got != 1 {
+		t.Errorf("无法解析的响应不应计入截断，计数增加了 %v", got)
+	}
+}
+
+func TestUpstreamCreatedUsed(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 2, 2))
+	cases := []struct {
+		created string
+		use     bool
+		want    int64
+	}{
+		{`1735000000`, true, 1735000000},
+		{`"1735000000"`, true, 1735000000},
+		{`1735000000123`, true, 1735000000},
+		{`1735000000`, false, 0},
+		{`"soon"`, true, 0},
+	}
+	for _, c := range cases {
+		up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"images":[{"url":"%s/a.png"}],"created":%s}`, img.URL, c.created)
+		})
+		useConfig(t, func(cfg *Config) {
+			cfg.UpstreamURL = up.URL
+			cfg.UseUpstreamCreated = c.use
+		})
+		before := time.Now().Unix()
+		resp := decodeB64Response(t, postGenerations(t, `{"prompt":"x","response_format":"b64_json"}`))
+		if c.want != 0 {
+			if resp.Created != c.want {
+				t.Errorf("created=%s: 响应 created = %d, want %d", c.created, resp.Created, c.want)
+			}
+		} else if resp.Created < before || resp.Created > time.Now().Unix() {
+			t.Errorf("created=%s use=%v: 响应 created = %d, want 当前时间", c.created, c.use, resp.Created)
+		}
+	}
+}
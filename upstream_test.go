@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSeedsSplitIntoUpstreamCalls(t *testing.T) {
+	var mu sync.Mutex
+	var calls []map[string]interface{}
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		calls = append(calls, body)
+		mu.Unlock()
+		seed := body["seed"].(float64)
+		// 先发起的调用晚返回，检验结果按 seeds 顺序合并
+		time.Sleep(time.Duration(30-int(seed)*10) * time.Millisecond)
+		fmt.Fprintf(w, `{"images":[{"url":"https://cdn.example/%v.png"}],"seed":%v}`, seed, seed)
+	})
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+
+	w := postGenerations(t, `{"prompt":"x","seeds":[1,2,3]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	if len(calls) != 3 {
+		t.Fatalf("上游调用次数 = %d, want 3", len(calls))
+	}
+	var seen []float64
+	for _, c := range calls {
+		if c["n"] != float64(1) {
+			t.Errorf("拆分后的调用 n = %v, want 1", c["n"])
+		}
+		if _, ok := c["seeds"]; ok {
+			t.Error("seeds 不应转发给上游")
+		}
+		seen = append(seen, c["seed"].(float64))
+	}
+	slices.Sort(seen)
+	if !slices.Equal(seen, []float64{1, 2, 3}) {
+		t.Errorf("上游收到的 seed = %v, want [1 2 3]", seen)
+	}
+
+	var resp struct {
+		Images []struct {
+			URL string `json:"url"`
+		} `json:"images"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	var urls []string
+	for _, img := range resp.Images {
+		urls = append(urls, img.URL)
+	}
+	want := []string{"https://cdn.example/1.png", "https://cdn.example/2.png", "https://cdn.example/3.png"}
+	if !slices.Equal(urls, want) {
+		t.Errorf("结果顺序 = %q, want %q", urls, want)
+	}
+}
+
+func TestSeedsLengthMustMatchN(t *testing.T) {
+	up := newUpstream(t, []string{"https://cdn.example/a.png"}, nil)
+	useConfig(t, func(c *Config) { c.UpstreamURL = up.URL })
+	for _, body := range []string{
+		`{"prompt":"x","n":2,"seeds":[1,2,3]}`,
+		`{"prompt":"x","seeds":[1,"a"]}`,
+	} {
+		if w := postGenerations(t, body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, w.Code)
+		}
+	}
+}
+
+func TestUpstreamResponseSizeCapped(t *testing.T) {
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"images":[{"url":"https://cdn.example/a.png"}],"padding":%q}`, strings.Repeat("x", 4096))
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.MaxUpstreamResponseBytes = 1024
+	})
+
+	w := postGenerations(t, `{"prompt":"x"}`)
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Upstream response exceeds 1024 bytes") {
+		t.Errorf("错误信息应说明超出上限: %s", w.Body)
+	}
+
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.MaxUpstreamResponseBytes = 8192
+	})
+	if w := postGenerations(t, `{"prompt":"x"}`); w.Code != http.StatusOK {
+		t.Errorf("未超过上限时 status = %d, want 200", w.Code)
+	}
+}
+
+func TestUpstreamOverUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "upstream.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("无法创建 Unix 域套接字: %v", err)
+	}
+	var host, path string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, path = r.Host, r.URL.Path
+		w.Write([]byte(`{"images":[{"url":"https://cdn.example/a.png"}]}`))
+	}))
+	srv.Listener = ln
+	srv.Start()
+	defer srv.Close()
+
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = "http://sidecar.internal/v1/images/generations"
+		c.UpstreamSocket = sock
+	})
+	w := postGenerations(t, `{"prompt":"x"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "https://cdn.example/a.png") {
+		t.Fatalf("经 socket 调用上游失败: status = %d, body = %s", w.Code, w.Body)
+	}
+	if host != "sidecar.internal" || path != "/v1/images/generations" {
+		t.Errorf("上游收到 Host = %q, path = %q", host, path)
+	}
+}
+
+func TestUpstreamSizeField(t *testing.T) {
+	for _, field := range []string{"image_size", "resolution", "size"} {
+		var forwarded map[string]interface{}
+		up := newUpstream(t, []string{"https://cdn.example/a.png"}, &forwarded)
+		useConfig(t, func(c *Config) {
+			c.UpstreamURL = up.URL
+			c.UpstreamSizeField = field
+		})
+		if w := postGenerations(t, `{"prompt":"x","size":"512x768"}`); w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", field, w.Code, w.Body)
+		}
+		if forwarded[field] != "512x768" {
+			t.Errorf("%s: 上游请求体 = %v, want %s=512x768", field, forwarded, field)
+		}
+		if _, ok := forwarded["size"]; ok && field != "size" {
+			t.Errorf("%s: size 应改写为 %s 后再转发: %v", field, field, forwarded)
+		}
+	}
+}
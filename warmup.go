@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 预热：向上游发起不产生生成任务的 HEAD 请求，建立并保留连接
+func warmUpstream(ctx context.Context, cfg *Config, header http.Header) error {
+	start := time.Now()
+	client := upstreamClient(cfg)
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, upstreamURL(cfg), nil)
+	if err != nil {
+		return err
+	}
+	if auth := upstreamAuthorization(cfg, header); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	// 读完响应体才能让连接回到连接池
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	log.Printf("[WARMUP] 上游连接已预热，状态码: %d, 耗时: %v", resp.StatusCode, time.Since(start))
+	return nil
+}
+
+// 启动及配置热加载后按 prewarm_connections 并发预热，预先建立多条上游连接，首个请求无需再握手
+func prewarmUpstream(cfg *Config) {
+	n := cfg.PrewarmConnections
+	if n <= 0 {
+		return
+	}
+	ctx := context.Background()
+	if cfg.UpstreamTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.UpstreamTimeout))
+		defer cancel()
+	}
+	var ok atomic.Int32
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := warmUpstream(ctx, cfg, nil); err != nil {
+				log.Printf("[WARN] 上游连接预热失败: %v", err)
+				return
+			}
+			ok.Add(1)
+		}()
+	}
+	wg.Wait()
+	log.Printf("[WARMUP] 连接池预热完成: %d/%d", ok.Load(), n)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWarmupReturns204WithoutGenerating(t *testing.T) {
+	var downloads atomic.Int64
+	img := newCountingImageServer(t, testPNG(t, 2, 2), &downloads)
+	var mu sync.Mutex
+	var methods []string
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method+" "+r.Header.Get("Authorization"))
+		mu.Unlock()
+		fmt.Fprintf(w, `{"images":[{"url":%q}]}`, img.URL+"/a.png")
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.AllowWarmup = true
+	})
+
+	w := postGenerations(t, `{"warmup":true,"prompt":"x"}`, "Authorization", "Bearer sk-1")
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Fatalf("status = %d, body = %q, want 204 无响应体", w.Code, w.Body)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(methods) != 1 || methods[0] != "HEAD Bearer sk-1" {
+		t.Fatalf("预热应只向上游发起一次带鉴权的 HEAD，实际: %v", methods)
+	}
+	if n := downloads.Load(); n != 0 {
+		t.Errorf("预热不应下载图片，实际下载 %d 次", n)
+	}
+}
+
+func TestWarmupDisabled(t *testing.T) {
+	useConfig(t, func(c *Config) { c.UpstreamURL = "http://127.0.0.1:1" })
+	if w := postGenerations(t, `{"warmup":true}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("未开启 allow_warmup 时 status = %d, want 400", w.Code)
+	}
+}
+
+func TestPrewarmConnectionsAtStartup(t *testing.T) {
+	var newConns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			// 让预热请求同时在途，各自占用一条连接
+			time.Sleep(50 * time.Millisecond)
+			return
+		}
+		w.Write([]byte(`{"images":[{"url":"https://cdn.example/a.png"}]}`))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			newConns.Add(1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	cfg := useConfig(t, func(c *Config) {
+		c.UpstreamURL = srv.URL
+		c.PrewarmConnections = 4
+	})
+
+	prewarmUpstream(cfg)
+	if got := newConns.Load(); got != 4 {
+		t.Fatalf("预热后建立了 %d 条连接, want 4", got)
+	}
+	// 随后的请求复用预热的连接，不再新建
+	for range 4 {
+		if w := postGenerations(t, `{"prompt":"x"}`); w.Code != http.StatusOK {
+			t.Fatalf("status = %d", w.Code)
+		}
+	}
+	if got := newConns.Load(); got != 4 {
+		t.Errorf("预热后的请求新建了 %d 条连接, want 0", got-4)
+	}
+
+	useConfig(t, func(c *Config) { c.UpstreamURL = srv.URL })
+	prewarmUpstream(currentConfig())
+	if got := newConns.Load(); got != 4 {
+		t.Errorf("未开启预热时不应建立连接，连接数 %d", got)
+	}
+}
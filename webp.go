@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+var errWebPUnavailable = errors.New("webp encoder unavailable")
+
+// WebP 编码器，调用外部 cwebp；Go 标准库与 x/image 只提供 WebP 解码
+type webpEncoder struct {
+	path    string // 可执行文件路径，为空表示未找到编码器
+	quality int
+}
+
+// 开启 WebP 输出时为非 nil
+var webpOutput *webpEncoder
+
+func newWebPEncoder(cfg WebPConfig) *webpEncoder {
+	if !cfg.Enabled {
+		return nil
+	}
+	path, err := exec.LookPath(cfg.Encoder)
+	if err != nil {
+		log.Printf("[WARN] 未找到 WebP 编码器 %s，WebP 输出将回退为 PNG: %v", cfg.Encoder, err)
+		path = ""
+	}
+	return &webpEncoder{path: path, quality: cfg.Quality}
+}
+
+func (e *webpEncoder) encode(img image.Image) ([]byte, error) {
+	if e == nil || e.path == "" {
+		return nil, errWebPUnavailable
+	}
+	dir, err := os.MkdirTemp("", "sc-webp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	in, out := filepath.Join(dir, "in.png"), filepath.Join(dir, "out.webp")
+	if err := os.WriteFile(in, buf.Bytes(), 0o600); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(e.path, "-quiet", "-q", strconv.Itoa(e.quality), in, "-o", out)
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("cwebp: %w: %s", err, bytes.TrimSpace(msg))
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		return nil, err
+	}
+	if detectFormat(data) != "webp" {
+		return nil, errors.New("cwebp produced no webp output")
+	}
+	return data, nil
+}
+
+// 编码为 WebP，编码器不可用或编码失败时回退为 PNG
+func encodeWebPOrPNG(img image.Image) ([]byte, error) {
+	data, err := webpOutput.encode(img)
+	if err == nil {
+		return data, nil
+	}
+	log.Printf("[WARN] WebP 编码失败，回退为 PNG: %v", err)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
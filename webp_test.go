@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 1x1 无损 WebP
+const tinyWebP = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+// 写一个假的 cwebp：把固定的 WebP 写到 -o 指定的路径；fail 为 true 时直接退出 1
+func fakeCWebP(t *testing.T, fail bool) string {
+	t.Helper()
+	script := "#!/bin/sh\nwhile [ $# -gt 0 ]; do if [ \"$1\" = -o ]; then out=$2; fi; shift; done\n" +
+		"echo " + tinyWebP + " | base64 -d > \"$out\"\n"
+	if fail {
+		script = "#!/bin/sh\necho 'Error! Cannot encode picture' >&2\nexit 1\n"
+	}
+	path := filepath.Join(t.TempDir(), "cwebp")
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWebPOutputWithPNGFallback(t *testing.T) {
+	src := testPNG(t, 4, 4)
+	cases := []struct {
+		name    string
+		encoder string
+		want    string
+	}{
+		{"编码成功", fakeCWebP(t, false), "webp"},
+		{"编码失败", fakeCWebP(t, true), "png"},
+		{"找不到编码器", filepath.Join(t.TempDir(), "missing-cwebp"), "png"},
+	}
+	for _, c := range cases {
+		swapGlobal(t, &webpOutput, newWebPEncoder(WebPConfig{Enabled: true, Quality: 75, Encoder: c.encoder}))
+		out, err := convertImage(src, "webp")
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := detectFormat(out); got != c.want {
+			t.Errorf("%s: 输出格式 = %q, want %q", c.name, got, c.want)
+		}
+		if _, _, err := image.Decode(bytes.NewReader(out)); err != nil {
+			t.Errorf("%s: 输出无法解码: %v", c.name, err)
+		}
+	}
+}
+
+func TestWebPStoredOutputFormat(t *testing.T) {
+	img := newImageServer(t, testPNG(t, 4, 4))
+	up := newUpstream(t, []string{img.URL + "/a.png"}, nil)
+	cfg := useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.Storage.Enabled = true
+		c.Storage.Dir = t.TempDir()
+		c.Storage.PublicBaseURL = "http://proxy.example"
+	})
+	useStorage(t, cfg)
+
+	swapGlobal(t, &webpOutput, nil)
+	if w := postGenerations(t, `{"prompt":"x","output_format":"webp"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("未开启 webp 时 status = %d, want 400", w.Code)
+	}
+
+	for encoder, ext := range map[string]string{fakeCWebP(t, false): ".webp", fakeCWebP(t, true): ".png"} {
+		swapGlobal(t, &webpOutput, newWebPEncoder(WebPConfig{Enabled: true, Quality: 80, Encoder: encoder}))
+		resp := decodeURLResponse(t, postGenerations(t, `{"prompt":"x","output_format":"webp"}`))
+		if len(resp.Data) != 1 || !strings.HasSuffix(resp.Data[0].URL, ext) {
+			t.Errorf("扩展名应为 %s: %+v", ext, resp.Data)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"archive/zip"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// 客户端通过 Accept: application/zip 请求把全部图片打包为一个 ZIP
+func wantsZip(r *http.Request) bool {
+	return acceptsMediaType(r, "application/zip")
+}
+
+// ZIP 顶层 manifest.json 中的单张图片
+type zipManifestItem struct {
+	Index  int    `json:"index"`
+	Prompt string `json:"prompt,omitempty"`
+	File   string `json:"file,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type zipManifest struct {
+	Created int64             `json:"created"`
+	Layout  string            `json:"layout"`
+	Seed    string            `json:"seed,omitempty"`
+	Images  []zipManifestItem `json:"images"`
+}
+
+// 提示词转换为目录名：保留字母数字，其余字符折叠为 -，过长时截断
+func promptDirName(prompt string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(prompt) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+		if b.Len() >= 48 {
+			break
+		}
+	}
+	return cmp.Or(strings.Trim(b.String(), "-"), "prompt")
+}
+
+// 以 ZIP 返回全部主变体图片与 manifest.json。layout 为 nested 时按提示词（上游改写过的
+// revised_prompt 优先）分到各自的子目录，flat 时全部放在根目录
+func writeZipArchive(w http.ResponseWriter, layout, prompt, seed string, images []Image, slots [][]imageSlot) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="images.zip"`)
+	zw := zip.NewWriter(w)
+
+	manifest := zipManifest{Created: time.Now().Unix(), Layout: layout, Seed: seed, Images: make([]zipManifestItem, len(images))}
+	dirs := make(map[string]string) // 提示词 → 目录名
+	used := make(map[string]bool)
+	for i, img := range images {
+		itemPrompt := cmp.Or(img.RevisedPrompt, prompt)
+		item := zipManifestItem{Index: i, Prompt: itemPrompt, Error: slots[i][0].err}
+		if item.Error == "" {
+			ext, _ := formatInfo(slots[i][0].data)
+			item.File = fmt.Sprintf("image-%d%s", i, ext)
+			if layout == "nested" {
+				dir, ok := dirs[itemPrompt]
+				if !ok {
+					dir = promptDirName(itemPrompt)
+					for n := 2; used[dir]; n++ {
+						dir = fmt.Sprintf("%s-%d", promptDirName(itemPrompt), n)
+					}
+					dirs[itemPrompt], used[dir] = dir, true
+				}
+				item.File = path.Join(dir, item.File)
+			}
+			f, err := zw.CreateHeader(&zip.FileHeader{Name: item.File, Method: zip.Store, Modified: time.Now()})
+			if err != nil {
+				log.Printf("[ERROR] 写入 ZIP 条目失败: %v", err)
+				return
+			}
+			f.Write(slots[i][0].data)
+		}
+		manifest.Images[i] = item
+	}
+
+	f, err := zw.Create("manifest.json")
+	if err != nil {
+		log.Printf("[ERROR] 写入 ZIP manifest 失败: %v", err)
+		return
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	enc.Encode(manifest)
+	if err := zw.Close(); err != nil {
+		log.Printf("[ERROR] 关闭 ZIP 响应失败: %v", err)
+	}
+	log.Printf("[SUCCESS] 以 ZIP 返回 - 图片数量: %d", countDownloaded(slots))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestZipNestedLayout(t *testing.T) {
+	png := testPNG(t, 2, 2)
+	img := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(png)
+	})
+	up := newUpstreamFunc(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"images":[{"url":"%[1]s/a.png","revised_prompt":"A red cat!"},{"url":"%[1]s/b.png","revised_prompt":"A red cat!"},`+
+			`{"url":"%[1]s/c.png","revised_prompt":"a blue dog"},{"url":"%[1]s/missing.png","revised_prompt":"a blue dog"}],"seed":42}`, img.URL)
+	})
+	useConfig(t, func(c *Config) {
+		c.UpstreamURL = up.URL
+		c.ZipLayout = "nested"
+	})
+
+	w := postGenerations(t, `{"prompt":"cats and dogs","n":4}`, "Accept", "application/zip")
+	if ct := w.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Fatalf("Content-Type = %q, body = %s", ct, w.Body)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	var names []string
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+		names = append(names, f.Name)
+	}
+	want := []string{"a-red-cat/image-0.png", "a-red-cat/image-1.png", "a-blue-dog/image-2.png", "manifest.json"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("ZIP 条目 = %v, want %v", names, want)
+	}
+	if !bytes.Equal(files["a-blue-dog/image-2.png"], png) {
+		t.Error("ZIP 中的图片与下载的内容不一致")
+	}
+
+	var manifest zipManifest
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Layout != "nested" || manifest.Seed != "42" || len(manifest.Images) != 4 {
+		t.Fatalf("manifest = %+v", manifest)
+	}
+	wantItems := []zipManifestItem{
+		{Index: 0, Prompt: "A red cat!", File: "a-red-cat/image-0.png"},
+		{Index: 1, Prompt: "A red cat!", File: "a-red-cat/image-1.png"},
+		{Index: 2, Prompt: "a blue dog", File: "a-blue-dog/image-2.png"},
+	}
+	if !slices.Equal(manifest.Images[:3], wantItems) {
+		t.Errorf("manifest.images = %+v, want %+v", manifest.Images[:3], wantItems)
+	}
+	if last := manifest.Images[3]; last.File != "" || last.Error == "" {
+		t.Errorf("下载失败的位置应只在 manifest 中记录错误: %+v", last)
+	}
+}
+
+func TestPromptDirName(t *testing.T) {
+	if got := promptDirName("  Hello, World!! "); got != "hello-world" {
+		t.Errorf("promptDirName = %q", got)
+	}
+	if got := promptDirName("!!!"); got != "prompt" {
+		t.Errorf("无字母数字时 promptDirName = %q, want prompt", got)
+	}
+}